@@ -0,0 +1,110 @@
+package traefik_plugin_geoblock
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ip2locationNotSupported is the prefix of the value ip2location returns for fields not contained in a database.
+const ip2locationNotSupported = "This parameter is unavailable"
+
+// ASNInfo holds the autonomous system information of an IP address.
+type ASNInfo struct {
+	Number       uint   // Autonomous system number, 0 if unknown
+	Organization string // Name of the organization / ISP operating the network
+}
+
+// initASNs parses a list of autonomous system numbers, with or without "AS" prefix.
+func initASNs(asns []string) (map[uint]struct{}, error) {
+	asnSet := make(map[uint]struct{}, len(asns))
+
+	for _, asn := range asns {
+		trimmed := strings.TrimSpace(asn)
+		if len(trimmed) > 2 && strings.EqualFold(trimmed[:2], "AS") {
+			trimmed = trimmed[2:]
+		}
+
+		number, err := strconv.ParseUint(trimmed, 10, 32)
+		if err != nil || number == 0 {
+			return nil, fmt.Errorf("%q is not a valid autonomous system number", asn)
+		}
+		asnSet[uint(number)] = struct{}{}
+	}
+
+	return asnSet, nil
+}
+
+// initISPPatterns normalizes and validates a list of ISP name patterns.
+func initISPPatterns(patterns []string) ([]string, error) {
+	var normalized []string
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid ISP pattern %q: %w", pattern, err)
+		}
+		normalized = append(normalized, pattern)
+	}
+
+	return normalized, nil
+}
+
+// matchesISPPattern indicates whether the given ISP name matches any of the given patterns.
+// Patterns use shell glob syntax (e.g. "*hosting*") and are matched case-insensitively.
+func matchesISPPattern(isp string, patterns []string) bool {
+	isp = strings.ToLower(isp)
+
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, isp); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LookupASN queries the configured databases for the autonomous system information of a given IP address.
+// The AS number is only available when an ASN database is configured, in which case it also provides
+// the organization name. Otherwise the ISP name is read from the ip2location database.
+func (p Plugin) LookupASN(ip string) (ASNInfo, error) {
+	if p.asnDB != nil {
+		ipAddress := net.ParseIP(ip)
+		if ipAddress == nil {
+			return ASNInfo{}, fmt.Errorf("unable parse IP address from address [%s]", ip)
+		}
+
+		value, err := p.asnDB.lookup(ipAddress)
+		if err != nil {
+			return ASNInfo{}, err
+		}
+
+		record, _ := value.(map[string]interface{})
+		organization, _ := record["autonomous_system_organization"].(string)
+
+		return ASNInfo{
+			Number:       mmdbUint(record["autonomous_system_number"]),
+			Organization: organization,
+		}, nil
+	}
+
+	record, err := p.db.Get_isp(ip)
+	if err != nil {
+		return ASNInfo{}, err
+	}
+
+	if strings.HasPrefix(strings.ToLower(record.Isp), "invalid") {
+		return ASNInfo{}, errors.New(record.Isp)
+	}
+	if strings.HasPrefix(record.Isp, ip2locationNotSupported) || record.Isp == "-" {
+		return ASNInfo{}, nil
+	}
+
+	return ASNInfo{Organization: record.Isp}, nil
+}
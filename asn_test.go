@@ -0,0 +1,119 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestInitASNs(t *testing.T) {
+	asns, err := initASNs([]string{"AS15169", "as24940", " 16509 "})
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	for _, asn := range []uint{15169, 24940, 16509} {
+		if _, ok := asns[asn]; !ok {
+			t.Errorf("expected ASN %d to be parsed", asn)
+		}
+	}
+
+	for _, invalid := range []string{"", "AS", "ASfoo", "0", "-1", "4294967296"} {
+		if _, err = initASNs([]string{invalid}); err == nil {
+			t.Errorf("expected error for %q, but got none", invalid)
+		}
+	}
+}
+
+func TestMatchesISPPattern(t *testing.T) {
+	patterns, err := initISPPatterns([]string{"*Hosting*", "Hetzner Online GmbH", " "})
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	if !matchesISPPattern("Some Hosting Company", patterns) {
+		t.Error("expected wildcard pattern to match")
+	}
+	if !matchesISPPattern("HETZNER ONLINE GMBH", patterns) {
+		t.Error("expected exact pattern to match case-insensitively")
+	}
+	if matchesISPPattern("Deutsche Telekom AG", patterns) {
+		t.Error("expected no pattern to match")
+	}
+
+	if _, err = initISPPatterns([]string{"[invalid"}); err == nil {
+		t.Error("expected error, but got none")
+	}
+}
+
+func TestPlugin_ServeHTTP_ASN(t *testing.T) {
+	asnDBFilePath := writeTestASNDatabase(t)
+
+	t.Run("NoASNDatabase", func(t *testing.T) {
+		cfg := &Config{
+			Enabled:              true,
+			DatabaseFilePath:     dbFilePath,
+			BlockedASNs:          []string{"AS15169"},
+			DisallowedStatusCode: http.StatusForbidden,
+		}
+
+		plugin, err := New(context.TODO(), &noopHandler{}, cfg, pluginName)
+		if err == nil {
+			t.Errorf("expected error, but got none")
+		}
+		if plugin != nil {
+			t.Error("expected plugin to be nil, but is not")
+		}
+	})
+
+	t.Run("BlockedASN", func(t *testing.T) {
+		cfg := &Config{
+			Enabled:              true,
+			DatabaseFilePath:     dbFilePath,
+			ASNDatabaseFilePath:  asnDBFilePath,
+			AllowedCountries:     []string{"US", "DE"},
+			BlockedASNs:          []string{"AS24940"},
+			DisallowedStatusCode: http.StatusForbidden,
+		}
+
+		testRequest(t, "Blocked ASN in allowed country", cfg, "185.5.82.105", http.StatusForbidden)
+		testRequest(t, "Other ASN in allowed country", cfg, "8.8.8.8", http.StatusTeapot)
+
+		cfg.AllowedIPBlocks = []string{"185.5.82.105/32"}
+
+		testRequest(t, "IP CIDR allow trumps ASN block", cfg, "185.5.82.105", http.StatusTeapot)
+	})
+
+	t.Run("AllowedASN", func(t *testing.T) {
+		cfg := &Config{
+			Enabled:              true,
+			DatabaseFilePath:     dbFilePath,
+			ASNDatabaseFilePath:  asnDBFilePath,
+			BlockedCountries:     []string{"US"},
+			AllowedASNs:          []string{"15169"},
+			DefaultAllow:         true,
+			DisallowedStatusCode: http.StatusForbidden,
+		}
+
+		testRequest(t, "Allowed ASN in blocked country", cfg, "8.8.8.8", http.StatusTeapot)
+		testRequest(t, "Unknown ASN in blocked country", cfg, "1.1.1.1", http.StatusForbidden)
+
+		cfg.BlockedIPBlocks = []string{"8.8.8.0/24"}
+
+		testRequest(t, "IP CIDR block trumps ASN allow", cfg, "8.8.8.8", http.StatusForbidden)
+	})
+
+	t.Run("BlockedISP", func(t *testing.T) {
+		cfg := &Config{
+			Enabled:              true,
+			DatabaseFilePath:     dbFilePath,
+			ASNDatabaseFilePath:  asnDBFilePath,
+			BlockedISPs:          []string{"hetzner*"},
+			DefaultAllow:         true,
+			DisallowedStatusCode: http.StatusForbidden,
+		}
+
+		testRequest(t, "Blocked ISP", cfg, "185.5.82.105", http.StatusForbidden)
+		testRequest(t, "Other ISP", cfg, "8.8.8.8", http.StatusTeapot)
+	})
+}
@@ -0,0 +1,166 @@
+package traefik_plugin_geoblock
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reasonBypassPrefix is followed by the ID of the bypass key that allowed a request, e.g. "bypass:partner-a".
+const reasonBypassPrefix = "bypass:"
+
+// expiredBypassKeyLogInterval is how often the use of the same expired bypass key is logged at most.
+const expiredBypassKeyLogInterval = time.Hour
+
+// BypassKeyConfig defines a key allowing requests to skip the geo check when sent in the bypass header.
+type BypassKeyConfig struct {
+	ID      string   // Identifier of the key, recorded in decisions (e.g. partner-a)
+	SHA256  string   // Hex encoded SHA-256 hash of the key
+	Paths   []string // Request paths the key is valid for, as prefixes or glob patterns (default: all paths)
+	Expires string   // Date (2026-12-31, valid through the end of the day in UTC) or RFC 3339 timestamp the key expires at
+}
+
+// bypassKey is a compiled bypass key.
+type bypassKey struct {
+	id      string
+	hash    []byte
+	paths   []string
+	expires time.Time
+}
+
+// bypassKeys holds the keys accepted in the bypass header.
+type bypassKeys struct {
+	header string
+	keys   []bypassKey
+	logf   func(format string, args ...interface{})
+
+	mu            sync.Mutex
+	expiredLogged map[string]time.Time // Time the use of an expired key was last logged, by key ID
+}
+
+// initBypassKeys compiles the configured bypass keys. Keys that already expired are logged, but not rejected,
+// so a configuration doesn't stop working once a key expires.
+func initBypassKeys(header string, keys []BypassKeyConfig, logf func(format string, args ...interface{})) (*bypassKeys, error) {
+	header = strings.TrimSpace(header)
+	if header == "" && len(keys) == 0 {
+		return nil, nil
+	}
+	if header == "" {
+		return nil, errors.New("bypass keys require a bypass header")
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("bypass header %s requires bypass keys", header)
+	}
+
+	b := &bypassKeys{
+		header:        http.CanonicalHeaderKey(header),
+		keys:          make([]bypassKey, 0, len(keys)),
+		logf:          logf,
+		expiredLogged: make(map[string]time.Time),
+	}
+	seen := make(map[string]struct{}, len(keys))
+	seenHashes := make(map[string]string, len(keys))
+
+	for i, keyCfg := range keys {
+		id := strings.TrimSpace(keyCfg.ID)
+		if id == "" {
+			return nil, fmt.Errorf("bypass key #%d: missing id", i+1)
+		}
+		if _, ok := seen[id]; ok {
+			return nil, fmt.Errorf("bypass key %q: defined more than once", id)
+		}
+		seen[id] = struct{}{}
+
+		hash, err := hex.DecodeString(strings.TrimSpace(keyCfg.SHA256))
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("bypass key %q: sha256 must be a hex encoded SHA-256 hash", id)
+		}
+		if other, ok := seenHashes[string(hash)]; ok {
+			return nil, fmt.Errorf("bypass key %q: same key as %q", id, other)
+		}
+		seenHashes[string(hash)] = id
+
+		for _, pattern := range keyCfg.Paths {
+			if _, err := path.Match(pattern, ""); err != nil || !strings.HasPrefix(pattern, "/") {
+				return nil, fmt.Errorf("bypass key %q: invalid path pattern %q", id, pattern)
+			}
+		}
+
+		key := bypassKey{id: id, hash: hash, paths: keyCfg.Paths}
+		if keyCfg.Expires != "" {
+			key.expires, err = parseExpiry(keyCfg.Expires)
+			if err != nil {
+				return nil, fmt.Errorf("bypass key %q: %w", id, err)
+			}
+			if !key.expires.After(time.Now()) {
+				logf("bypass key %q expired at %s", id, key.expires.Format(time.RFC3339))
+			}
+		}
+
+		b.keys = append(b.keys, key)
+	}
+
+	return b, nil
+}
+
+// parseExpiry parses a date, which expires at the end of the day in UTC, or an RFC 3339 timestamp.
+func parseExpiry(value string) (time.Time, error) {
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date.AddDate(0, 0, 1), nil
+	}
+	if timestamp, err := time.Parse(time.RFC3339, value); err == nil {
+		return timestamp, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid expiry %q, must be a date (2006-01-02) or an RFC 3339 timestamp", value)
+}
+
+// match returns the ID of the key sent with the request, if it is valid for the request's path and didn't expire yet.
+// Uses of expired keys are logged, at most once per key and expiredBypassKeyLogInterval.
+func (b *bypassKeys) match(req *http.Request, now time.Time) (string, bool) {
+	if b == nil || req == nil {
+		return "", false
+	}
+
+	value := req.Header.Get(b.header)
+	if value == "" {
+		return "", false
+	}
+	hash := sha256.Sum256([]byte(value))
+
+	for _, key := range b.keys {
+		if subtle.ConstantTimeCompare(hash[:], key.hash) != 1 {
+			continue
+		}
+		if !key.expires.IsZero() && !now.Before(key.expires) {
+			b.logExpired(key, now)
+			continue
+		}
+		if len(key.paths) > 0 && !matchesAnyPathPattern(key.paths, cleanPath(req.URL.Path)) {
+			continue
+		}
+		return key.id, true
+	}
+
+	return "", false
+}
+
+// logExpired logs that an expired key was sent, unless this was already logged within expiredBypassKeyLogInterval.
+func (b *bypassKeys) logExpired(key bypassKey, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if last, ok := b.expiredLogged[key.id]; ok && now.Sub(last) < expiredBypassKeyLogInterval {
+		return
+	}
+	b.expiredLogged[key.id] = now
+
+	b.logf("expired bypass key %q (expired at %s) was sent", key.id, key.expires.Format(time.RFC3339))
+}
@@ -0,0 +1,197 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sha256Hex(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestInitBypassKeys_Errors(t *testing.T) {
+	for name, test := range map[string]struct {
+		header string
+		keys   []BypassKeyConfig
+	}{
+		"MissingHeader":  {"", []BypassKeyConfig{{ID: "a", SHA256: sha256Hex("a")}}},
+		"MissingKeys":    {"X-Bypass", nil},
+		"MissingID":      {"X-Bypass", []BypassKeyConfig{{SHA256: sha256Hex("a")}}},
+		"DuplicateID":    {"X-Bypass", []BypassKeyConfig{{ID: "a", SHA256: sha256Hex("a")}, {ID: "a", SHA256: sha256Hex("b")}}},
+		"DuplicateHash":  {"X-Bypass", []BypassKeyConfig{{ID: "a", SHA256: sha256Hex("a")}, {ID: "b", SHA256: sha256Hex("a")}}},
+		"PlaintextKey":   {"X-Bypass", []BypassKeyConfig{{ID: "a", SHA256: "secret"}}},
+		"ShortHash":      {"X-Bypass", []BypassKeyConfig{{ID: "a", SHA256: sha256Hex("a")[:32]}}},
+		"InvalidPath":    {"X-Bypass", []BypassKeyConfig{{ID: "a", SHA256: sha256Hex("a"), Paths: []string{"api"}}}},
+		"InvalidPattern": {"X-Bypass", []BypassKeyConfig{{ID: "a", SHA256: sha256Hex("a"), Paths: []string{"/api/["}}}},
+		"InvalidExpiry":  {"X-Bypass", []BypassKeyConfig{{ID: "a", SHA256: sha256Hex("a"), Expires: "31.12.2026"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := initBypassKeys(test.header, test.keys, t.Logf); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestBypassKeys_Match(t *testing.T) {
+	bypass, err := initBypassKeys("x-geoblock-bypass", []BypassKeyConfig{
+		{ID: "partner-a", SHA256: sha256Hex("secret-a")},
+		{ID: "partner-b", SHA256: sha256Hex("secret-b"), Paths: []string{"/api/", "/hooks/*/push"}, Expires: "2026-06-30"},
+		{ID: "partner-c", SHA256: sha256Hex("secret-c"), Expires: "2026-06-30T12:00:00+02:00"},
+		{ID: "partner-d", SHA256: sha256Hex("secret-d"), Paths: []string{"/partner"}},
+	}, t.Logf)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	now := time.Date(2026, 6, 30, 10, 0, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		name, key, path string
+		now             time.Time
+		expectedID      string
+	}{
+		{"NoKey", "", "/", now, ""},
+		{"UnknownKey", "secret-x", "/", now, ""},
+		{"Key", "secret-a", "/", now, "partner-a"},
+		{"PathPrefix", "secret-b", "/api/orders", now, "partner-b"},
+		{"PathPattern", "secret-b", "/hooks/github/push", now, "partner-b"},
+		{"OtherPath", "secret-b", "/admin", now, ""},
+		{"PathBelowPrefix", "secret-d", "/partner/orders", now, "partner-d"},
+		{"PathSharingPrefix", "secret-d", "/partner-admin", now, ""},
+		{"DotDotSegment", "secret-d", "/partner/../admin", now, ""},
+		{"DotDotSegmentIntoPrefix", "secret-d", "/public/../partner/orders", now, "partner-d"},
+		{"ValidThroughEndOfDay", "secret-b", "/api/", now.Add(13 * time.Hour), "partner-b"},
+		{"ExpiredDate", "secret-b", "/api/", now.Add(14 * time.Hour), ""},
+		{"ExpiredTimestamp", "secret-c", "/", now, ""},
+		{"BeforeTimestamp", "secret-c", "/", now.Add(-time.Minute), "partner-c"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.key != "" {
+				req.Header.Set("X-Geoblock-Bypass", test.key)
+			}
+
+			id, ok := bypass.match(req, test.now)
+			if ok != (test.expectedID != "") || id != test.expectedID {
+				t.Errorf("expected key %q, but got: %q", test.expectedID, id)
+			}
+		})
+	}
+}
+
+func TestBypassKeys_MatchLogsExpiredKey(t *testing.T) {
+	var logs []string
+	logf := func(format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+
+	bypass, err := initBypassKeys("X-Geoblock-Bypass", []BypassKeyConfig{
+		{ID: "partner-a", SHA256: sha256Hex("secret-a"), Expires: "2026-06-30"},
+		{ID: "partner-b", SHA256: sha256Hex("secret-b"), Expires: "2026-06-30"},
+	}, logf)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	logs = nil
+
+	now := time.Date(2026, 7, 1, 10, 0, 0, 0, time.UTC)
+	match := func(key string, now time.Time) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Geoblock-Bypass", key)
+		if id, ok := bypass.match(req, now); ok {
+			t.Errorf("expected expired key not to match, but got: %q", id)
+		}
+	}
+
+	match("secret-a", now)
+	match("secret-a", now.Add(time.Minute))
+	match("secret-b", now.Add(time.Minute))
+	if len(logs) != 2 || !strings.Contains(logs[0], `"partner-a"`) || !strings.Contains(logs[1], `"partner-b"`) {
+		t.Errorf("expected each expired key to be logged once, but got: %q", logs)
+	}
+
+	match("secret-a", now.Add(expiredBypassKeyLogInterval))
+	if len(logs) != 3 || !strings.Contains(logs[2], `"partner-a"`) {
+		t.Errorf("expected expired key to be logged again after %s, but got: %q", expiredBypassKeyLogInterval, logs)
+	}
+}
+
+func TestPlugin_ServeHTTP_Bypass(t *testing.T) {
+	var forwardedKey string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwardedKey = req.Header.Get("X-Geoblock-Bypass")
+		rw.WriteHeader(http.StatusTeapot)
+	})
+
+	plugin, err := New(context.TODO(), next, &Config{
+		Enabled:          true,
+		DatabaseFilePath: dbFilePath,
+		AllowedCountries: []string{"DE"},
+		BypassHeader:     "X-Geoblock-Bypass",
+		BypassKeys: []BypassKeyConfig{
+			{ID: "partner-a", SHA256: sha256Hex("secret-a"), Paths: []string{"/api/"}},
+			{ID: "expired", SHA256: sha256Hex("secret-b"), Expires: "2020-01-01"},
+		},
+		DisallowedStatusCode: http.StatusForbidden,
+	}, pluginName)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	request := func(path, key, ip string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Real-IP", ip)
+		req.Header.Set("X-Geoblock-Bypass", key)
+
+		rr := httptest.NewRecorder()
+		plugin.ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	for _, test := range []struct {
+		name, path, key, ip string
+		expectedStatus      int
+	}{
+		{"Bypass", "/api/orders", "secret-a", "8.8.8.8", http.StatusTeapot},
+		{"InvalidKey", "/api/orders", "secret-x", "8.8.8.8", http.StatusForbidden},
+		{"OtherPath", "/admin", "secret-a", "8.8.8.8", http.StatusForbidden},
+		{"ExpiredKey", "/api/orders", "secret-b", "8.8.8.8", http.StatusForbidden},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if status := request(test.path, test.key, test.ip); status != test.expectedStatus {
+				t.Errorf("expected status code %d, but got: %d", test.expectedStatus, status)
+			}
+		})
+	}
+
+	t.Run("KeyIsNotForwarded", func(t *testing.T) {
+		forwardedKey = "unset"
+		request("/api/orders", "secret-a", "8.8.8.8")
+		if forwardedKey != "" {
+			t.Errorf("expected bypass key not to be forwarded, but got: %q", forwardedKey)
+		}
+	})
+
+	t.Run("Decision", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/orders", nil)
+		req.Header.Set("X-Geoblock-Bypass", "secret-a")
+
+		decision, err := plugin.(*Plugin).DecideRequest(req, "8.8.8.8")
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if !decision.Allowed || decision.Reason != "bypass:partner-a" {
+			t.Errorf("expected request to be allowed by bypass key, but got: %+v", decision)
+		}
+	})
+}
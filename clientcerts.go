@@ -0,0 +1,231 @@
+package traefik_plugin_geoblock
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// reasonBypassCertPrefix is followed by the ID of the client certificate matcher that allowed a request,
+// e.g. "bypass-cert:devices".
+const reasonBypassCertPrefix = "bypass-cert:"
+
+// ClientCertConfig matches the client certificate presented via mutual TLS. All configured attributes must match,
+// and an attribute matches if any of its values match. Values other than fingerprints are glob patterns.
+type ClientCertConfig struct {
+	ID               string   // Identifier, recorded in decisions (required for bypassClientCerts)
+	CommonNames      []string // Subject common names (e.g. *.devices.example.com)
+	DNSNames         []string // Subject alternative DNS names
+	URIs             []string // Subject alternative URIs (e.g. spiffe://example.com/*)
+	Issuers          []string // Issuer common names
+	SPKIFingerprints []string // SHA-256 fingerprints of the subject public key info, hex or base64 encoded
+}
+
+// clientCertMatcher is a compiled client certificate matcher.
+type clientCertMatcher struct {
+	id               string
+	commonNames      []string
+	dnsNames         []string
+	uris             []string
+	issuers          []string
+	spkiFingerprints [][]byte
+}
+
+// initClientCertMatchers compiles the configured client certificate matchers. If requireID is set,
+// each matcher must have a unique ID.
+func initClientCertMatchers(certs []ClientCertConfig, requireID bool) ([]clientCertMatcher, error) {
+	matchers := make([]clientCertMatcher, 0, len(certs))
+	seen := make(map[string]struct{}, len(certs))
+
+	for i, certCfg := range certs {
+		m, err := initClientCertMatcher(certCfg)
+		if err == nil && requireID && m.id == "" {
+			err = errors.New("missing id")
+		}
+		if err != nil {
+			if certCfg.ID != "" {
+				return nil, fmt.Errorf("client certificate %q: %w", certCfg.ID, err)
+			}
+			return nil, fmt.Errorf("client certificate #%d: %w", i+1, err)
+		}
+
+		if m.id != "" {
+			if _, ok := seen[m.id]; ok {
+				return nil, fmt.Errorf("client certificate %q: defined more than once", m.id)
+			}
+			seen[m.id] = struct{}{}
+		}
+
+		matchers = append(matchers, m)
+	}
+
+	return matchers, nil
+}
+
+// initClientCertMatcher compiles a single client certificate matcher.
+func initClientCertMatcher(cfg ClientCertConfig) (clientCertMatcher, error) {
+	m := clientCertMatcher{id: strings.TrimSpace(cfg.ID)}
+
+	var err error
+	if m.commonNames, err = initCertPatterns(cfg.CommonNames, true); err != nil {
+		return m, err
+	}
+	if m.dnsNames, err = initCertPatterns(cfg.DNSNames, true); err != nil {
+		return m, err
+	}
+	if m.uris, err = initCertPatterns(cfg.URIs, false); err != nil {
+		return m, err
+	}
+	if m.issuers, err = initCertPatterns(cfg.Issuers, true); err != nil {
+		return m, err
+	}
+
+	for _, fingerprint := range cfg.SPKIFingerprints {
+		decoded, err := decodeFingerprint(fingerprint)
+		if err != nil {
+			return m, err
+		}
+		m.spkiFingerprints = append(m.spkiFingerprints, decoded)
+	}
+
+	if len(m.commonNames)+len(m.dnsNames)+len(m.uris)+len(m.issuers)+len(m.spkiFingerprints) == 0 {
+		return m, errors.New("no attributes to match configured")
+	}
+
+	return m, nil
+}
+
+// initCertPatterns validates a list of glob patterns, lower casing them if matching should be case-insensitive.
+func initCertPatterns(patterns []string, caseInsensitive bool) ([]string, error) {
+	var normalized []string
+
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if caseInsensitive {
+			pattern = strings.ToLower(pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return nil, fmt.Errorf("invalid pattern %q", pattern)
+		}
+		normalized = append(normalized, pattern)
+	}
+
+	return normalized, nil
+}
+
+// decodeFingerprint decodes a hex (optionally separated by colons) or base64 encoded SHA-256 fingerprint.
+func decodeFingerprint(fingerprint string) ([]byte, error) {
+	fingerprint = strings.TrimSpace(fingerprint)
+
+	if decoded, err := hex.DecodeString(strings.ReplaceAll(fingerprint, ":", "")); err == nil && len(decoded) == sha256.Size {
+		return decoded, nil
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(fingerprint); err == nil && len(decoded) == sha256.Size {
+		return decoded, nil
+	}
+
+	return nil, fmt.Errorf("invalid SPKI fingerprint %q, must be a hex or base64 encoded SHA-256 hash", fingerprint)
+}
+
+// matches indicates whether the certificate matches all configured attributes. Certificates that weren't verified
+// against a CA by Traefik only match if the matcher pins their public key, as anyone can issue a certificate
+// with arbitrary names.
+func (m clientCertMatcher) matches(cert *x509.Certificate, verified bool) bool {
+	if !verified && len(m.spkiFingerprints) == 0 {
+		return false
+	}
+	if len(m.commonNames) > 0 && !matchesCertPattern(m.commonNames, true, cert.Subject.CommonName) {
+		return false
+	}
+	if len(m.dnsNames) > 0 && !matchesCertPattern(m.dnsNames, true, cert.DNSNames...) {
+		return false
+	}
+	if len(m.uris) > 0 {
+		uris := make([]string, 0, len(cert.URIs))
+		for _, uri := range cert.URIs {
+			uris = append(uris, uri.String())
+		}
+		if !matchesCertPattern(m.uris, false, uris...) {
+			return false
+		}
+	}
+	if len(m.issuers) > 0 && !matchesCertPattern(m.issuers, true, cert.Issuer.CommonName) {
+		return false
+	}
+	if len(m.spkiFingerprints) > 0 {
+		fingerprint := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+		matched := false
+		for _, expected := range m.spkiFingerprints {
+			if bytes.Equal(fingerprint[:], expected) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesCertPattern indicates whether any of the values matches any of the patterns.
+func matchesCertPattern(patterns []string, caseInsensitive bool, values ...string) bool {
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		if caseInsensitive {
+			value = strings.ToLower(value)
+		}
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, value); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// clientCertificate returns the leaf certificate the client presented, if any, and whether Traefik verified it.
+// Verifying it is up to the TLS configuration of Traefik, e.g. RequestClientCert doesn't verify certificates.
+func clientCertificate(req *http.Request) (*x509.Certificate, bool) {
+	if req == nil || req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil, false
+	}
+
+	return req.TLS.PeerCertificates[0], len(req.TLS.VerifiedChains) > 0
+}
+
+// matchClientCert returns the first matcher matching the client certificate of the request, if any.
+func matchClientCert(matchers []clientCertMatcher, req *http.Request) (clientCertMatcher, bool) {
+	cert, verified := clientCertificate(req)
+	if cert == nil {
+		return clientCertMatcher{}, false
+	}
+
+	for _, m := range matchers {
+		if m.matches(cert, verified) {
+			return m, true
+		}
+	}
+
+	return clientCertMatcher{}, false
+}
+
+// clientCertCondition matches the client certificate of the request against a list of matchers.
+type clientCertCondition []clientCertMatcher
+
+func (c clientCertCondition) matches(ctx *evalContext) (bool, error) {
+	_, ok := matchClientCert(c, ctx.req)
+	return ok, nil
+}
@@ -0,0 +1,241 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// testClientCert creates a self-signed client certificate.
+func testClientCert(t *testing.T, commonName, issuer string, dnsNames []string, uris []string) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		Issuer:       pkix.Name{CommonName: issuer},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	for _, rawURI := range uris {
+		uri, err := url.Parse(rawURI)
+		if err != nil {
+			t.Fatalf("failed to parse URI: %v", err)
+		}
+		template.URIs = append(template.URIs, uri)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	// NB: self-signed certificates are issued by their subject, the issuer is set for matching only.
+	cert.Issuer.CommonName = issuer
+
+	return cert
+}
+
+// verifiedTLS returns the connection state of a client certificate that was verified against a CA.
+func verifiedTLS(cert *x509.Certificate) *tls.ConnectionState {
+	return &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+}
+
+func TestInitClientCertMatchers_Errors(t *testing.T) {
+	for name, test := range map[string]struct {
+		certs     []ClientCertConfig
+		requireID bool
+	}{
+		"NoAttributes":       {[]ClientCertConfig{{ID: "a"}}, false},
+		"MissingID":          {[]ClientCertConfig{{CommonNames: []string{"a"}}}, true},
+		"DuplicateID":        {[]ClientCertConfig{{ID: "a", CommonNames: []string{"a"}}, {ID: "a", CommonNames: []string{"b"}}}, false},
+		"InvalidPattern":     {[]ClientCertConfig{{DNSNames: []string{"[a"}}}, false},
+		"InvalidFingerprint": {[]ClientCertConfig{{SPKIFingerprints: []string{"abcd"}}}, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := initClientCertMatchers(test.certs, test.requireID); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestClientCertMatcher_Matches(t *testing.T) {
+	cert := testClientCert(t, "Sensor-1.devices.example.com", "Example Device CA",
+		[]string{"sensor-1.example.com"}, []string{"spiffe://example.com/ns/prod/sa/sensor"})
+	fingerprint := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	for _, test := range []struct {
+		name     string
+		cfg      ClientCertConfig
+		expected bool
+	}{
+		{"CommonName", ClientCertConfig{CommonNames: []string{"*.devices.example.com"}}, true},
+		{"OtherCommonName", ClientCertConfig{CommonNames: []string{"*.example.org"}}, false},
+		{"DNSName", ClientCertConfig{DNSNames: []string{"other.example.com", "sensor-*.example.com"}}, true},
+		{"URI", ClientCertConfig{URIs: []string{"spiffe://example.com/ns/prod/sa/*"}}, true},
+		{"URIIsCaseSensitive", ClientCertConfig{URIs: []string{"spiffe://example.com/ns/PROD/sa/*"}}, false},
+		{"Issuer", ClientCertConfig{Issuers: []string{"example device ca"}}, true},
+		{"HexFingerprint", ClientCertConfig{SPKIFingerprints: []string{hex.EncodeToString(fingerprint[:])}}, true},
+		{"Base64Fingerprint", ClientCertConfig{SPKIFingerprints: []string{base64.StdEncoding.EncodeToString(fingerprint[:])}}, true},
+		{"OtherFingerprint", ClientCertConfig{SPKIFingerprints: []string{sha256Hex("other")}}, false},
+		{"AllAttributes", ClientCertConfig{CommonNames: []string{"*"}, Issuers: []string{"example device ca"}}, true},
+		{"IssuerMismatch", ClientCertConfig{CommonNames: []string{"*"}, Issuers: []string{"other ca"}}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := initClientCertMatcher(test.cfg)
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if actual := m.matches(cert, true); actual != test.expected {
+				t.Errorf("expected %t, but got: %t", test.expected, actual)
+			}
+		})
+	}
+
+	t.Run("Unverified", func(t *testing.T) {
+		for _, test := range []struct {
+			name     string
+			cfg      ClientCertConfig
+			expected bool
+		}{
+			{"CommonName", ClientCertConfig{CommonNames: []string{"*.devices.example.com"}}, false},
+			{"DNSName", ClientCertConfig{DNSNames: []string{"sensor-*.example.com"}}, false},
+			{"URI", ClientCertConfig{URIs: []string{"spiffe://example.com/ns/prod/sa/*"}}, false},
+			{"Issuer", ClientCertConfig{Issuers: []string{"example device ca"}}, false},
+			{"Fingerprint", ClientCertConfig{SPKIFingerprints: []string{hex.EncodeToString(fingerprint[:])}}, true},
+			{"FingerprintAndIssuer", ClientCertConfig{Issuers: []string{"example device ca"}, SPKIFingerprints: []string{hex.EncodeToString(fingerprint[:])}}, true},
+		} {
+			m, err := initClientCertMatcher(test.cfg)
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if actual := m.matches(cert, false); actual != test.expected {
+				t.Errorf("%s: expected %t, but got: %t", test.name, test.expected, actual)
+			}
+		}
+	})
+}
+
+func TestPlugin_ServeHTTP_ClientCerts(t *testing.T) {
+	device := testClientCert(t, "sensor-1.devices.example.com", "Example Device CA", nil, nil)
+	partner := testClientCert(t, "api.partner.example.org", "Partner CA", nil, nil)
+	other := testClientCert(t, "laptop.example.net", "Other CA", nil, nil)
+
+	request := func(plugin http.Handler, cert *x509.Certificate, ip string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-IP", ip)
+		if cert != nil {
+			req.TLS = verifiedTLS(cert)
+		}
+
+		rr := httptest.NewRecorder()
+		plugin.ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	t.Run("Bypass", func(t *testing.T) {
+		plugin, err := New(context.TODO(), &noopHandler{}, &Config{
+			Enabled:          true,
+			DatabaseFilePath: dbFilePath,
+			AllowedCountries: []string{"DE"},
+			BypassClientCerts: []ClientCertConfig{
+				{ID: "devices", CommonNames: []string{"*.devices.example.com"}, Issuers: []string{"Example Device CA"}},
+			},
+			DisallowedStatusCode: http.StatusForbidden,
+		}, pluginName)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+
+		for _, test := range []struct {
+			name           string
+			cert           *x509.Certificate
+			expectedStatus int
+		}{
+			{"NoCertificate", nil, http.StatusForbidden},
+			{"MatchingCertificate", device, http.StatusTeapot},
+			{"OtherCertificate", other, http.StatusForbidden},
+		} {
+			if status := request(plugin, test.cert, "8.8.8.8"); status != test.expectedStatus {
+				t.Errorf("%s: expected status code %d, but got: %d", test.name, test.expectedStatus, status)
+			}
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.TLS = verifiedTLS(device)
+
+		decision, err := plugin.(*Plugin).DecideRequest(req, "8.8.8.8")
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if !decision.Allowed || decision.Reason != "bypass-cert:devices" {
+			t.Errorf("expected request to be allowed by client certificate, but got: %+v", decision)
+		}
+
+		// NB: e.g. with clientAuthType RequestClientCert, anyone can present a self-signed certificate with matching names.
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{device}}
+
+		decision, err = plugin.(*Plugin).DecideRequest(req, "8.8.8.8")
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if decision.Allowed {
+			t.Errorf("expected unverified client certificate not to bypass, but got: %+v", decision)
+		}
+	})
+
+	t.Run("Rules", func(t *testing.T) {
+		plugin, err := New(context.TODO(), &noopHandler{}, &Config{
+			Enabled:          true,
+			DatabaseFilePath: dbFilePath,
+			Rules: []RuleConfig{
+				{Name: "partners", Action: "allow", ClientCerts: []ClientCertConfig{{Issuers: []string{"partner ca"}}}, Countries: []string{"US"}},
+				{Name: "devices", Action: "allow", ClientCerts: []ClientCertConfig{{CommonNames: []string{"*.devices.example.com"}}}},
+			},
+			DisallowedStatusCode: http.StatusForbidden,
+		}, pluginName)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+
+		for _, test := range []struct {
+			name           string
+			cert           *x509.Certificate
+			ip             string
+			expectedStatus int
+		}{
+			{"PartnerFromUS", partner, "8.8.8.8", http.StatusTeapot},
+			{"PartnerFromRU", partner, "77.88.8.8", http.StatusForbidden},
+			{"DeviceFromRU", device, "77.88.8.8", http.StatusTeapot},
+			{"Other", other, "8.8.8.8", http.StatusForbidden},
+		} {
+			if status := request(plugin, test.cert, test.ip); status != test.expectedStatus {
+				t.Errorf("%s: expected status code %d, but got: %d", test.name, test.expectedStatus, status)
+			}
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeCountry(t *testing.T) {
+	for input, expected := range map[string]string{
+		"DE":   "DE",
+		"de":   "DE",
+		" DE ": "DE",
+		"DEU":  "DE",
+		"deu":  "DE",
+		"276":  "DE",
+		"40":   "AT",
+		"040":  "AT",
+		"XK":   "XK",
+	} {
+		country, err := normalizeCountry(input)
+		if err != nil {
+			t.Errorf("expected no error for %q, but got: %v", input, err)
+		}
+		if country != expected {
+			t.Errorf("expected %q to be normalized to %q, but got: %q", input, expected, country)
+		}
+	}
+
+	for input, expectedErr := range map[string]string{
+		"UK":      "UK is not ISO 3166-1, did you mean GB?",
+		"el":      "EL is not ISO 3166-1, did you mean GR?",
+		"Germany": `"Germany" is not an ISO 3166-1 country code, did you mean DE?`,
+		"ZZ":      `"ZZ" is not a valid ISO 3166-1 country code`,
+		"0276":    `"0276" is not a valid ISO 3166-1 country code`,
+		"":        `"" is not a valid ISO 3166-1 country code`,
+	} {
+		_, err := normalizeCountry(input)
+		if err == nil {
+			t.Errorf("expected error for %q, but got none", input)
+			continue
+		}
+		if err.Error() != expectedErr {
+			t.Errorf("expected error %q for %q, but got: %q", expectedErr, input, err)
+		}
+	}
+}
+
+func TestIsoCountries(t *testing.T) {
+	seen := make(map[string]bool)
+
+	for _, c := range isoCountries {
+		if len(c.alpha2) != 2 || len(c.alpha3) != 3 || (c.numeric != "" && len(c.numeric) != 3) {
+			t.Errorf("invalid country entry %+v", c)
+		}
+		for _, code := range []string{c.alpha2, c.alpha3, c.numeric} {
+			if code != "" && seen[code] {
+				t.Errorf("duplicate country code %s", code)
+			}
+			seen[code] = true
+		}
+	}
+
+	groups, err := initCountryGroups(nil)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	for name, members := range groups {
+		for _, member := range members {
+			if _, ok := countriesByAlpha2[member]; !ok {
+				t.Errorf("group %s contains unknown country %s", name, member)
+			}
+		}
+	}
+}
+
+func TestNew_InvalidCountry(t *testing.T) {
+	cfg := &Config{
+		Enabled:              true,
+		DatabaseFilePath:     dbFilePath,
+		AllowedCountries:     []string{"DE", "UK"},
+		DisallowedStatusCode: http.StatusForbidden,
+	}
+
+	plugin, err := New(context.TODO(), &noopHandler{}, cfg, pluginName)
+	if err == nil {
+		t.Fatal("expected error, but got none")
+	}
+	if !strings.Contains(err.Error(), "did you mean GB?") {
+		t.Errorf("expected error to suggest GB, but got: %v", err)
+	}
+	if plugin != nil {
+		t.Error("expected plugin to be nil, but is not")
+	}
+}
+
+func TestPlugin_ServeHTTP_NormalizedCountries(t *testing.T) {
+	cfg := &Config{
+		Enabled:              true,
+		DatabaseFilePath:     dbFilePath,
+		AllowedCountries:     []string{"de ", "840"},
+		BlockedCountries:     []string{"DEU"},
+		DisallowedStatusCode: http.StatusForbidden,
+	}
+
+	testRequest(t, "Lower case country allowed", cfg, "185.5.82.105", http.StatusTeapot)
+	testRequest(t, "Numeric country allowed", cfg, "8.8.8.8", http.StatusTeapot)
+}
@@ -0,0 +1,133 @@
+package traefik_plugin_geoblock
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// reasonCrawlerPrefix is followed by the name of the verified crawler that allowed a request, e.g. "crawler:googlebot".
+const reasonCrawlerPrefix = "crawler:"
+
+// CrawlerConfig defines a crawler that is allowed if verified by reverse and forward DNS.
+type CrawlerConfig struct {
+	Name       string   // Name of the crawler, recorded in decisions
+	UserAgents []string // Substrings of the User-Agent header identifying the crawler, matched case-insensitively
+	Domains    []string // Domains the hostnames of the crawler's IP addresses belong to
+}
+
+// knownCrawlers are the built-in crawlers, by name. Their domains are documented by the search engines as the ones
+// to verify crawlers by. Google's user-triggered fetchers are kept apart from Googlebot, and only App Engine hostnames
+// of googleusercontent.com are accepted, as any Google Cloud VM can have a hostname below it.
+var knownCrawlers = map[string]CrawlerConfig{
+	"googlebot":   {UserAgents: []string{"Googlebot", "Google-InspectionTool", "GoogleOther"}, Domains: []string{"googlebot.com"}},
+	"googlefetch": {UserAgents: []string{"FeedFetcher-Google", "Google-Read-Aloud", "Google-Site-Verification"}, Domains: []string{"google.com", "gae.googleusercontent.com"}},
+	"bingbot":     {UserAgents: []string{"bingbot", "BingPreview", "msnbot"}, Domains: []string{"search.msn.com"}},
+	"applebot":    {UserAgents: []string{"Applebot"}, Domains: []string{"applebot.apple.com"}},
+	"yandexbot":   {UserAgents: []string{"YandexBot", "YandexImages", "YandexMobileBot"}, Domains: []string{"yandex.ru", "yandex.net", "yandex.com"}},
+	"baiduspider": {UserAgents: []string{"Baiduspider"}, Domains: []string{"crawl.baidu.com", "crawl.baidu.jp"}},
+}
+
+// crawler is a compiled crawler definition.
+type crawler struct {
+	name       string
+	userAgents []string
+	domains    []string
+}
+
+// initCrawlers compiles the built-in crawlers referenced by name and the custom ones.
+func initCrawlers(names []string, custom []CrawlerConfig) ([]crawler, error) {
+	configs := make([]CrawlerConfig, 0, len(names)+len(custom))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		known, ok := knownCrawlers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown crawler %q, must be one of %s", name, strings.Join(knownCrawlerNames(), ", "))
+		}
+		known.Name = name
+		configs = append(configs, known)
+	}
+	configs = append(configs, custom...)
+
+	crawlers := make([]crawler, 0, len(configs))
+	seen := make(map[string]struct{}, len(configs))
+
+	for i, crawlerCfg := range configs {
+		c := crawler{name: strings.TrimSpace(crawlerCfg.Name)}
+		if c.name == "" {
+			return nil, fmt.Errorf("custom crawler #%d: missing name", i-len(names)+1)
+		}
+		if _, ok := seen[c.name]; ok {
+			return nil, fmt.Errorf("crawler %q: defined more than once", c.name)
+		}
+		seen[c.name] = struct{}{}
+
+		for _, userAgent := range crawlerCfg.UserAgents {
+			if userAgent = strings.ToLower(strings.TrimSpace(userAgent)); userAgent != "" {
+				c.userAgents = append(c.userAgents, userAgent)
+			}
+		}
+		if len(c.userAgents) == 0 {
+			return nil, fmt.Errorf("crawler %q: no user agents configured", c.name)
+		}
+
+		for _, domain := range crawlerCfg.Domains {
+			domain = strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "."), ".")
+			if domain == "" || !strings.Contains(domain, ".") || strings.ContainsAny(domain, "*/: ") {
+				return nil, fmt.Errorf("crawler %q: invalid domain %q", c.name, domain)
+			}
+			c.domains = append(c.domains, domain)
+		}
+		if len(c.domains) == 0 {
+			return nil, fmt.Errorf("crawler %q: no domains configured", c.name)
+		}
+
+		crawlers = append(crawlers, c)
+	}
+
+	return crawlers, nil
+}
+
+// knownCrawlerNames returns the names of the built-in crawlers, sorted.
+func knownCrawlerNames() []string {
+	names := make([]string, 0, len(knownCrawlers))
+	for name := range knownCrawlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// claimedCrawlers returns the crawlers the User-Agent header claims the request is from.
+func claimedCrawlers(crawlers []crawler, userAgent string) []crawler {
+	if userAgent == "" {
+		return nil
+	}
+	userAgent = strings.ToLower(userAgent)
+
+	var claimed []crawler
+	for _, c := range crawlers {
+		for _, substring := range c.userAgents {
+			if strings.Contains(userAgent, substring) {
+				claimed = append(claimed, c)
+				break
+			}
+		}
+	}
+
+	return claimed
+}
+
+// verifiedBy indicates whether any of the forward-confirmed hostnames belongs to the crawler's domains.
+func (c crawler) verifiedBy(hostnames []string) bool {
+	for _, hostname := range hostnames {
+		for _, domain := range c.domains {
+			if matchesDomain(hostname, domain) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,141 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInitCrawlers_Errors(t *testing.T) {
+	for name, test := range map[string]struct {
+		names  []string
+		custom []CrawlerConfig
+	}{
+		"UnknownCrawler": {[]string{"examplebot"}, nil},
+		"Duplicate":      {[]string{"googlebot"}, []CrawlerConfig{{Name: "googlebot", UserAgents: []string{"Googlebot"}, Domains: []string{"google.com"}}}},
+		"MissingName":    {nil, []CrawlerConfig{{UserAgents: []string{"ExampleBot"}, Domains: []string{"example.com"}}}},
+		"NoUserAgents":   {nil, []CrawlerConfig{{Name: "examplebot", Domains: []string{"example.com"}}}},
+		"NoDomains":      {nil, []CrawlerConfig{{Name: "examplebot", UserAgents: []string{"ExampleBot"}}}},
+		"WildcardDomain": {nil, []CrawlerConfig{{Name: "examplebot", UserAgents: []string{"ExampleBot"}, Domains: []string{"*.example.com"}}}},
+		"TopLevelDomain": {nil, []CrawlerConfig{{Name: "examplebot", UserAgents: []string{"ExampleBot"}, Domains: []string{"com"}}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := initCrawlers(test.names, test.custom); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestClaimedCrawlers(t *testing.T) {
+	crawlers, err := initCrawlers([]string{"googlebot", "BingBot"}, []CrawlerConfig{
+		{Name: "examplebot", UserAgents: []string{"ExampleBot/"}, Domains: []string{".crawl.example.com."}},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	for userAgent, expected := range map[string]string{
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)": "googlebot",
+		"Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)":  "bingbot",
+		"examplebot/1.0":                  "examplebot",
+		"Mozilla/5.0 (X11; Linux x86_64)": "",
+		"":                                "",
+	} {
+		actual := ""
+		if claimed := claimedCrawlers(crawlers, userAgent); len(claimed) > 0 {
+			actual = claimed[0].name
+		}
+		if actual != expected {
+			t.Errorf("expected crawler %q for %q, but got: %q", expected, userAgent, actual)
+		}
+	}
+
+	if !crawlers[2].verifiedBy([]string{"bot-1.crawl.example.com"}) {
+		t.Error("expected crawler to be verified by subdomain, but it is not")
+	}
+	if crawlers[2].verifiedBy([]string{"bot-1.crawl.example.com.evil.net", "notcrawl.example.com"}) {
+		t.Error("expected crawler not to be verified by other domains, but it is")
+	}
+}
+
+func TestPlugin_ServeHTTP_VerifiedCrawlers(t *testing.T) {
+	server := startTestDNSServer(t, map[string][]string{
+		"8.8.8.8":                           {"crawl-8-8-8-8.googlebot.com."},
+		"crawl-8-8-8-8.googlebot.com.":      {"8.8.8.8"},
+		"8.8.4.4":                           {"crawl-8-8-4-4.googlebot.com."},
+		"crawl-8-8-4-4.googlebot.com.":      {"66.249.66.1"},
+		"1.1.1.1":                           {"one.one.one.one."},
+		"one.one.one.one.":                  {"1.1.1.1"},
+		"8.8.8.4":                           {"4.8.8.8.bc.googleusercontent.com."},
+		"4.8.8.8.bc.googleusercontent.com.": {"8.8.8.4"},
+	})
+
+	googlebot := "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+
+	plugin, err := New(context.TODO(), &noopHandler{}, &Config{
+		Enabled:              true,
+		DatabaseFilePath:     dbFilePath,
+		AllowedCountries:     []string{"DE"},
+		VerifiedCrawlers:     []string{"googlebot"},
+		DNSServer:            server.addr,
+		DisallowedStatusCode: http.StatusForbidden,
+	}, pluginName)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	for _, test := range []struct {
+		name, userAgent, ip string
+		expectedStatus      int
+	}{
+		{"VerifiedCrawler", googlebot, "8.8.8.8", http.StatusTeapot},
+		{"NotForwardConfirmed", googlebot, "8.8.4.4", http.StatusForbidden},
+		{"OtherDomain", googlebot, "1.1.1.1", http.StatusForbidden},
+		{"CloudVM", googlebot, "8.8.8.4", http.StatusForbidden},
+		{"NoPTRRecord", googlebot, "77.88.8.8", http.StatusForbidden},
+		{"NoCrawler", "Mozilla/5.0", "8.8.8.8", http.StatusForbidden},
+		{"AllowedCountry", "Mozilla/5.0", "185.5.82.105", http.StatusTeapot},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Real-IP", test.ip)
+			req.Header.Set("User-Agent", test.userAgent)
+
+			rr := httptest.NewRecorder()
+			plugin.ServeHTTP(rr, req)
+
+			if rr.Code != test.expectedStatus {
+				t.Errorf("expected status code %d, but got: %d", test.expectedStatus, rr.Code)
+			}
+		})
+	}
+
+	t.Run("Decision", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("User-Agent", googlebot)
+
+		decision, err := plugin.(*Plugin).DecideRequest(req, "8.8.8.8")
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if !decision.Allowed || decision.Reason != "crawler:googlebot" {
+			t.Errorf("expected request to be allowed as verified crawler, but got: %+v", decision)
+		}
+	})
+
+	t.Run("Cached", func(t *testing.T) {
+		queries := server.queryCount()
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("User-Agent", googlebot)
+		if _, err := plugin.(*Plugin).DecideRequest(req, "8.8.8.8"); err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+
+		if server.queryCount() != queries {
+			t.Error("expected verification to be cached, but DNS server was queried")
+		}
+	})
+}
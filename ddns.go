@@ -0,0 +1,255 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultHostnameRefreshInterval is the interval in which allowed hostnames are resolved again by default.
+const defaultHostnameRefreshInterval = 5 * time.Minute
+
+// resolvedHostnames holds the addresses of hostnames that are resolved periodically, e.g. dynamic DNS names.
+// If a hostname can't be resolved, its last known addresses are kept.
+type resolvedHostnames struct {
+	hostnames  []string
+	resolver   dnsResolver
+	timeout    time.Duration
+	interval   time.Duration
+	lastErrs   map[string]string
+	logf       func(format string, args ...interface{})
+	refreshing int32 // Set while a request refreshes the hostnames
+
+	mu        sync.RWMutex
+	addrs     map[string][]net.IP // Last known addresses, by hostname
+	addrIndex map[string]struct{} // All known addresses, as strings
+	refreshed time.Time           // When the hostnames were last refreshed
+}
+
+// initResolvedHostnames validates the hostnames and DNS settings. The hostnames aren't resolved until refreshed.
+func initResolvedHostnames(hostnames []string, interval string, cfg *Config,
+	logf func(format string, args ...interface{}),
+) (*resolvedHostnames, error) {
+	resolver, timeout, err := initDNSResolver(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &resolvedHostnames{
+		resolver:  resolver,
+		timeout:   timeout,
+		interval:  defaultHostnameRefreshInterval,
+		lastErrs:  make(map[string]string),
+		logf:      logf,
+		addrs:     make(map[string][]net.IP),
+		addrIndex: make(map[string]struct{}),
+	}
+
+	seen := make(map[string]struct{}, len(hostnames))
+	for _, hostname := range hostnames {
+		normalized := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(hostname)), ".")
+		if normalized == "" || net.ParseIP(normalized) != nil || strings.ContainsAny(normalized, "*/: ") || strings.Contains(normalized, "..") {
+			return nil, fmt.Errorf("invalid hostname %q", hostname)
+		}
+		if _, ok := seen[normalized]; ok {
+			continue
+		}
+		seen[normalized] = struct{}{}
+		h.hostnames = append(h.hostnames, normalized)
+	}
+
+	if interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid hostname refresh interval %q", interval)
+		}
+		h.interval = d
+	}
+
+	return h, nil
+}
+
+// contains indicates whether the IP address is one of the known addresses.
+func (h *resolvedHostnames) contains(ip net.IP) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	_, ok := h.addrIndex[ip.String()]
+	return ok
+}
+
+// refresh resolves all hostnames in parallel and replaces the known addresses at once, so it takes no longer than
+// the DNS timeout. Hostnames that can't be resolved keep their last known addresses. If ctx is canceled, the refresh
+// isn't considered done, so the hostnames are due to be refreshed again right away.
+func (h *resolvedHostnames) refresh(ctx context.Context, logf func(format string, args ...interface{})) {
+	h.mu.RLock()
+	addrs := make(map[string][]net.IP, len(h.addrs))
+	for hostname, hostAddrs := range h.addrs {
+		addrs[hostname] = hostAddrs
+	}
+	h.mu.RUnlock()
+
+	resolved := make([][]net.IP, len(h.hostnames))
+	errs := make([]error, len(h.hostnames))
+
+	var wg sync.WaitGroup
+	for i, hostname := range h.hostnames {
+		wg.Add(1)
+		go func(i int, hostname string) {
+			defer wg.Done()
+			resolved[i], errs[i] = h.resolve(ctx, hostname)
+		}(i, hostname)
+	}
+	wg.Wait()
+	canceled := ctx.Err() != nil
+
+	for i, hostname := range h.hostnames {
+		if err := errs[i]; err != nil {
+			if canceled {
+				continue
+			}
+			// NB: errors are only logged once, until the hostname could be resolved again.
+			if err.Error() != h.lastErrs[hostname] {
+				logf("failed to resolve allowed hostname %s, keeping previous addresses: %v", hostname, err)
+			}
+			h.lastErrs[hostname] = err.Error()
+			continue
+		}
+		delete(h.lastErrs, hostname)
+
+		if !equalIPs(addrs[hostname], resolved[i]) {
+			logf("allowed hostname %s resolved to %s", hostname, joinIPs(resolved[i]))
+		}
+		addrs[hostname] = resolved[i]
+	}
+
+	addrIndex := make(map[string]struct{})
+	for _, hostAddrs := range addrs {
+		for _, addr := range hostAddrs {
+			addrIndex[addr.String()] = struct{}{}
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.addrs = addrs
+	h.addrIndex = addrIndex
+	if !canceled {
+		h.refreshed = time.Now()
+	}
+}
+
+// refreshIfDue refreshes the hostnames if the refresh interval elapsed, unless a refresh is already running. Like
+// reloads of the hosts file (see hostsFileWatcher.reloadIfDue), refreshes run in the request that finds them due, which
+// waits up to the DNS timeout for them. The lookups don't use the context of that request, as their result is shared
+// with all other requests.
+func (h *resolvedHostnames) refreshIfDue(now time.Time) {
+	h.mu.RLock()
+	due := !now.Before(h.refreshed.Add(h.interval))
+	h.mu.RUnlock()
+
+	if !due || !atomic.CompareAndSwapInt32(&h.refreshing, 0, 1) {
+		return
+	}
+
+	defer atomic.StoreInt32(&h.refreshing, 0)
+	h.refresh(context.Background(), h.logf)
+}
+
+// refreshAll refreshes all given hostnames in parallel, so it takes no longer than the DNS timeout.
+func refreshAll(ctx context.Context, resolved []*resolvedHostnames, logf func(format string, args ...interface{})) {
+	seen := make(map[*resolvedHostnames]struct{}, len(resolved))
+
+	var wg sync.WaitGroup
+	for _, hostnames := range resolved {
+		if _, ok := seen[hostnames]; ok {
+			continue
+		}
+		seen[hostnames] = struct{}{}
+
+		wg.Add(1)
+		go func(hostnames *resolvedHostnames) {
+			defer wg.Done()
+			hostnames.refresh(ctx, logf)
+		}(hostnames)
+	}
+	wg.Wait()
+}
+
+// resolve looks up the A and AAAA records of a hostname, sorted.
+func (h *resolvedHostnames) resolve(ctx context.Context, hostname string) ([]net.IP, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	// NB: the trailing dot prevents search domains from being applied.
+	ipAddrs, err := h.resolver.LookupIPAddr(ctx, hostname+".")
+	if err != nil {
+		return nil, err
+	}
+	if len(ipAddrs) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", hostname)
+	}
+
+	resolved := make([]net.IP, 0, len(ipAddrs))
+	for _, ipAddr := range ipAddrs {
+		resolved = append(resolved, ipAddr.IP)
+	}
+	sort.Slice(resolved, func(i, j int) bool { return resolved[i].String() < resolved[j].String() })
+
+	return resolved, nil
+}
+
+// equalIPs indicates whether both sorted lists contain the same addresses.
+func equalIPs(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// joinIPs formats a list of addresses for logging.
+func joinIPs(ips []net.IP) string {
+	formatted := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		formatted = append(formatted, ip.String())
+	}
+
+	return strings.Join(formatted, ", ")
+}
+
+// resolvedHostnameCondition matches the addresses of periodically resolved hostnames.
+type resolvedHostnameCondition struct {
+	hostnames *resolvedHostnames
+}
+
+func (c resolvedHostnameCondition) matches(ctx *evalContext) (bool, error) {
+	c.hostnames.refreshIfDue(ctx.now)
+	return c.hostnames.contains(ctx.ip), nil
+}
+
+// resolvedHostnamesOf returns the periodically resolved hostnames the given rules depend on.
+func resolvedHostnamesOf(rules []rule) []*resolvedHostnames {
+	var resolved []*resolvedHostnames
+
+	for _, r := range rules {
+		for _, cond := range r.conditions {
+			if cond, ok := cond.(resolvedHostnameCondition); ok {
+				resolved = append(resolved, cond.hostnames)
+			}
+		}
+	}
+
+	return resolved
+}
@@ -0,0 +1,222 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInitResolvedHostnames_Errors(t *testing.T) {
+	for name, test := range map[string]struct {
+		hostnames []string
+		interval  string
+		cfg       *Config
+	}{
+		"IPAddress":       {[]string{"192.0.2.1"}, "", &Config{}},
+		"Wildcard":        {[]string{"*.example.com"}, "", &Config{}},
+		"URL":             {[]string{"https://home.example.com"}, "", &Config{}},
+		"InvalidInterval": {[]string{"home.example.com"}, "hourly", &Config{}},
+		"InvalidServer":   {[]string{"home.example.com"}, "", &Config{DNSServer: "localhost"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := initResolvedHostnames(test.hostnames, test.interval, test.cfg, t.Logf); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestResolvedHostnames_Refresh(t *testing.T) {
+	server := startTestDNSServer(t, map[string][]string{
+		"home.example.com.":   {"198.51.100.7", "2001:db8::7"},
+		"office.example.com.": {"203.0.113.1"},
+	})
+
+	hostnames, err := initResolvedHostnames([]string{"Home.example.com.", "office.example.com", "home.example.com"}, "", &Config{DNSServer: server.addr}, t.Logf)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if len(hostnames.hostnames) != 2 {
+		t.Errorf("expected duplicate hostnames to be removed, but got: %v", hostnames.hostnames)
+	}
+
+	if hostnames.contains(net.ParseIP("198.51.100.7")) {
+		t.Error("expected no addresses before the first refresh, but got some")
+	}
+
+	hostnames.refresh(context.Background(), t.Logf)
+
+	for ip, expected := range map[string]bool{
+		"198.51.100.7": true,
+		"2001:db8::7":  true,
+		"203.0.113.1":  true,
+		"198.51.100.8": false,
+	} {
+		if actual := hostnames.contains(net.ParseIP(ip)); actual != expected {
+			t.Errorf("expected %t for %s, but got: %t", expected, ip, actual)
+		}
+	}
+
+	server.setRecords("home.example.com.", []string{"198.51.100.8"})
+	server.setRecords("office.example.com.", nil)
+	hostnames.refresh(context.Background(), t.Logf)
+
+	for ip, expected := range map[string]bool{
+		"198.51.100.7": false,
+		"2001:db8::7":  false,
+		"198.51.100.8": true,
+		"203.0.113.1":  true, // NB: kept, as office.example.com couldn't be resolved
+	} {
+		if actual := hostnames.contains(net.ParseIP(ip)); actual != expected {
+			t.Errorf("expected %t for %s after refresh, but got: %t", expected, ip, actual)
+		}
+	}
+}
+
+func TestResolvedHostnames_RefreshIfDue(t *testing.T) {
+	server := startTestDNSServer(t, map[string][]string{
+		"home.example.com.": {"198.51.100.7"},
+	})
+
+	hostnames, err := initResolvedHostnames([]string{"home.example.com"}, "1h", &Config{DNSServer: server.addr}, t.Logf)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	hostnames.refresh(context.Background(), t.Logf)
+	server.setRecords("home.example.com.", []string{"198.51.100.8"})
+
+	hostnames.refreshIfDue(time.Now())
+	if !hostnames.contains(net.ParseIP("198.51.100.7")) {
+		t.Error("expected hostnames not to be refreshed before the interval elapsed")
+	}
+
+	hostnames.refreshIfDue(time.Now().Add(time.Hour))
+	if !hostnames.contains(net.ParseIP("198.51.100.8")) {
+		t.Error("expected hostnames to be refreshed after the interval elapsed, but they were not")
+	}
+}
+
+func TestResolvedHostnames_RefreshCanceled(t *testing.T) {
+	server := startTestDNSServer(t, map[string][]string{
+		"home.example.com.": {"198.51.100.7"},
+	})
+
+	var logs []string
+	logf := func(format string, args ...interface{}) { logs = append(logs, fmt.Sprintf(format, args...)) }
+
+	hostnames, err := initResolvedHostnames([]string{"home.example.com"}, "1h", &Config{DNSServer: server.addr}, logf)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	hostnames.refresh(ctx, logf)
+
+	if len(logs) != 0 {
+		t.Errorf("expected canceled lookups not to be logged, but got: %v", logs)
+	}
+
+	// NB: the canceled refresh doesn't count, so the hostnames are still due to be refreshed.
+	hostnames.refreshIfDue(time.Now())
+	if !hostnames.contains(net.ParseIP("198.51.100.7")) {
+		t.Error("expected hostnames to be refreshed after a canceled refresh, but they were not")
+	}
+}
+
+func TestResolvedHostnames_RefreshParallel(t *testing.T) {
+	// NB: the server never answers, so every lookup runs into the timeout.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start DNS server: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	var names []string
+	for i := 0; i < 10; i++ {
+		names = append(names, fmt.Sprintf("host%d.example.com", i))
+	}
+
+	hostnames, err := initResolvedHostnames(names, "", &Config{DNSServer: conn.LocalAddr().String(), DNSTimeout: "100ms"}, t.Logf)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	start := time.Now()
+	refreshAll(context.Background(), []*resolvedHostnames{hostnames, hostnames}, t.Logf)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected hostnames to be resolved in parallel, but it took: %s", elapsed)
+	}
+}
+
+func TestPlugin_ServeHTTP_AllowedHostnames(t *testing.T) {
+	server := startTestDNSServer(t, map[string][]string{
+		"admin.dyndns.example.net.": {"77.88.8.8"},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	plugin, err := New(ctx, &noopHandler{}, &Config{
+		Enabled:                 true,
+		DatabaseFilePath:        dbFilePath,
+		AllowedCountries:        []string{"DE"},
+		BlockedIPBlocks:         []string{"77.88.0.0/16"},
+		AllowedHostnames:        []string{"admin.dyndns.example.net"},
+		HostnameRefreshInterval: "1ns",
+		DNSServer:               server.addr,
+		DisallowedStatusCode:    http.StatusForbidden,
+	}, pluginName)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	request := func(ip string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-IP", ip)
+
+		rr := httptest.NewRecorder()
+		plugin.ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	if status := request("77.88.8.8"); status != http.StatusTeapot {
+		t.Errorf("expected status code %d, but got: %d", http.StatusTeapot, status)
+	}
+	if status := request("77.88.8.9"); status != http.StatusForbidden {
+		t.Errorf("expected status code %d, but got: %d", http.StatusForbidden, status)
+	}
+
+	decision, err := plugin.(*Plugin).Decide("77.88.8.8")
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if !decision.Allowed || decision.Reason != reasonHostname {
+		t.Errorf("expected request to be allowed by hostname, but got: %+v", decision)
+	}
+
+	t.Run("Refresh", func(t *testing.T) {
+		server.setRecords("admin.dyndns.example.net.", []string{"77.88.8.9"})
+
+		// NB: the hostnames are refreshed by the request that finds them due, before the request is handled.
+		if status := request("77.88.8.9"); status != http.StatusTeapot {
+			t.Errorf("expected hostname to be resolved again, but got status code: %d", status)
+		}
+		if status := request("77.88.8.8"); status != http.StatusForbidden {
+			t.Errorf("expected previous address to be removed, but got status code: %d", status)
+		}
+	})
+
+	t.Run("KeepLastGoodResult", func(t *testing.T) {
+		server.setRecords("admin.dyndns.example.net.", nil)
+
+		if status := request("77.88.8.9"); status != http.StatusTeapot {
+			t.Errorf("expected last known address to be kept, but got status code: %d", status)
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package traefik_plugin_geoblock
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Reasons for a decision, naming the kind of rule that matched.
+const (
+	reasonPrivate    = "private"
+	reasonIPBlock    = "ip-block"
+	reasonHostname   = "hostname"
+	reasonProxy      = "proxy"
+	reasonReverseDNS = "reverse-dns"
+	reasonASN        = "asn"
+	reasonUsageType  = "usage-type"
+	reasonRegion     = "region"
+	reasonCountry    = "country"
+	reasonDefault    = "default"
+
+	// reasonPresetPrefix is followed by the name and version of the preset, e.g. "preset:sanctions@2026.1".
+	reasonPresetPrefix = "preset:"
+)
+
+// Decision describes whether a remote IP address is allowed, and why.
+type Decision struct {
+	Allowed   bool   // Whether the IP address is allowed
+	IP        string // The checked IP address
+	Country   string // ISO 3166-1 alpha-2 country code, "-" for private networks
+	Region    string // Region name as reported by the database, empty if not looked up
+	ProxyType string // IP2Proxy proxy type (e.g. VPN, TOR), "-" for non-proxies and empty if unknown
+	Reason    string // The kind of rule that led to the decision
+	Policy    string // The policy the decision is based on (e.g. "path:/admin"), empty for the global policy
+
+	StatusCode int // HTTP status code to respond with if not allowed, 0 for the configured default
+
+	action *blockAction // Response of the rule the decision is based on, if it overrides the default
+}
+
+// String returns a compact, log-friendly representation of the decision.
+func (d Decision) String() string {
+	var sb strings.Builder
+
+	if d.Allowed {
+		sb.WriteString("allowed")
+	} else {
+		sb.WriteString("blocked")
+	}
+	fmt.Fprintf(&sb, " ip=%s country=%s", d.IP, d.Country)
+	if d.Region != "" {
+		fmt.Fprintf(&sb, " region=%q", d.Region)
+	}
+	if d.ProxyType != "" {
+		fmt.Fprintf(&sb, " proxyType=%s", d.ProxyType)
+	}
+	if d.Policy != "" {
+		fmt.Fprintf(&sb, " policy=%s", d.Policy)
+	}
+	fmt.Fprintf(&sb, " reason=%s", d.Reason)
+
+	return sb.String()
+}
+
+// with returns a copy of the decision with the given outcome and reason.
+func (d Decision) with(allowed bool, reason string) Decision {
+	d.Allowed = allowed
+	d.Reason = reason
+
+	return d
+}
+
+// decidedBy names what the decision is based on: the name of the policy, "global" for the global policy, or the kind
+// of exemption for decisions made before any policy is evaluated, e.g. "bypass" or "crawler".
+func (d Decision) decidedBy() string {
+	if d.Policy != "" {
+		return d.Policy
+	}
+	for _, prefix := range []string{reasonPresetPrefix, reasonBypassPrefix, reasonBypassTokenPrefix, reasonBypassCertPrefix, reasonCrawlerPrefix} {
+		if strings.HasPrefix(d.Reason, prefix) {
+			return strings.TrimSuffix(prefix, ":")
+		}
+	}
+	if d.Reason == reasonPreflight {
+		return reasonPreflight
+	}
+
+	return "global"
+}
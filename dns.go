@@ -0,0 +1,296 @@
+package traefik_plugin_geoblock
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for DNS lookups.
+const (
+	defaultDNSTimeout   = 2 * time.Second
+	defaultDNSCacheTTL  = time.Hour
+	defaultDNSCacheSize = 10000
+
+	// dnsFailureCacheTTL is how long failed lookups are cached, so a slow or failing DNS server doesn't delay every
+	// request from the same IP address by the full timeout. It is capped by the configured cache TTL.
+	dnsFailureCacheTTL = 30 * time.Second
+)
+
+// dnsResolver performs reverse and forward DNS lookups, as implemented by net.Resolver.
+type dnsResolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// reverseDNS looks up the hostnames of IP addresses, confirmed by forward lookups, and caches them.
+type reverseDNS struct {
+	resolver    dnsResolver
+	timeout     time.Duration
+	cache       *hostnameCache
+	failureMode string // Whether reverse DNS conditions fail "open" or "closed" if hostnames can't be looked up
+	logf        func(format string, args ...interface{})
+
+	mu       sync.Mutex
+	inflight map[string]*reverseDNSLookup // Running lookups, by IP address
+}
+
+// reverseDNSLookup is a running lookup, shared by all requests from the same IP address until it completes.
+type reverseDNSLookup struct {
+	done  chan struct{} // Closed when the lookup completed
+	names []string
+	err   error
+}
+
+// initDNSResolver creates a resolver using the configured DNS server (host:port), or the system resolver
+// if none is configured, and returns it along with the configured timeout.
+func initDNSResolver(cfg *Config) (dnsResolver, time.Duration, error) {
+	var resolver dnsResolver = net.DefaultResolver
+	timeout := defaultDNSTimeout
+
+	if server := cfg.DNSServer; server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			return nil, 0, fmt.Errorf("invalid DNS server %q, must be host:port", server)
+		}
+
+		dialer := &net.Dialer{}
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	if cfg.DNSTimeout != "" {
+		d, err := time.ParseDuration(cfg.DNSTimeout)
+		if err != nil || d <= 0 {
+			return nil, 0, fmt.Errorf("invalid DNS timeout %q", cfg.DNSTimeout)
+		}
+		timeout = d
+	}
+
+	return resolver, timeout, nil
+}
+
+// initReverseDNS creates a reverse DNS lookup with the configured DNS settings.
+func initReverseDNS(cfg *Config, logf func(format string, args ...interface{})) (*reverseDNS, error) {
+	resolver, timeout, err := initDNSResolver(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r := &reverseDNS{resolver: resolver, timeout: timeout, logf: logf, inflight: make(map[string]*reverseDNSLookup)}
+
+	ttl := defaultDNSCacheTTL
+	if cfg.DNSCacheTTL != "" {
+		d, err := time.ParseDuration(cfg.DNSCacheTTL)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid DNS cache TTL %q", cfg.DNSCacheTTL)
+		}
+		ttl = d
+	}
+
+	cacheSize := cfg.DNSCacheSize
+	if cacheSize < 0 {
+		return nil, fmt.Errorf("invalid DNS cache size %d", cacheSize)
+	} else if cacheSize == 0 {
+		cacheSize = defaultDNSCacheSize
+	}
+	r.cache = newHostnameCache(cacheSize, ttl)
+
+	if r.failureMode, err = initReverseDNSFailureMode(cfg.ReverseDNSFailureMode); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// hostnames returns the lower case hostnames of the IP address (without trailing dot) whose forward lookup
+// resolves to the IP address again. Hostnames of addresses without PTR records are empty. Failed lookups are cached
+// for a short time as well, and logged once when they are cached rather than whenever the cached failure is used.
+//
+// Concurrent requests from the same IP address share a single lookup. It doesn't depend on the context of any of
+// them, so it finishes within the DNS timeout even if the request that started it is canceled, while each request
+// stops waiting for it as soon as its own context is done.
+func (r *reverseDNS) hostnames(ctx context.Context, ip net.IP, now time.Time) ([]string, error) {
+	key := ip.String()
+	if names, ok, err := r.cache.get(key, now); ok {
+		return names, err
+	}
+
+	r.mu.Lock()
+	running, ok := r.inflight[key]
+	if !ok {
+		running = &reverseDNSLookup{done: make(chan struct{})}
+		r.inflight[key] = running
+		go r.run(running, ip, key, now)
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-running.done:
+		return running.names, running.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("reverse DNS lookup of %s canceled: %w", key, ctx.Err())
+	}
+}
+
+// run performs a shared lookup and caches its result.
+func (r *reverseDNS) run(running *reverseDNSLookup, ip net.IP, key string, now time.Time) {
+	running.names, running.err = r.lookup(context.Background(), ip, key)
+	if running.err != nil {
+		ttl := r.cache.setFailure(key, running.err, now)
+		r.logf("%v, reverse DNS conditions fail %s and crawlers are not verified for %s", running.err, r.failureMode, ttl)
+	} else {
+		r.cache.set(key, running.names, now)
+	}
+
+	r.mu.Lock()
+	delete(r.inflight, key)
+	r.mu.Unlock()
+	close(running.done)
+}
+
+// lookup looks up the forward-confirmed hostnames of the IP address, without caching them.
+func (r *reverseDNS) lookup(ctx context.Context, ip net.IP, key string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	ptrNames, err := r.resolver.LookupAddr(ctx, key)
+	if err != nil && !isDNSNotFound(err) {
+		return nil, fmt.Errorf("reverse DNS lookup of %s failed: %w", key, err)
+	}
+
+	var names []string
+	for _, name := range ptrNames {
+		// NB: the trailing dot prevents search domains from being applied to the forward lookup.
+		name = strings.ToLower(name)
+		if !strings.HasSuffix(name, ".") {
+			name += "."
+		}
+
+		addrs, err := r.resolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			if isDNSNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("forward DNS lookup of %s failed: %w", name, err)
+		}
+
+		for _, addr := range addrs {
+			if addr.IP.Equal(ip) {
+				names = append(names, strings.TrimSuffix(name, "."))
+				break
+			}
+		}
+	}
+
+	return names, nil
+}
+
+// isDNSNotFound indicates whether a lookup failed because the name doesn't exist.
+func isDNSNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// matchesDomain indicates whether the hostname equals the domain or is a subdomain of it.
+func matchesDomain(hostname, domain string) bool {
+	return hostname == domain || strings.HasSuffix(hostname, "."+domain)
+}
+
+// hostnameCache is a bounded cache of hostnames by IP address, evicting the least recently used entries.
+type hostnameCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// hostnameCacheEntry is an entry of the hostname cache.
+type hostnameCacheEntry struct {
+	key     string
+	names   []string
+	err     error // Error of a failed lookup, cached for a shorter time
+	expires time.Time
+}
+
+// newHostnameCache creates a cache holding up to size entries for the given time to live.
+func newHostnameCache(size int, ttl time.Duration) *hostnameCache {
+	return &hostnameCache{size: size, ttl: ttl, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// get returns the cached hostnames for the key, or the error of a failed lookup, unless they expired.
+func (c *hostnameCache) get(key string, now time.Time) ([]string, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	entry := elem.Value.(*hostnameCacheEntry)
+	if !now.Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	c.lru.MoveToFront(elem)
+
+	return entry.names, true, entry.err
+}
+
+// set caches the hostnames for the key, evicting the least recently used entry if the cache is full.
+func (c *hostnameCache) set(key string, names []string, now time.Time) {
+	c.put(&hostnameCacheEntry{key: key, names: names, expires: now.Add(c.ttl)})
+}
+
+// setFailure caches the error of a failed lookup for the key, for the failure TTL or the cache TTL if shorter,
+// and returns how long it is cached.
+func (c *hostnameCache) setFailure(key string, err error, now time.Time) time.Duration {
+	ttl := dnsFailureCacheTTL
+	if c.ttl < ttl {
+		ttl = c.ttl
+	}
+
+	c.put(&hostnameCacheEntry{key: key, err: err, expires: now.Add(ttl)})
+
+	return ttl
+}
+
+// put adds the entry, evicting the least recently used entry if the cache is full.
+func (c *hostnameCache) put(entry *hostnameCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := entry.key
+
+	if elem, ok := c.entries[key]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	}
+
+	if c.lru.Len() >= c.size {
+		if oldest := c.lru.Back(); oldest != nil {
+			c.lru.Remove(oldest)
+			delete(c.entries, oldest.Value.(*hostnameCacheEntry).key)
+		}
+	}
+
+	c.entries[key] = c.lru.PushFront(entry)
+}
+
+// len returns the number of cached entries.
+func (c *hostnameCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
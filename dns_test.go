@@ -0,0 +1,400 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// DNS record types served by the test DNS server.
+const (
+	testDNSTypeA    = 1
+	testDNSTypePTR  = 12
+	testDNSTypeAAAA = 28
+)
+
+// testDNSServer is a minimal UDP DNS server answering A, AAAA and PTR queries from static records.
+type testDNSServer struct {
+	addr    string
+	queries int64
+
+	mu      sync.Mutex
+	records map[string][]string // Lower case names with trailing dot, to IP addresses or PTR names
+	delay   time.Duration
+}
+
+// startTestDNSServer starts a DNS server on a random local port, stopped when the test finishes.
+// PTR records are given by IP address, e.g. "66.249.66.1": {"crawl-66-249-66-1.googlebot.com."}.
+func startTestDNSServer(t *testing.T, records map[string][]string) *testDNSServer {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start DNS server: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	s := &testDNSServer{addr: conn.LocalAddr().String(), records: make(map[string][]string)}
+	for name, values := range records {
+		if ip := net.ParseIP(name); ip != nil {
+			name = reverseName(ip)
+		}
+		s.records[strings.ToLower(name)] = values
+	}
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if response := s.respond(buf[:n]); response != nil {
+				_, _ = conn.WriteTo(response, addr)
+			}
+		}
+	}()
+
+	return s
+}
+
+// setDelay delays all following responses.
+func (s *testDNSServer) setDelay(delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delay = delay
+}
+
+// setRecords replaces the records of a name, or removes them if values is nil.
+func (s *testDNSServer) setRecords(name string, values []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if values == nil {
+		delete(s.records, strings.ToLower(name))
+	} else {
+		s.records[strings.ToLower(name)] = values
+	}
+}
+
+// queryCount returns the number of queries received so far.
+func (s *testDNSServer) queryCount() int {
+	return int(atomic.LoadInt64(&s.queries))
+}
+
+// reverseName returns the name of the PTR record of an IP address.
+func reverseName(ip net.IP) string {
+	var labels []string
+	if ip4 := ip.To4(); ip4 != nil {
+		for i := len(ip4) - 1; i >= 0; i-- {
+			labels = append(labels, strconv.Itoa(int(ip4[i])))
+		}
+		return strings.Join(labels, ".") + ".in-addr.arpa."
+	}
+
+	const hexDigits = "0123456789abcdef"
+	for i := len(ip) - 1; i >= 0; i-- {
+		labels = append(labels, string(hexDigits[ip[i]&0xf]), string(hexDigits[ip[i]>>4]))
+	}
+	return strings.Join(labels, ".") + ".ip6.arpa."
+}
+
+// respond answers a DNS query, or returns nil if it can't be parsed.
+func (s *testDNSServer) respond(query []byte) []byte {
+	atomic.AddInt64(&s.queries, 1)
+
+	s.mu.Lock()
+	delay := s.delay
+	s.mu.Unlock()
+	time.Sleep(delay)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(query) < 12 {
+		return nil
+	}
+
+	// Parse the question, which directly follows the header.
+	var labels []string
+	pos := 12
+	for pos < len(query) && query[pos] != 0 {
+		length := int(query[pos])
+		if pos+1+length > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[pos+1:pos+1+length]))
+		pos += 1 + length
+	}
+	if pos+5 > len(query) {
+		return nil
+	}
+	questionEnd := pos + 5
+	qtype := binary.BigEndian.Uint16(query[pos+1:])
+	name := strings.ToLower(strings.Join(labels, ".")) + "."
+
+	values, exists := s.records[name]
+
+	var answers [][]byte
+	for _, value := range values {
+		var rtype uint16
+		var rdata []byte
+		switch ip := net.ParseIP(value); {
+		case qtype == testDNSTypePTR && ip == nil:
+			rtype, rdata = testDNSTypePTR, encodeDNSName(value)
+		case qtype == testDNSTypeA && ip != nil && ip.To4() != nil:
+			rtype, rdata = testDNSTypeA, ip.To4()
+		case qtype == testDNSTypeAAAA && ip != nil && ip.To4() == nil:
+			rtype, rdata = testDNSTypeAAAA, ip.To16()
+		default:
+			continue
+		}
+
+		answer := []byte{0xc0, 12} // NB: pointer to the name of the question
+		answer = binary.BigEndian.AppendUint16(answer, rtype)
+		answer = binary.BigEndian.AppendUint16(answer, 1) // IN
+		answer = binary.BigEndian.AppendUint32(answer, 60)
+		answer = binary.BigEndian.AppendUint16(answer, uint16(len(rdata)))
+		answers = append(answers, append(answer, rdata...))
+	}
+
+	response := make([]byte, 12, 512)
+	copy(response, query[:2])
+	flags := uint16(0x8180) // NB: response, recursion desired and available
+	if !exists {
+		flags |= 3 // NXDOMAIN
+	}
+	binary.BigEndian.PutUint16(response[2:], flags)
+	binary.BigEndian.PutUint16(response[4:], 1)
+	binary.BigEndian.PutUint16(response[6:], uint16(len(answers)))
+	response = append(response, query[12:questionEnd]...)
+	for _, answer := range answers {
+		response = append(response, answer...)
+	}
+
+	return response
+}
+
+// encodeDNSName encodes a name in the DNS wire format.
+func encodeDNSName(name string) []byte {
+	var encoded []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		encoded = append(encoded, byte(len(label)))
+		encoded = append(encoded, label...)
+	}
+
+	return append(encoded, 0)
+}
+
+func TestReverseDNS_Hostnames(t *testing.T) {
+	server := startTestDNSServer(t, map[string][]string{
+		"66.249.66.1":                               {"crawl-66-249-66-1.googlebot.com."},
+		"crawl-66-249-66-1.googlebot.com.":          {"66.249.66.1"},
+		"2001:4860:4801:10::1":                      {"crawl-2001-4860-4801-10--1.googlebot.com."},
+		"crawl-2001-4860-4801-10--1.googlebot.com.": {"2001:4860:4801:10::1"},
+		"203.0.113.7":                               {"crawl-203-0-113-7.googlebot.com.", "host.example.net."},
+		"crawl-203-0-113-7.googlebot.com.":          {"66.249.66.1"},
+		"host.example.net.":                         {"203.0.113.7"},
+	})
+
+	rdns, err := initReverseDNS(&Config{DNSServer: server.addr, DNSTimeout: "1s", DNSCacheTTL: "1h", DNSCacheSize: 2}, t.Logf)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	now := time.Now()
+	for _, test := range []struct{ ip, expected string }{
+		{"66.249.66.1", "crawl-66-249-66-1.googlebot.com"},
+		{"2001:4860:4801:10::1", "crawl-2001-4860-4801-10--1.googlebot.com"},
+		{"203.0.113.7", "host.example.net"},
+		{"198.51.100.1", ""},
+	} {
+		t.Run(test.ip, func(t *testing.T) {
+			hostnames, err := rdns.hostnames(context.Background(), net.ParseIP(test.ip), now)
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if actual := strings.Join(hostnames, ","); actual != test.expected {
+				t.Errorf("expected %q, but got: %q", test.expected, actual)
+			}
+		})
+	}
+
+	t.Run("Cache", func(t *testing.T) {
+		if size := rdns.cache.len(); size != 2 {
+			t.Errorf("expected cache to be bounded to 2 entries, but got: %d", size)
+		}
+
+		queries := server.queryCount()
+		if _, err := rdns.hostnames(context.Background(), net.ParseIP("198.51.100.1"), now); err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if server.queryCount() != queries {
+			t.Error("expected cached hostnames to be used, but DNS server was queried")
+		}
+
+		if _, err := rdns.hostnames(context.Background(), net.ParseIP("198.51.100.1"), now.Add(2*time.Hour)); err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if server.queryCount() == queries {
+			t.Error("expected expired hostnames to be looked up again, but DNS server was not queried")
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		server.setDelay(200 * time.Millisecond)
+		t.Cleanup(func() { server.setDelay(0) })
+
+		rdns, err := initReverseDNS(&Config{DNSServer: server.addr, DNSTimeout: "50ms"}, t.Logf)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if _, err := rdns.hostnames(context.Background(), net.ParseIP("66.249.66.1"), now); err == nil {
+			t.Error("expected error, but got none")
+		}
+	})
+
+	t.Run("FailureCached", func(t *testing.T) {
+		resolver := &failingResolver{}
+		logs := 0
+		logf := func(format string, args ...interface{}) { logs++ }
+		rdns := &reverseDNS{resolver: resolver, timeout: time.Second, cache: newHostnameCache(10, time.Hour), logf: logf,
+			inflight: make(map[string]*reverseDNSLookup)}
+
+		for _, at := range []time.Time{now, now.Add(dnsFailureCacheTTL / 2)} {
+			if _, err := rdns.hostnames(context.Background(), net.ParseIP("66.249.66.1"), at); err == nil {
+				t.Error("expected error, but got none")
+			}
+		}
+		if resolver.calls != 1 {
+			t.Errorf("expected failed lookup to be cached, but got %d lookups", resolver.calls)
+		}
+		if logs != 1 {
+			t.Errorf("expected failed lookup to be logged once while cached, but got %d logs", logs)
+		}
+
+		if _, err := rdns.hostnames(context.Background(), net.ParseIP("66.249.66.1"), now.Add(dnsFailureCacheTTL)); err == nil {
+			t.Error("expected error, but got none")
+		}
+		if resolver.calls != 2 {
+			t.Errorf("expected expired failure to be looked up again, but got %d lookups", resolver.calls)
+		}
+	})
+}
+
+func TestReverseDNS_HostnamesConcurrent(t *testing.T) {
+	now := time.Now()
+
+	t.Run("Coalesced", func(t *testing.T) {
+		resolver := &blockingResolver{release: make(chan struct{})}
+		rdns := &reverseDNS{resolver: resolver, timeout: time.Second, cache: newHostnameCache(10, time.Hour), logf: t.Logf,
+			inflight: make(map[string]*reverseDNSLookup)}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := rdns.hostnames(context.Background(), net.ParseIP("66.249.66.1"), now); err != nil {
+					t.Errorf("expected no error, but got: %v", err)
+				}
+			}()
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(resolver.release)
+		wg.Wait()
+
+		if calls := atomic.LoadInt64(&resolver.calls); calls != 1 {
+			t.Errorf("expected concurrent lookups to be coalesced, but got %d lookups", calls)
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		resolver := &blockingResolver{release: make(chan struct{})}
+		rdns := &reverseDNS{resolver: resolver, timeout: time.Hour, cache: newHostnameCache(10, time.Hour), logf: t.Logf,
+			inflight: make(map[string]*reverseDNSLookup)}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		if _, err := rdns.hostnames(ctx, net.ParseIP("66.249.66.1"), now); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected request to stop waiting when canceled, but got: %v", err)
+		}
+
+		// NB: the lookup started by the canceled request keeps running for other requests from the same address.
+		result := make(chan error)
+		go func() {
+			_, err := rdns.hostnames(context.Background(), net.ParseIP("66.249.66.1"), now)
+			result <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+		close(resolver.release)
+
+		if err := <-result; err != nil {
+			t.Errorf("expected no error for a request that wasn't canceled, but got: %v", err)
+		}
+		if calls := atomic.LoadInt64(&resolver.calls); calls != 1 {
+			t.Errorf("expected the running lookup to be shared, but got %d lookups", calls)
+		}
+	})
+}
+
+func TestInitReverseDNS_Errors(t *testing.T) {
+	for name, cfg := range map[string]*Config{
+		"InvalidServer":      {DNSServer: "127.0.0.1"},
+		"InvalidTimeout":     {DNSTimeout: "soon"},
+		"InvalidCacheTTL":    {DNSCacheTTL: "-1h"},
+		"InvalidCacheSize":   {DNSCacheSize: -1},
+		"InvalidFailureMode": {ReverseDNSFailureMode: "ajar"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := initReverseDNS(cfg, t.Logf); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+// failingResolver fails all lookups, counting them.
+type failingResolver struct {
+	calls int
+}
+
+func (r *failingResolver) LookupAddr(context.Context, string) ([]string, error) {
+	r.calls++
+	return nil, &net.DNSError{Err: "server misbehaving", IsTemporary: true}
+}
+
+func (r *failingResolver) LookupIPAddr(context.Context, string) ([]net.IPAddr, error) {
+	r.calls++
+	return nil, &net.DNSError{Err: "server misbehaving", IsTemporary: true}
+}
+
+// blockingResolver blocks all lookups until released or canceled, counting them.
+type blockingResolver struct {
+	release chan struct{}
+	calls   int64
+}
+
+func (r *blockingResolver) LookupAddr(ctx context.Context, _ string) ([]string, error) {
+	atomic.AddInt64(&r.calls, 1)
+
+	select {
+	case <-r.release:
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (r *blockingResolver) LookupIPAddr(ctx context.Context, _ string) ([]net.IPAddr, error) {
+	return nil, ctx.Err()
+}
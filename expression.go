@@ -0,0 +1,933 @@
+package traefik_plugin_geoblock
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Token kinds of the policy expression language.
+const (
+	tokenEOF = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenGroup
+	tokenLParen
+	tokenRParen
+	tokenLBracket
+	tokenRBracket
+	tokenComma
+	tokenNot
+	tokenAnd
+	tokenOr
+	tokenEqual
+	tokenNotEqual
+)
+
+// exprPunctuation maps single-character punctuation to token kinds.
+var exprPunctuation = map[rune]int{'(': tokenLParen, ')': tokenRParen, '[': tokenLBracket, ']': tokenRBracket, ',': tokenComma}
+
+// exprOperators maps two-character operators to token kinds.
+var exprOperators = map[string]int{"&&": tokenAnd, "||": tokenOr, "==": tokenEqual, "!=": tokenNotEqual}
+
+// exprToken is a token of a policy expression. Positions are 1-based character offsets.
+type exprToken struct {
+	kind  int
+	text  string
+	value string
+	pos   int
+}
+
+// exprError is an error at a position of a policy expression.
+type exprError struct {
+	pos int
+	msg string
+}
+
+func (e *exprError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.pos, e.msg)
+}
+
+// errorAt creates an error at the given position of a policy expression.
+func errorAt(pos int, format string, args ...interface{}) error {
+	return &exprError{pos: pos, msg: fmt.Sprintf(format, args...)}
+}
+
+// tokenizeExpression splits a policy expression into tokens.
+func tokenizeExpression(expr string) ([]exprToken, error) {
+	var tokens []exprToken
+
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		pos := i + 1
+
+		if unicode.IsSpace(r) {
+			i++
+			continue
+		}
+		if kind, ok := exprPunctuation[r]; ok {
+			tokens = append(tokens, exprToken{kind: kind, text: string(r), pos: pos})
+			i++
+			continue
+		}
+		if i+1 < len(runes) {
+			if kind, ok := exprOperators[string(runes[i:i+2])]; ok {
+				tokens = append(tokens, exprToken{kind: kind, text: string(runes[i : i+2]), pos: pos})
+				i += 2
+				continue
+			}
+		}
+
+		switch {
+		case r == '!':
+			tokens = append(tokens, exprToken{kind: tokenNot, text: "!", pos: pos})
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				if runes[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(runes) {
+				return nil, errorAt(pos, "unterminated string")
+			}
+			text := string(runes[i : end+1])
+			value, err := strconv.Unquote(text)
+			if err != nil {
+				return nil, errorAt(pos, "invalid string %s", text)
+			}
+			tokens = append(tokens, exprToken{kind: tokenString, text: text, value: value, pos: pos})
+			i = end + 1
+		case unicode.IsDigit(r):
+			end := i
+			for end < len(runes) && unicode.IsDigit(runes[end]) {
+				end++
+			}
+			tokens = append(tokens, exprToken{kind: tokenNumber, text: string(runes[i:end]), pos: pos})
+			i = end
+		case r == '@' || unicode.IsLetter(r) || r == '_':
+			end := i + 1
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || runes[end] == '_') {
+				end++
+			}
+			kind := tokenIdent
+			if r == '@' {
+				kind = tokenGroup
+				if end == i+1 {
+					return nil, errorAt(pos, "missing country group name after @")
+				}
+			}
+			tokens = append(tokens, exprToken{kind: kind, text: string(runes[i:end]), pos: pos})
+			i = end
+		default:
+			return nil, errorAt(pos, "unexpected character %q", r)
+		}
+	}
+
+	return append(tokens, exprToken{kind: tokenEOF, text: "end of expression", pos: len(runes) + 1}), nil
+}
+
+// Node kinds of a parsed policy expression.
+const (
+	nodeOr = iota
+	nodeAnd
+	nodeNot
+	nodeEqual
+	nodeNotEqual
+	nodeIn
+	nodeMatches
+	nodeIdent
+	nodeCall
+	nodeString
+	nodeNumber
+	nodeBool
+	nodeList
+	nodeGroup
+)
+
+// exprNode is a node of a parsed policy expression.
+type exprNode struct {
+	kind     int
+	pos      int
+	text     string
+	children []*exprNode
+}
+
+// exprParser is a recursive descent parser for policy expressions:
+//
+//	or         = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | comparison
+//	comparison = operand [ ( "==" | "!=" | "in" | "matches" ) operand ]
+//	operand    = string | number | "true" | "false" | @group | list | ident [ "(" [ or { "," or } ] ")" ] | "(" or ")"
+//	list       = "[" [ operand { "," operand } ] "]"
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+// parseExpression parses a policy expression into a tree.
+func parseExpression(expr string) (*exprNode, error) {
+	tokens, err := tokenizeExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{tokens: tokens}
+
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, errorAt(tok.pos, "unexpected %s", tok.text)
+	}
+
+	return node, nil
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+
+	return tok
+}
+
+func (p *exprParser) expect(kind int, what string) (exprToken, error) {
+	tok := p.next()
+	if tok.kind != kind {
+		return tok, errorAt(tok.pos, "expected %s, but got %s", what, tok.text)
+	}
+
+	return tok, nil
+}
+
+func (p *exprParser) parseOr() (*exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokenOr {
+		tok := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprNode{kind: nodeOr, pos: tok.pos, text: tok.text, children: []*exprNode{left, right}}
+	}
+
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (*exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokenAnd {
+		tok := p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprNode{kind: nodeAnd, pos: tok.pos, text: tok.text, children: []*exprNode{left, right}}
+	}
+
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (*exprNode, error) {
+	if p.peek().kind == tokenNot {
+		tok := p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return &exprNode{kind: nodeNot, pos: tok.pos, text: tok.text, children: []*exprNode{operand}}, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (*exprNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+	kind := -1
+	switch {
+	case tok.kind == tokenEqual:
+		kind = nodeEqual
+	case tok.kind == tokenNotEqual:
+		kind = nodeNotEqual
+	case tok.kind == tokenIdent && tok.text == "in":
+		kind = nodeIn
+	case tok.kind == tokenIdent && tok.text == "matches":
+		kind = nodeMatches
+	}
+	if kind < 0 {
+		return left, nil
+	}
+	p.next()
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	return &exprNode{kind: kind, pos: tok.pos, text: tok.text, children: []*exprNode{left, right}}, nil
+}
+
+func (p *exprParser) parseOperand() (*exprNode, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokenString:
+		return &exprNode{kind: nodeString, pos: tok.pos, text: tok.value}, nil
+	case tokenNumber:
+		return &exprNode{kind: nodeNumber, pos: tok.pos, text: tok.text}, nil
+	case tokenGroup:
+		return &exprNode{kind: nodeGroup, pos: tok.pos, text: tok.text}, nil
+	case tokenLParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRParen, ")"); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case tokenLBracket:
+		list := &exprNode{kind: nodeList, pos: tok.pos, text: "list"}
+		if p.peek().kind == tokenRBracket {
+			p.next()
+			return list, nil
+		}
+		for {
+			item, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			list.children = append(list.children, item)
+
+			sep := p.next()
+			if sep.kind == tokenRBracket {
+				return list, nil
+			}
+			if sep.kind != tokenComma {
+				return nil, errorAt(sep.pos, "expected , or ], but got %s", sep.text)
+			}
+		}
+	case tokenIdent:
+		switch tok.text {
+		case "true", "false":
+			return &exprNode{kind: nodeBool, pos: tok.pos, text: tok.text}, nil
+		case "in", "matches":
+			return nil, errorAt(tok.pos, "unexpected %s", tok.text)
+		}
+		if p.peek().kind != tokenLParen {
+			return &exprNode{kind: nodeIdent, pos: tok.pos, text: tok.text}, nil
+		}
+		p.next()
+
+		call := &exprNode{kind: nodeCall, pos: tok.pos, text: tok.text}
+		if p.peek().kind == tokenRParen {
+			p.next()
+			return call, nil
+		}
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.children = append(call.children, arg)
+
+			sep := p.next()
+			if sep.kind == tokenRParen {
+				return call, nil
+			}
+			if sep.kind != tokenComma {
+				return nil, errorAt(sep.pos, "expected , or ), but got %s", sep.text)
+			}
+		}
+	default:
+		return nil, errorAt(tok.pos, "unexpected %s", tok.text)
+	}
+}
+
+// Types of policy expression values.
+const (
+	typeBool = iota
+	typeNumber
+	typeString
+	typeStringList
+	typeNumberList
+)
+
+// exprTypeNames are the names of expression value types, for error messages.
+var exprTypeNames = map[int]string{
+	typeBool:       "bool",
+	typeNumber:     "number",
+	typeString:     "string",
+	typeStringList: "list of strings",
+	typeNumberList: "list of numbers",
+}
+
+// compiledExpr is a type-checked policy expression, compiled into a function of the matching type.
+// Lists are always constant, and are compiled into sets.
+type compiledExpr struct {
+	typ      int
+	boolFn   func(ctx *evalContext) (bool, error)
+	numberFn func(ctx *evalContext) (uint, error)
+	stringFn func(ctx *evalContext) (string, error)
+	listFn   func(ctx *evalContext) ([]string, error)
+
+	strings []string
+	numbers map[uint]struct{}
+
+	// normalize normalizes string constants compared with a variable, e.g. country codes.
+	normalize func(value string) (string, error)
+	// variable is the name of the variable the expression consists of, if any.
+	variable string
+}
+
+// exprVariable describes a variable of the policy expression language.
+type exprVariable struct {
+	typ       int
+	stringFn  func(ctx *evalContext) (string, error)
+	numberFn  func(ctx *evalContext) (uint, error)
+	listFn    func(ctx *evalContext) ([]string, error)
+	normalize func(value string) (string, error)
+	requires  func(req *ruleRequirements)
+}
+
+// exprVariables are the variables available in policy expressions.
+var exprVariables = map[string]exprVariable{
+	"country": {
+		typ:       typeString,
+		stringFn:  func(ctx *evalContext) (string, error) { return ctx.decision.Country, nil },
+		normalize: normalizeCountry,
+	},
+	"region": {
+		typ: typeString,
+		stringFn: func(ctx *evalContext) (string, error) {
+			region, err := ctx.region()
+			return normalizeRegionName(region), err
+		},
+		requires: func(req *ruleRequirements) { req.region = true },
+	},
+	"asn": {
+		typ: typeNumber,
+		numberFn: func(ctx *evalContext) (uint, error) {
+			info, err := ctx.asnInfo()
+			return info.Number, err
+		},
+		requires: func(req *ruleRequirements) { req.asn = true },
+	},
+	"usageType": {
+		typ: typeStringList,
+		listFn: func(ctx *evalContext) ([]string, error) {
+			return ctx.usageTypeList()
+		},
+		normalize: func(value string) (string, error) {
+			normalized := strings.ToUpper(strings.TrimSpace(value))
+			if _, ok := knownUsageTypes[normalized]; !ok {
+				return "", fmt.Errorf("%q is not a known usage type", value)
+			}
+			return normalized, nil
+		},
+		requires: func(req *ruleRequirements) { req.usageType = true },
+	},
+	"ip": {
+		typ:      typeString,
+		stringFn: func(ctx *evalContext) (string, error) { return ctx.ip.String(), nil },
+		normalize: func(value string) (string, error) {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return "", fmt.Errorf("%q is not a valid IP address", value)
+			}
+			return ip.String(), nil
+		},
+	},
+	"path": {
+		typ: typeString,
+		stringFn: func(ctx *evalContext) (string, error) {
+			return ctx.path, nil
+		},
+	},
+	"method": {
+		typ:       typeString,
+		stringFn:  func(ctx *evalContext) (string, error) { return ctx.method, nil },
+		normalize: func(value string) (string, error) { return strings.ToUpper(value), nil },
+	},
+	"host": {
+		typ: typeString,
+		stringFn: func(ctx *evalContext) (string, error) {
+			if ctx.req == nil {
+				return "", nil
+			}
+			host := ctx.req.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			return strings.ToLower(host), nil
+		},
+		normalize: func(value string) (string, error) { return strings.ToLower(value), nil },
+	},
+}
+
+// exprCompiler type-checks and compiles parsed policy expressions.
+type exprCompiler struct {
+	countryGroups map[string][]string
+	requirements  ruleRequirements
+}
+
+// expressionCondition matches requests for which a policy expression evaluates to true.
+type expressionCondition struct {
+	eval         func(ctx *evalContext) (bool, error)
+	requirements ruleRequirements
+}
+
+func (c expressionCondition) matches(ctx *evalContext) (bool, error) {
+	return c.eval(ctx)
+}
+
+// initExpression parses, type-checks and compiles a policy expression.
+func initExpression(expr string, countryGroups map[string][]string) (expressionCondition, error) {
+	node, err := parseExpression(expr)
+	if err != nil {
+		return expressionCondition{}, err
+	}
+
+	c := &exprCompiler{countryGroups: countryGroups}
+
+	compiled, err := c.compile(node)
+	if err != nil {
+		return expressionCondition{}, err
+	}
+	if compiled.typ != typeBool {
+		return expressionCondition{}, errorAt(node.pos, "expression must be a condition, but is a %s", exprTypeNames[compiled.typ])
+	}
+
+	return expressionCondition{eval: compiled.boolFn, requirements: c.requirements}, nil
+}
+
+// compile type-checks and compiles a node of a parsed policy expression.
+func (c *exprCompiler) compile(node *exprNode) (*compiledExpr, error) {
+	switch node.kind {
+	case nodeString:
+		value := node.text
+		return &compiledExpr{typ: typeString, stringFn: func(*evalContext) (string, error) { return value, nil }}, nil
+	case nodeNumber:
+		number, err := strconv.ParseUint(node.text, 10, 32)
+		if err != nil {
+			return nil, errorAt(node.pos, "invalid number %s", node.text)
+		}
+		value := uint(number)
+		return &compiledExpr{typ: typeNumber, numberFn: func(*evalContext) (uint, error) { return value, nil }}, nil
+	case nodeBool:
+		value := node.text == "true"
+		return &compiledExpr{typ: typeBool, boolFn: func(*evalContext) (bool, error) { return value, nil }}, nil
+	case nodeGroup:
+		members, ok := c.countryGroups[strings.ToUpper(strings.TrimPrefix(node.text, countryGroupPrefix))]
+		if !ok {
+			return nil, errorAt(node.pos, "unknown country group %q", node.text)
+		}
+		return &compiledExpr{typ: typeStringList, strings: members}, nil
+	case nodeList:
+		return c.compileList(node)
+	case nodeIdent:
+		return c.compileVariable(node)
+	case nodeCall:
+		return c.compileCall(node)
+	case nodeNot:
+		operand, err := c.compileBool(node.children[0])
+		if err != nil {
+			return nil, err
+		}
+		return &compiledExpr{typ: typeBool, boolFn: func(ctx *evalContext) (bool, error) {
+			value, err := operand(ctx)
+			return !value, err
+		}}, nil
+	case nodeAnd, nodeOr:
+		left, err := c.compileBool(node.children[0])
+		if err != nil {
+			return nil, err
+		}
+		right, err := c.compileBool(node.children[1])
+		if err != nil {
+			return nil, err
+		}
+
+		// NB: both operators short-circuit, so lookups on the right-hand side are only done if necessary.
+		shortCircuit := node.kind == nodeOr
+		return &compiledExpr{typ: typeBool, boolFn: func(ctx *evalContext) (bool, error) {
+			value, err := left(ctx)
+			if errors.Is(err, errHostnamesUnavailable) {
+				// NB: unavailable hostnames only make the result unknown if the right-hand side doesn't decide it,
+				// e.g. rdns("corp.example.com") || country == "DE" still matches requests from DE.
+				if rightValue, rightErr := right(ctx); rightErr == nil && rightValue == shortCircuit {
+					return rightValue, nil
+				}
+				return value, err
+			}
+			if err != nil || value == shortCircuit {
+				return value, err
+			}
+			return right(ctx)
+		}}, nil
+	case nodeEqual, nodeNotEqual:
+		return c.compileEqual(node)
+	case nodeIn:
+		return c.compileIn(node)
+	case nodeMatches:
+		return c.compileMatches(node)
+	default:
+		return nil, errorAt(node.pos, "unexpected %s", node.text)
+	}
+}
+
+// compileBool compiles a node that must evaluate to a bool.
+func (c *exprCompiler) compileBool(node *exprNode) (func(ctx *evalContext) (bool, error), error) {
+	compiled, err := c.compile(node)
+	if err != nil {
+		return nil, err
+	}
+	if compiled.typ != typeBool {
+		return nil, errorAt(node.pos, "expected a condition, but got a %s", exprTypeNames[compiled.typ])
+	}
+
+	return compiled.boolFn, nil
+}
+
+// compileList compiles a constant list of strings or numbers.
+func (c *exprCompiler) compileList(node *exprNode) (*compiledExpr, error) {
+	list := &compiledExpr{typ: typeStringList, numbers: make(map[uint]struct{})}
+
+	for i, item := range node.children {
+		if item.kind != nodeString && item.kind != nodeNumber && item.kind != nodeGroup {
+			return nil, errorAt(item.pos, "lists may only contain strings, numbers and country groups")
+		}
+
+		itemType := typeStringList
+		if item.kind == nodeNumber {
+			itemType = typeNumberList
+		}
+		if i > 0 && itemType != list.typ {
+			return nil, errorAt(item.pos, "lists can't mix strings and numbers")
+		}
+		list.typ = itemType
+
+		compiled, err := c.compile(item)
+		if err != nil {
+			return nil, err
+		}
+		switch item.kind {
+		case nodeString:
+			list.strings = append(list.strings, item.text)
+		case nodeGroup:
+			list.strings = append(list.strings, compiled.strings...)
+		case nodeNumber:
+			number, _ := compiled.numberFn(nil)
+			list.numbers[number] = struct{}{}
+		}
+	}
+
+	return list, nil
+}
+
+// compileVariable compiles a reference to a variable.
+func (c *exprCompiler) compileVariable(node *exprNode) (*compiledExpr, error) {
+	variable, ok := exprVariables[node.text]
+	if !ok {
+		var names []string
+		for name := range exprVariables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		return nil, errorAt(node.pos, "unknown variable %q, must be one of: %s", node.text, strings.Join(names, ", "))
+	}
+
+	if variable.requires != nil {
+		variable.requires(&c.requirements)
+	}
+
+	return &compiledExpr{
+		typ:       variable.typ,
+		stringFn:  variable.stringFn,
+		numberFn:  variable.numberFn,
+		listFn:    variable.listFn,
+		normalize: variable.normalize,
+		variable:  node.text,
+	}, nil
+}
+
+// compileCall compiles a function call. Functions only accept constant arguments.
+func (c *exprCompiler) compileCall(node *exprNode) (*compiledExpr, error) {
+	arg, err := c.constantStringArg(node)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node.text {
+	case "cidr":
+		_, block, err := net.ParseCIDR(arg)
+		if err != nil {
+			return nil, errorAt(node.children[0].pos, "invalid CIDR %q", arg)
+		}
+		return &compiledExpr{typ: typeBool, boolFn: func(ctx *evalContext) (bool, error) {
+			return block.Contains(ctx.ip), nil
+		}}, nil
+	case "rdns":
+		patterns, err := initReverseDNSPatterns([]string{arg})
+		if err != nil {
+			return nil, errorAt(node.children[0].pos, "%v", err)
+		}
+		c.requirements.reverseDNS = true
+		return &compiledExpr{typ: typeBool, boolFn: reverseDNSCondition(patterns).matches}, nil
+	case "header":
+		name := arg
+		return &compiledExpr{typ: typeString, stringFn: func(ctx *evalContext) (string, error) {
+			if ctx.req == nil {
+				return "", nil
+			}
+			return ctx.req.Header.Get(name), nil
+		}}, nil
+	default:
+		return nil, errorAt(node.pos, "unknown function %q", node.text)
+	}
+}
+
+// constantStringArg returns the single string argument of a function call.
+func (c *exprCompiler) constantStringArg(node *exprNode) (string, error) {
+	if len(node.children) != 1 {
+		return "", errorAt(node.pos, "%s expects exactly one argument, but got %d", node.text, len(node.children))
+	}
+	if node.children[0].kind != nodeString {
+		return "", errorAt(node.children[0].pos, "%s expects a string argument", node.text)
+	}
+
+	return node.children[0].text, nil
+}
+
+// normalizeConstant normalizes a string constant compared with the given operand.
+func normalizeConstant(operand *compiledExpr, value string, pos int) (string, error) {
+	if operand.normalize == nil {
+		return value, nil
+	}
+
+	normalized, err := operand.normalize(value)
+	if err != nil {
+		return "", errorAt(pos, "%v", err)
+	}
+
+	return normalized, nil
+}
+
+// compileEqual compiles the == and != operators. Constants are normalized for the operand they are compared with,
+// whichever side they are on.
+func (c *exprCompiler) compileEqual(node *exprNode) (*compiledExpr, error) {
+	leftNode, rightNode := node.children[0], node.children[1]
+	if leftNode.kind == nodeString && rightNode.kind != nodeString {
+		leftNode, rightNode = rightNode, leftNode
+	}
+
+	left, err := c.compile(leftNode)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.compile(rightNode)
+	if err != nil {
+		return nil, err
+	}
+
+	negate := node.kind == nodeNotEqual
+	result := func(fn func(ctx *evalContext) (bool, error)) (*compiledExpr, error) {
+		return &compiledExpr{typ: typeBool, boolFn: func(ctx *evalContext) (bool, error) {
+			equal, err := fn(ctx)
+			return equal != negate, err
+		}}, nil
+	}
+
+	switch {
+	case left.typ == typeNumber && right.typ == typeNumber:
+		return result(func(ctx *evalContext) (bool, error) {
+			l, err := left.numberFn(ctx)
+			if err != nil {
+				return false, err
+			}
+			r, err := right.numberFn(ctx)
+			return l == r, err
+		})
+	case left.typ == typeBool && right.typ == typeBool:
+		return result(func(ctx *evalContext) (bool, error) {
+			l, err := left.boolFn(ctx)
+			if err != nil {
+				return false, err
+			}
+			r, err := right.boolFn(ctx)
+			return l == r, err
+		})
+	case left.variable == "region" && rightNode.kind == nodeString:
+		// NB: regions are matched like those of region in [...], e.g. "UA-43" or "DE:Bayern".
+		regions, err := initRegions([]string{rightNode.text})
+		if err != nil {
+			return nil, errorAt(rightNode.pos, "%v", err)
+		}
+		return result(func(ctx *evalContext) (bool, error) {
+			region, err := ctx.region()
+			if err != nil || region == "" {
+				return false, err
+			}
+			return regions.contains(ctx.decision.Country, region), nil
+		})
+	case (left.typ == typeString || left.typ == typeStringList) && right.typ == typeString && rightNode.kind == nodeString:
+		value, err := normalizeConstant(left, rightNode.text, rightNode.pos)
+		if err != nil {
+			return nil, err
+		}
+		if left.typ == typeStringList {
+			// NB: a list variable (e.g. usageType) equals a string if it contains it.
+			return result(func(ctx *evalContext) (bool, error) {
+				values, err := left.listFn(ctx)
+				for _, v := range values {
+					if v == value {
+						return true, err
+					}
+				}
+				return false, err
+			})
+		}
+		return result(func(ctx *evalContext) (bool, error) {
+			v, err := left.stringFn(ctx)
+			return v == value, err
+		})
+	case left.typ == typeString && right.typ == typeString:
+		return result(func(ctx *evalContext) (bool, error) {
+			l, err := left.stringFn(ctx)
+			if err != nil {
+				return false, err
+			}
+			r, err := right.stringFn(ctx)
+			return l == r, err
+		})
+	default:
+		return nil, errorAt(node.pos, "can't compare %s with %s", exprTypeNames[left.typ], exprTypeNames[right.typ])
+	}
+}
+
+// compileIn compiles the in operator, whose right-hand side must be a constant list or country group.
+func (c *exprCompiler) compileIn(node *exprNode) (*compiledExpr, error) {
+	left, err := c.compile(node.children[0])
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.compile(node.children[1])
+	if err != nil {
+		return nil, err
+	}
+	if node.children[1].kind != nodeList && node.children[1].kind != nodeGroup {
+		return nil, errorAt(node.children[1].pos, "expected a list or country group after in")
+	}
+
+	listPos := node.children[1].pos
+
+	switch {
+	case left.variable == "region" && right.typ == typeStringList:
+		// NB: regions are matched like the regions of allowedRegions / blockedRegions, e.g. ["UA-43", "DE:Bayern"].
+		regions, err := initRegions(right.strings)
+		if err != nil {
+			return nil, errorAt(listPos, "%v", err)
+		}
+		return &compiledExpr{typ: typeBool, boolFn: func(ctx *evalContext) (bool, error) {
+			region, err := ctx.region()
+			if err != nil || region == "" {
+				return false, err
+			}
+			return regions.contains(ctx.decision.Country, region), nil
+		}}, nil
+	case (left.typ == typeString || left.typ == typeStringList) && right.typ == typeStringList:
+		set := make(map[string]struct{}, len(right.strings))
+		for _, value := range right.strings {
+			normalized, err := normalizeConstant(left, value, listPos)
+			if err != nil {
+				return nil, err
+			}
+			set[normalized] = struct{}{}
+		}
+
+		if left.typ == typeStringList {
+			return &compiledExpr{typ: typeBool, boolFn: func(ctx *evalContext) (bool, error) {
+				values, err := left.listFn(ctx)
+				for _, v := range values {
+					if _, ok := set[v]; ok {
+						return true, err
+					}
+				}
+				return false, err
+			}}, nil
+		}
+		return &compiledExpr{typ: typeBool, boolFn: func(ctx *evalContext) (bool, error) {
+			v, err := left.stringFn(ctx)
+			_, ok := set[v]
+			return ok, err
+		}}, nil
+	case left.typ == typeNumber && (right.typ == typeNumberList || (node.children[1].kind == nodeList && len(node.children[1].children) == 0)):
+		return &compiledExpr{typ: typeBool, boolFn: func(ctx *evalContext) (bool, error) {
+			v, err := left.numberFn(ctx)
+			_, ok := right.numbers[v]
+			return ok, err
+		}}, nil
+	default:
+		return nil, errorAt(node.pos, "can't check whether a %s is in a %s", exprTypeNames[left.typ], exprTypeNames[right.typ])
+	}
+}
+
+// compileMatches compiles the matches operator, whose right-hand side must be a constant glob pattern.
+func (c *exprCompiler) compileMatches(node *exprNode) (*compiledExpr, error) {
+	left, err := c.compile(node.children[0])
+	if err != nil {
+		return nil, err
+	}
+	if left.typ != typeString {
+		return nil, errorAt(node.children[0].pos, "expected a string before matches, but got a %s", exprTypeNames[left.typ])
+	}
+	if node.children[1].kind != nodeString {
+		return nil, errorAt(node.children[1].pos, "expected a pattern after matches")
+	}
+
+	pattern := node.children[1].text
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, errorAt(node.children[1].pos, "invalid pattern %q", pattern)
+	}
+
+	return &compiledExpr{typ: typeBool, boolFn: func(ctx *evalContext) (bool, error) {
+		v, err := left.stringFn(ctx)
+		if err != nil {
+			return false, err
+		}
+		matched, _ := path.Match(pattern, v)
+		return matched, nil
+	}}, nil
+}
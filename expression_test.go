@@ -0,0 +1,177 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInitExpression_Errors(t *testing.T) {
+	groups, err := initCountryGroups(nil)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	for expr, expectedErr := range map[string]string{
+		`country in @EU &&`:               "column 18: unexpected end of expression",
+		`contry == "DE"`:                  `column 1: unknown variable "contry", must be one of: asn, country, host, ip, method, path, region, usageType`,
+		`country == "UK"`:                 "column 12: UK is not ISO 3166-1, did you mean GB?",
+		`country in @NOPE`:                `column 12: unknown country group "@NOPE"`,
+		`asn in ["AS1"]`:                  "column 5: can't check whether a number is in a list of strings",
+		`asn in @EU`:                      "column 5: can't check whether a number is in a list of strings",
+		`country`:                         "column 1: expression must be a condition, but is a string",
+		`country == "DE" && asn`:          "column 20: expected a condition, but got a number",
+		`(country == "DE"`:                "column 17: expected ), but got end of expression",
+		`cidr("10.0.0.0/33")`:             `column 6: invalid CIDR "10.0.0.0/33"`,
+		`cidr(ip)`:                        "column 6: cidr expects a string argument",
+		`foo("bar")`:                      `column 1: unknown function "foo"`,
+		`path matches "[a"`:               `column 14: invalid pattern "[a"`,
+		`asn in [1, "2"]`:                 "column 12: lists can't mix strings and numbers",
+		`country == "DE" # comment`:       "column 17: unexpected character '#'",
+		`header("X-Foo") == "bar`:         "column 20: unterminated string",
+		`usageType == "FOO"`:              `column 14: "FOO" is not a known usage type`,
+		`region in ["XX-1"]`:              `column 11: unknown ISO 3166-2 subdivision "XX-1", use "XX:Region name" instead`,
+		`region == "Crimea"`:              `column 11: invalid region "Crimea", expected an ISO 3166-2 code or "CC:Region name"`,
+		`"XX-1" != region`:                `column 1: unknown ISO 3166-2 subdivision "XX-1", use "XX:Region name" instead`,
+		`"UK" == country`:                 "column 1: UK is not ISO 3166-1, did you mean GB?",
+		`country in ["DE"] country`:       "column 19: unexpected country",
+		`asn == "15169"`:                  "column 5: can't compare number with string",
+		`ip in ["1.2.3.4", "1.2.3"]`:      `column 7: "1.2.3" is not a valid IP address`,
+		`country in @EU && !(asn in [1,]`: "column 31: unexpected ]",
+	} {
+		_, err := initExpression(expr, groups)
+		if err == nil {
+			t.Errorf("expected error for %q, but got none", expr)
+			continue
+		}
+		if err.Error() != expectedErr {
+			t.Errorf("expected error %q for %q, but got: %q", expectedErr, expr, err)
+		}
+	}
+}
+
+func TestInitExpression_Requirements(t *testing.T) {
+	expr, err := initExpression(`region == "DE:Bayern" || asn in [1] || usageType == "DCH" || country == "DE"`, nil)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	expected := ruleRequirements{region: true, asn: true, usageType: true}
+	if expr.requirements != expected {
+		t.Errorf("expected requirements %+v, but got: %+v", expected, expr.requirements)
+	}
+}
+
+func TestPlugin_ServeHTTP_Expression(t *testing.T) {
+	cfg := &Config{
+		Enabled:             true,
+		DatabaseFilePath:    dbFilePath,
+		ASNDatabaseFilePath: writeTestASNDatabase(t),
+		Rules: []RuleConfig{
+			{
+				Name:       "expr",
+				Action:     "allow",
+				Expression: `country in [@DACH, "US"] && !(asn in [15169, 14618]) || cidr("8.8.8.8/32") || (method == "get" && path matches "/public/*" && header("X-Client") != "")`,
+			},
+			{Action: "block"},
+		},
+		DisallowedStatusCode: http.StatusForbidden,
+	}
+
+	plugin, err := New(context.TODO(), &noopHandler{}, cfg, pluginName)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	for _, test := range []struct {
+		name, method, path, ip, client string
+		expectedStatus                 int
+	}{
+		{"Allowed country and ASN", http.MethodGet, "/foobar", "185.5.82.105", "", http.StatusTeapot},
+		{"Allowed country but blocked ASN", http.MethodGet, "/foobar", "8.8.8.9", "", http.StatusForbidden},
+		{"Blocked ASN but allowed CIDR", http.MethodGet, "/foobar", "8.8.8.8", "", http.StatusTeapot},
+		{"Other country", http.MethodGet, "/foobar", "77.88.8.8", "", http.StatusForbidden},
+		{"Other country on public path with client header", http.MethodGet, "/public/index.html", "77.88.8.8", "foo", http.StatusTeapot},
+		{"Other country on public path without client header", http.MethodGet, "/public/index.html", "77.88.8.8", "", http.StatusForbidden},
+		{"Other country on public path with other method", http.MethodPost, "/public/index.html", "77.88.8.8", "foo", http.StatusForbidden},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, nil)
+			req.Header.Set("X-Real-IP", test.ip)
+			if test.client != "" {
+				req.Header.Set("X-Client", test.client)
+			}
+
+			rr := httptest.NewRecorder()
+			plugin.ServeHTTP(rr, req)
+
+			if rr.Code != test.expectedStatus {
+				t.Errorf("expected status code %d, but got: %d", test.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestNew_ExpressionError(t *testing.T) {
+	cfg := &Config{
+		Enabled:              true,
+		DatabaseFilePath:     dbFilePath,
+		Rules:                []RuleConfig{{Name: "eu", Action: "allow", Expression: `country in @EU && asn in [16509]`}},
+		DisallowedStatusCode: http.StatusForbidden,
+	}
+
+	// The expression refers to the ASN, which requires an ASN database.
+	plugin, err := New(context.TODO(), &noopHandler{}, cfg, pluginName)
+	if err == nil {
+		t.Errorf("expected error, but got none")
+	}
+	if plugin != nil {
+		t.Error("expected plugin to be nil, but is not")
+	}
+
+	cfg.Rules[0].Expression = `country in @EU &&`
+
+	_, err = New(context.TODO(), &noopHandler{}, cfg, pluginName)
+	expectedErr := `geoblock: failed loading rules: rule "eu": invalid expression: column 18: unexpected end of expression`
+	if err == nil || err.Error() != expectedErr {
+		t.Errorf("expected error %q, but got: %v", expectedErr, err)
+	}
+}
+
+func TestPlugin_ServeHTTP_ExpressionConstantOnLeft(t *testing.T) {
+	cfg := &Config{
+		Enabled:          true,
+		DatabaseFilePath: dbFilePath,
+		Rules: []RuleConfig{
+			{Name: "expr", Action: "allow", Expression: `"de" == country`},
+			{Action: "block"},
+		},
+		DisallowedStatusCode: http.StatusForbidden,
+	}
+
+	plugin, err := New(context.TODO(), &noopHandler{}, cfg, pluginName)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	for _, test := range []struct {
+		name, ip       string
+		expectedStatus int
+	}{
+		{"Matching country", "185.5.82.105", http.StatusTeapot},
+		{"Other country", "77.88.8.8", http.StatusForbidden},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/foobar", nil)
+			req.Header.Set("X-Real-IP", test.ip)
+
+			rr := httptest.NewRecorder()
+			plugin.ServeHTTP(rr, req)
+
+			if rr.Code != test.expectedStatus {
+				t.Errorf("expected status code %d, but got: %d", test.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
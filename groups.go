@@ -0,0 +1,249 @@
+package traefik_plugin_geoblock
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// countryGroupPrefix marks a reference to a country group in a list of countries, e.g. "@EU".
+const countryGroupPrefix = "@"
+
+// countryExclusionPrefix marks a country or country group to be excluded from a list of countries, e.g. "!HU".
+const countryExclusionPrefix = "!"
+
+// euCountries are the member states of the European Union.
+var euCountries = []string{
+	"AT", "BE", "BG", "CY", "CZ", "DE", "DK", "EE", "ES", "FI", "FR", "GR", "HR", "HU",
+	"IE", "IT", "LT", "LU", "LV", "MT", "NL", "PL", "PT", "RO", "SE", "SI", "SK",
+}
+
+// eftaCountries are the member states of the European Free Trade Association.
+var eftaCountries = []string{"CH", "IS", "LI", "NO"}
+
+// builtinCountryGroups are the country groups available without configuration.
+// Continents are available by name and, except for Europe (which would clash with the EU), by code.
+var builtinCountryGroups = map[string][]string{
+	"EU":   euCountries,
+	"EEA":  append(append([]string{}, euCountries...), "IS", "LI", "NO"),
+	"EFTA": eftaCountries,
+	"SCHENGEN": {
+		"AT", "BE", "BG", "CH", "CZ", "DE", "DK", "EE", "ES", "FI", "FR", "GR", "HR", "HU", "IS",
+		"IT", "LI", "LT", "LU", "LV", "MT", "NL", "NO", "PL", "PT", "RO", "SE", "SI", "SK",
+	},
+	"DACH": {"AT", "CH", "DE"},
+
+	"AFRICA": {
+		"AO", "BF", "BI", "BJ", "BW", "CD", "CF", "CG", "CI", "CM", "CV", "DJ", "DZ", "EG", "EH", "ER",
+		"ET", "GA", "GH", "GM", "GN", "GQ", "GW", "KE", "KM", "LR", "LS", "LY", "MA", "MG", "ML", "MR",
+		"MU", "MW", "MZ", "NA", "NE", "NG", "RE", "RW", "SC", "SD", "SH", "SL", "SN", "SO", "SS", "ST",
+		"SZ", "TD", "TG", "TN", "TZ", "UG", "YT", "ZA", "ZM", "ZW",
+	},
+	"ANTARCTICA": {"AQ", "BV", "GS", "HM", "TF"},
+	"ASIA": {
+		"AE", "AF", "AM", "AZ", "BD", "BH", "BN", "BT", "CC", "CN", "CX", "GE", "HK", "ID", "IL", "IN",
+		"IO", "IQ", "IR", "JO", "JP", "KG", "KH", "KP", "KR", "KW", "KZ", "LA", "LB", "LK", "MM", "MN",
+		"MO", "MV", "MY", "NP", "OM", "PH", "PK", "PS", "QA", "SA", "SG", "SY", "TH", "TJ", "TL", "TM",
+		"TR", "TW", "UZ", "VN", "YE",
+	},
+	"EUROPE": {
+		"AD", "AL", "AT", "AX", "BA", "BE", "BG", "BY", "CH", "CY", "CZ", "DE", "DK", "EE", "ES", "FI",
+		"FO", "FR", "GB", "GG", "GI", "GR", "HR", "HU", "IE", "IM", "IS", "IT", "JE", "LI", "LT", "LU",
+		"LV", "MC", "MD", "ME", "MK", "MT", "NL", "NO", "PL", "PT", "RO", "RS", "RU", "SE", "SI", "SJ",
+		"SK", "SM", "UA", "VA",
+	},
+	"NORTH_AMERICA": {
+		"AG", "AI", "AW", "BB", "BL", "BM", "BQ", "BS", "BZ", "CA", "CR", "CU", "CW", "DM", "DO", "GD",
+		"GL", "GP", "GT", "HN", "HT", "JM", "KN", "KY", "LC", "MF", "MQ", "MS", "MX", "NI", "PA", "PM",
+		"PR", "SV", "SX", "TC", "TT", "UM", "US", "VC", "VG", "VI",
+	},
+	"OCEANIA": {
+		"AS", "AU", "CK", "FJ", "FM", "GU", "KI", "MH", "MP", "NC", "NF", "NR", "NU", "NZ", "PF", "PG",
+		"PN", "PW", "SB", "TK", "TO", "TV", "VU", "WF", "WS",
+	},
+	"SOUTH_AMERICA": {"AR", "BO", "BR", "CL", "CO", "EC", "FK", "GF", "GY", "PE", "PY", "SR", "UY", "VE"},
+}
+
+// continentCodes maps continent codes to the names of their built-in groups.
+var continentCodes = map[string]string{
+	"AF": "AFRICA",
+	"AN": "ANTARCTICA",
+	"AS": "ASIA",
+	"NA": "NORTH_AMERICA",
+	"OC": "OCEANIA",
+	"SA": "SOUTH_AMERICA",
+}
+
+// initCountryGroups merges the built-in country groups with the configured ones, and expands
+// references to other groups within them. Group names are case-insensitive.
+func initCountryGroups(custom map[string][]string) (map[string][]string, error) {
+	groups := make(map[string][]string, len(builtinCountryGroups)+len(continentCodes)+len(custom))
+	for name, members := range builtinCountryGroups {
+		groups[name] = members
+	}
+	for code, name := range continentCodes {
+		groups[code] = builtinCountryGroups[name]
+	}
+
+	rawGroups := make(map[string][]string, len(custom))
+	for name, members := range custom {
+		normalized := strings.ToUpper(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(name), countryGroupPrefix)))
+		if normalized == "" {
+			return nil, fmt.Errorf("country group name %q is empty", name)
+		}
+		if _, ok := groups[normalized]; ok {
+			return nil, fmt.Errorf("country group %q conflicts with a built-in group", name)
+		}
+		if _, ok := rawGroups[normalized]; ok {
+			return nil, fmt.Errorf("country group %q is defined more than once", name)
+		}
+		rawGroups[normalized] = members
+	}
+
+	var resolve func(name string, visiting map[string]bool) ([]string, error)
+	resolve = func(name string, visiting map[string]bool) ([]string, error) {
+		if members, ok := groups[name]; ok {
+			return members, nil
+		}
+
+		rawMembers, ok := rawGroups[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown country group %q", countryGroupPrefix+name)
+		}
+		if visiting[name] {
+			return nil, fmt.Errorf("country group %q references itself", countryGroupPrefix+name)
+		}
+		visiting[name] = true
+
+		members, err := expandCountryEntries(rawMembers, func(ref string) ([]string, error) {
+			return resolve(ref, visiting)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid country group %q: %w", countryGroupPrefix+name, err)
+		}
+
+		delete(visiting, name)
+		groups[name] = members
+
+		return members, nil
+	}
+
+	for name := range rawGroups {
+		if _, err := resolve(name, make(map[string]bool)); err != nil {
+			return nil, err
+		}
+	}
+
+	return groups, nil
+}
+
+// expandCountries expands references to country groups (e.g. "@EU") and exclusions (e.g. "!HU")
+// in a list of countries. Entries may also contain multiple comma-separated items (e.g. "@EU,!HU").
+// The second return value indicates whether any groups or exclusions were used.
+func expandCountries(entries []string, groups map[string][]string) ([]string, bool, error) {
+	usedGroups := false
+
+	countries, err := expandCountryEntries(entries, func(ref string) ([]string, error) {
+		usedGroups = true
+
+		members, ok := groups[ref]
+		if !ok {
+			return nil, fmt.Errorf("unknown country group %q", countryGroupPrefix+ref)
+		}
+
+		return members, nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, entry := range entries {
+		if strings.Contains(entry, countryExclusionPrefix) {
+			usedGroups = true
+		}
+	}
+
+	return countries, usedGroups, nil
+}
+
+// expandCountryEntries expands a list of countries, group references and exclusions into a sorted
+// list of countries, resolving group references using the given function. Exclusions are applied
+// after all inclusions, so their position within the list does not matter.
+func expandCountryEntries(entries []string, resolveGroup func(name string) ([]string, error)) ([]string, error) {
+	included := make(map[string]struct{})
+	excluded := make(map[string]struct{})
+
+	for _, entry := range entries {
+		for _, item := range strings.Split(entry, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+
+			target := included
+			if strings.HasPrefix(item, countryExclusionPrefix) {
+				target = excluded
+				item = strings.TrimSpace(strings.TrimPrefix(item, countryExclusionPrefix))
+			}
+
+			if !strings.HasPrefix(item, countryGroupPrefix) {
+				country, err := normalizeCountry(item)
+				if err != nil {
+					return nil, err
+				}
+				target[country] = struct{}{}
+				continue
+			}
+
+			members, err := resolveGroup(strings.ToUpper(strings.TrimPrefix(item, countryGroupPrefix)))
+			if err != nil {
+				return nil, err
+			}
+			for _, member := range members {
+				target[member] = struct{}{}
+			}
+		}
+	}
+
+	countries := make([]string, 0, len(included))
+	for country := range included {
+		if _, ok := excluded[country]; !ok {
+			countries = append(countries, country)
+		}
+	}
+	sort.Strings(countries)
+
+	return countries, nil
+}
+
+// expandCountryKeys maps each country to the key of a per-country setting applying to it. Keys are countries or
+// country groups (e.g. "@EU" or "@EU,!HU"). Countries take precedence over groups, but a country may only be part
+// of a single group.
+func expandCountryKeys(keys []string, groups map[string][]string) (map[string]string, error) {
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+
+	byCountry := make(map[string]string)
+	fromGroup := make(map[string]bool)
+	for _, key := range sorted {
+		countries, isGroup, err := expandCountries([]string{key}, groups)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", key, err)
+		}
+
+		for _, country := range countries {
+			previous, ok := byCountry[country]
+			switch {
+			case !ok || (fromGroup[country] && !isGroup):
+				byCountry[country] = key
+				fromGroup[country] = isGroup
+			case !fromGroup[country] && isGroup:
+				// NB: the country itself takes precedence over the group.
+			default:
+				return nil, fmt.Errorf("%s is covered by both %q and %q", country, previous, key)
+			}
+		}
+	}
+
+	return byCountry, nil
+}
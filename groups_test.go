@@ -0,0 +1,124 @@
+package traefik_plugin_geoblock
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestExpandCountries(t *testing.T) {
+	groups, err := initCountryGroups(map[string][]string{
+		"nordics": {"DK", "FI", "IS", "NO", "SE"},
+		"@Core":   {"@DACH", "@nordics", "!IS"},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	for name, test := range map[string]struct {
+		entries  []string
+		expected []string
+		expanded bool
+	}{
+		"Plain":          {entries: []string{"US", "DE"}, expected: []string{"DE", "US"}},
+		"BuiltinGroup":   {entries: []string{"@dach"}, expected: []string{"AT", "CH", "DE"}, expanded: true},
+		"ContinentCode":  {entries: []string{"@SA", "!@SA", "US"}, expected: []string{"US"}, expanded: true},
+		"Exclusion":      {entries: []string{"@DACH,!AT"}, expected: []string{"CH", "DE"}, expanded: true},
+		"ExclusionFirst": {entries: []string{"!CH", "@DACH"}, expected: []string{"AT", "DE"}, expanded: true},
+		"CustomGroup":    {entries: []string{"@CORE"}, expected: []string{"AT", "CH", "DE", "DK", "FI", "NO", "SE"}, expanded: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			countries, expanded, err := expandCountries(test.entries, groups)
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if !reflect.DeepEqual(countries, test.expected) {
+				t.Errorf("expected countries %v, but got: %v", test.expected, countries)
+			}
+			if expanded != test.expanded {
+				t.Errorf("expected expanded to be %t, but got: %t", test.expanded, expanded)
+			}
+		})
+	}
+
+	t.Run("UnknownGroup", func(t *testing.T) {
+		if _, _, err := expandCountries([]string{"@FOO"}, groups); err == nil {
+			t.Error("expected error, but got none")
+		}
+	})
+}
+
+func TestInitCountryGroups(t *testing.T) {
+	for name, custom := range map[string]map[string][]string{
+		"BuiltinConflict": {"eu": {"DE"}},
+		"UnknownGroup":    {"foo": {"@BAR"}},
+		"Cycle":           {"foo": {"@BAR"}, "bar": {"@FOO"}},
+		"Duplicate":       {"foo": {"DE"}, "FOO": {"AT"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := initCountryGroups(custom); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+
+	t.Run("EEA", func(t *testing.T) {
+		groups, err := initCountryGroups(nil)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if len(groups["EU"]) != 27 {
+			t.Errorf("expected 27 EU countries, but got: %d", len(groups["EU"]))
+		}
+		if len(groups["EEA"]) != 30 {
+			t.Errorf("expected 30 EEA countries, but got: %d", len(groups["EEA"]))
+		}
+	})
+}
+
+func TestPlugin_ServeHTTP_CountryGroups(t *testing.T) {
+	cfg := &Config{
+		Enabled:              true,
+		DatabaseFilePath:     dbFilePath,
+		AllowedCountries:     []string{"@EU,!AT", "@staff"},
+		CountryGroups:        map[string][]string{"staff": {"US"}},
+		DisallowedStatusCode: http.StatusForbidden,
+	}
+
+	testRequest(t, "EU country allowed", cfg, "185.5.82.105", http.StatusTeapot)
+	testRequest(t, "Custom group country allowed", cfg, "8.8.8.8", http.StatusTeapot)
+
+	cfg.AllowedCountries = []string{"@EU,!DE"}
+
+	testRequest(t, "Excluded country disallowed", cfg, "185.5.82.105", http.StatusForbidden)
+}
+
+func TestExpandCountryKeys(t *testing.T) {
+	groups, err := initCountryGroups(map[string][]string{"ALPS": {"DE", "AT", "CH"}})
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	byCountry, err := expandCountryKeys([]string{"@ALPS", "de", "@EFTA,!CH,!IS,!LI"}, groups)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	expected := map[string]string{"AT": "@ALPS", "CH": "@ALPS", "DE": "de", "NO": "@EFTA,!CH,!IS,!LI"}
+	if !reflect.DeepEqual(byCountry, expected) {
+		t.Errorf("expected %v, but got: %v", expected, byCountry)
+	}
+
+	for name, keys := range map[string][]string{
+		"OverlappingGroups": {"@ALPS", "@EFTA"},
+		"DuplicateCountry":  {"DE", "DEU"},
+		"UnknownGroup":      {"@FOO"},
+		"InvalidCountry":    {"XX"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := expandCountryKeys(keys, groups); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
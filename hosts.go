@@ -0,0 +1,281 @@
+package traefik_plugin_geoblock
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hostPolicyPrefix is followed by the hostname or pattern of a host policy in decisions, e.g. "host:*.example.com".
+const hostPolicyPrefix = "host:"
+
+// defaultHostsFileReloadInterval is the interval in which the hosts file is checked for changes by default.
+const defaultHostsFileReloadInterval = 10 * time.Second
+
+// HostPolicyConfig defines a policy for requests to specific hosts, overriding the global policy.
+type HostPolicyConfig struct {
+	AllowedCountries     []string // Whitelist of countries to allow (ISO 3166-1 codes or @groups)
+	BlockedCountries     []string // Blocklist of countries to be blocked (ISO 3166-1 codes or @groups)
+	AllowedIPBlocks      []string // List of whitelist CIDR
+	BlockedIPBlocks      []string // List of blocklisted CIDRs
+	DefaultAllow         bool     // If source matches neither blocklist nor whitelist, should it be allowed through?
+	DisallowedStatusCode int      // HTTP status code to return for disallowed requests (default: the global one)
+}
+
+// hostPolicies holds the policies for specific hosts, by lower case hostname or wildcard pattern (e.g. "*.example.com").
+// They are replaced as a whole when the hosts file changes.
+type hostPolicies struct {
+	mu       sync.RWMutex
+	policies map[string]*policy
+	watcher  *hostsFileWatcher // Watcher of the hosts file, if any
+}
+
+// reloadIfDue reloads the host policies if the hosts file is due to be checked for changes.
+func (h *hostPolicies) reloadIfDue(now time.Time) {
+	if h == nil || h.watcher == nil {
+		return
+	}
+
+	h.watcher.reloadIfDue(h, now)
+}
+
+// get returns the policy for the given host, if any. Exact hostnames take precedence over wildcard patterns,
+// and longer patterns over shorter ones. The pattern "*" matches all hosts.
+func (h *hostPolicies) get(host string) *policy {
+	if h == nil {
+		return nil
+	}
+
+	host = strings.ToLower(host)
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.TrimSuffix(host, ".")
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if pol, ok := h.policies[host]; ok {
+		return pol
+	}
+
+	for labels := host; labels != ""; {
+		_, parent, ok := strings.Cut(labels, ".")
+		if !ok {
+			break
+		}
+		if pol, ok := h.policies["*."+parent]; ok {
+			return pol
+		}
+		labels = parent
+	}
+
+	return h.policies["*"]
+}
+
+// set replaces all host policies.
+func (h *hostPolicies) set(policies map[string]*policy) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.policies = policies
+}
+
+// initHostPolicies compiles the given host policies.
+func initHostPolicies(hosts map[string]HostPolicyConfig, global *Config, countryGroups map[string][]string,
+	logf func(format string, args ...interface{}),
+) (map[string]*policy, error) {
+	policies := make(map[string]*policy, len(hosts))
+
+	names := make([]string, 0, len(hosts))
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		host, err := normalizeHostPattern(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := policies[host]; ok {
+			return nil, fmt.Errorf("host policy %q: defined more than once", name)
+		}
+
+		hostCfg := hosts[name]
+		pol, err := initOverridePolicy(hostPolicyPrefix+host, &Config{
+			AllowedCountries: hostCfg.AllowedCountries,
+			BlockedCountries: hostCfg.BlockedCountries,
+			AllowedIPBlocks:  hostCfg.AllowedIPBlocks,
+			BlockedIPBlocks:  hostCfg.BlockedIPBlocks,
+			DefaultAllow:     hostCfg.DefaultAllow,
+			AllowPrivate:     global.AllowPrivate,
+		}, hostCfg.DisallowedStatusCode, countryGroups, logf)
+		if err != nil {
+			return nil, fmt.Errorf("host policy %q: %w", name, err)
+		}
+		policies[host] = pol
+	}
+
+	return policies, nil
+}
+
+// normalizeHostPattern validates and normalizes a hostname or wildcard pattern.
+func normalizeHostPattern(pattern string) (string, error) {
+	host := strings.ToLower(strings.TrimSpace(pattern))
+	if host == "*" {
+		return host, nil
+	}
+	host = strings.TrimSuffix(host, ".")
+
+	name := strings.TrimPrefix(host, "*.")
+	if name == "" || strings.ContainsAny(name, "*/:") || strings.Contains(name, "..") {
+		return "", fmt.Errorf("invalid host %q, must be a hostname or a pattern like *.example.com", pattern)
+	}
+
+	return host, nil
+}
+
+// loadHostsFile reads host policies from a JSON or YAML file, by hostname or pattern. Unknown fields are rejected, so
+// typos in the file don't go unnoticed.
+func loadHostsFile(path string) (map[string]HostPolicyConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make(map[string]HostPolicyConfig)
+
+	// NB: JSON documents are valid YAML, but only a subset of YAML is supported, so they're detected upfront.
+	if strings.EqualFold(filepath.Ext(path), ".json") || strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		err = decodeJSON(data, &hosts)
+	} else {
+		err = decodeYAML(data, &hosts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return hosts, nil
+}
+
+// hostsFileWatcher reloads host policies when the hosts file changes.
+type hostsFileWatcher struct {
+	path      string
+	interval  time.Duration
+	modTime   time.Time
+	size      int64
+	lastErr   string
+	reloading int32 // Set while a request reloads the hosts file
+
+	mu      sync.Mutex
+	checked time.Time // When the hosts file was last checked for changes
+
+	// load reads and compiles the host policies, merged with those of the configuration.
+	load func() (map[string]*policy, error)
+	logf func(format string, args ...interface{})
+}
+
+// initHostsFileWatcher creates a watcher for the hosts file and loads it for the first time.
+func initHostsFileWatcher(path, interval string, load func() (map[string]*policy, error),
+	logf func(format string, args ...interface{}),
+) (*hostsFileWatcher, map[string]*policy, error) {
+	w := &hostsFileWatcher{path: path, interval: defaultHostsFileReloadInterval, load: load, logf: logf}
+
+	if interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return nil, nil, fmt.Errorf("invalid hosts file reload interval %q", interval)
+		}
+		w.interval = d
+	}
+
+	info, _, err := w.changed()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	policies, err := load()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.loaded(info)
+	w.checked = time.Now()
+
+	return w, policies, nil
+}
+
+// changed indicates whether the hosts file changed since it was last loaded successfully, and returns its file info.
+func (w *hostsFileWatcher) changed() (os.FileInfo, bool, error) {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return nil, false, err
+	}
+	if info.IsDir() {
+		return nil, false, errors.New(w.path + " is a directory")
+	}
+
+	return info, !info.ModTime().Equal(w.modTime) || info.Size() != w.size, nil
+}
+
+// loaded records the state of the hosts file once it was loaded successfully. Until then, it is considered changed,
+// so a file that fails to load is loaded again on the next check, even if it didn't change in the meantime.
+func (w *hostsFileWatcher) loaded(info os.FileInfo) {
+	w.modTime = info.ModTime()
+	w.size = info.Size()
+}
+
+// reloadIfDue checks the hosts file for changes if the reload interval elapsed, and replaces the host policies whenever
+// it changed. If the file can't be loaded, the previous host policies are kept. Checks run synchronously in the request
+// that finds them due, while concurrent requests keep using the current host policies, so no goroutine outlives an
+// instance replaced on a configuration reload.
+func (w *hostsFileWatcher) reloadIfDue(hosts *hostPolicies, now time.Time) {
+	w.mu.Lock()
+	due := !now.Before(w.checked.Add(w.interval))
+	if due {
+		w.checked = now
+	}
+	w.mu.Unlock()
+
+	if !due || !atomic.CompareAndSwapInt32(&w.reloading, 0, 1) {
+		return
+	}
+
+	defer atomic.StoreInt32(&w.reloading, 0)
+
+	if err := w.reload(hosts); err != nil {
+		// NB: errors are only logged once, until the hosts file could be loaded again.
+		if err.Error() != w.lastErr {
+			w.logf("failed to reload hosts file, keeping previous host policies: %v", err)
+		}
+		w.lastErr = err.Error()
+		return
+	}
+	w.lastErr = ""
+}
+
+// reload replaces the host policies if the hosts file changed.
+func (w *hostsFileWatcher) reload(hosts *hostPolicies) error {
+	info, changed, err := w.changed()
+	if err != nil || !changed {
+		return err
+	}
+
+	policies, err := w.load()
+	if err != nil {
+		return err
+	}
+
+	w.loaded(info)
+	hosts.set(policies)
+	w.logf("reloaded %d host policies from %s", len(policies), w.path)
+
+	return nil
+}
@@ -0,0 +1,290 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHostPolicies_Get(t *testing.T) {
+	policies, err := initHostPolicies(map[string]HostPolicyConfig{
+		"example.com":       {},
+		"*.example.com":     {},
+		"*.api.example.com": {},
+		"Shop.Example.ORG.": {},
+	}, &Config{}, nil, t.Logf)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	hosts := &hostPolicies{}
+	hosts.set(policies)
+
+	for host, expected := range map[string]string{
+		"example.com":          "host:example.com",
+		"EXAMPLE.com:8443":     "host:example.com",
+		"www.example.com":      "host:*.example.com",
+		"a.b.example.com":      "host:*.example.com",
+		"v1.api.example.com":   "host:*.api.example.com",
+		"api.example.com":      "host:*.example.com",
+		"shop.example.org":     "host:shop.example.org",
+		"www.shop.example.org": "",
+		"example.net":          "",
+	} {
+		actual := ""
+		if pol := hosts.get(host); pol != nil {
+			actual = pol.name
+		}
+		if actual != expected {
+			t.Errorf("expected policy %q for %s, but got: %q", expected, host, actual)
+		}
+	}
+
+	policies, err = initHostPolicies(map[string]HostPolicyConfig{"*": {}}, &Config{}, nil, t.Logf)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	hosts.set(policies)
+
+	if pol := hosts.get("example.net"); pol == nil || pol.name != "host:*" {
+		t.Errorf("expected default host policy, but got: %+v", pol)
+	}
+}
+
+func TestInitHostPolicies_Errors(t *testing.T) {
+	for name, hosts := range map[string]map[string]HostPolicyConfig{
+		"InvalidHost":       {"example.*": {}},
+		"InvalidPattern":    {"*.*.example.com": {}},
+		"EmptyPattern":      {"*.": {}},
+		"URL":               {"https://example.com": {}},
+		"Duplicate":         {"example.com": {}, "EXAMPLE.COM": {}},
+		"InvalidStatusCode": {"example.com": {DisallowedStatusCode: -1}},
+		"InvalidCIDR":       {"example.com": {AllowedIPBlocks: []string{"10.0.0.0/33"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := initHostPolicies(hosts, &Config{}, nil, t.Logf); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestLoadHostsFile(t *testing.T) {
+	write := func(name, content string) string {
+		hostsFilePath := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(hostsFilePath, []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write hosts file: %v", err)
+		}
+		return hostsFilePath
+	}
+
+	t.Run("NumericCountryCode", func(t *testing.T) {
+		hosts, err := loadHostsFile(write("hosts.json", `{"example.com": {"allowedCountries": ["276", "AT"]}}`))
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		policies, err := initHostPolicies(hosts, &Config{}, nil, t.Logf)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if _, ok := policies["example.com"]; !ok {
+			t.Error("expected host policy for example.com, but got none")
+		}
+	})
+
+	for name, file := range map[string]struct{ name, content string }{
+		"YAML":           {"hosts.yml", "example.com:\n  allowedCountries: [276, AT]\n"},
+		"JSONInYAMLFile": {"hosts.yml", `{"example.com": {"allowedCountries": ["276", "AT"]}}`},
+		"YAMLWithoutExt": {"hosts", "example.com:\n  allowedCountries:\n    - 276\n    - AT\n"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			hosts, err := loadHostsFile(write(file.name, file.content))
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if actual := hosts["example.com"].AllowedCountries; len(actual) != 2 || actual[0] != "276" || actual[1] != "AT" {
+				t.Errorf("expected allowed countries [276 AT], but got: %v", actual)
+			}
+		})
+	}
+
+	for name, file := range map[string]struct{ name, content string }{
+		"UnquotedNumber":   {"hosts.json", `{"example.com": {"allowedCountries": [276]}}`},
+		"UnknownField":     {"hosts.json", `{"example.com": {"allowedCountry": ["DE"]}}`},
+		"YAMLInJSONFile":   {"hosts.json", "example.com:\n  allowedCountries: [DE]\n"},
+		"YAMLUnknownField": {"hosts.yml", "example.com:\n  allowedCountry: [DE]\n"},
+		"Empty":            {"hosts.json", ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadHostsFile(write(file.name, file.content)); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestPlugin_ServeHTTP_HostPolicies(t *testing.T) {
+	hostsFilePath := filepath.Join(t.TempDir(), "hosts.json")
+	writeHostsFile := func(content string, modTime time.Time) {
+		if err := os.WriteFile(hostsFilePath, []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write hosts file: %v", err)
+		}
+		if err := os.Chtimes(hostsFilePath, modTime, modTime); err != nil {
+			t.Fatalf("failed to set modification time of hosts file: %v", err)
+		}
+	}
+	writeHostsFile(`{"customer.example.com": {"allowedCountries": ["DE"]}}`, time.Now().Add(-time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	cfg := &Config{
+		Enabled:          true,
+		DatabaseFilePath: dbFilePath,
+		AllowedCountries: []string{"US"},
+		Hosts: map[string]HostPolicyConfig{
+			"*.example.com":        {AllowedCountries: []string{"@EU"}, DisallowedStatusCode: http.StatusNotFound},
+			"customer.example.com": {AllowedCountries: []string{"US"}},
+		},
+		Paths:                   []PathPolicyConfig{{Path: "/healthz", DefaultAllow: true}},
+		HostsFilePath:           hostsFilePath,
+		HostsFileReloadInterval: "1ns",
+		DisallowedStatusCode:    http.StatusForbidden,
+	}
+
+	plugin, err := New(ctx, &noopHandler{}, cfg, pluginName)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	request := func(host, path, ip string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		req.Header.Set("X-Real-IP", ip)
+
+		rr := httptest.NewRecorder()
+		plugin.ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	for _, test := range []struct {
+		name, host, path, ip string
+		expectedStatus       int
+	}{
+		{"Default policy allows US", "example.net", "/", "8.8.8.8", http.StatusTeapot},
+		{"Default policy blocks DE", "example.net", "/", "185.5.82.105", http.StatusForbidden},
+		{"Wildcard host allows DE", "www.example.com", "/", "185.5.82.105", http.StatusTeapot},
+		{"Wildcard host blocks US with own status code", "www.example.com", "/", "8.8.8.8", http.StatusNotFound},
+		{"File takes precedence over configuration", "customer.example.com", "/", "185.5.82.105", http.StatusTeapot},
+		{"File host blocks US", "customer.example.com", "/", "8.8.8.8", http.StatusForbidden},
+		{"Path takes precedence over host", "customer.example.com", "/healthz", "77.88.8.8", http.StatusTeapot},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if status := request(test.host, test.path, test.ip); status != test.expectedStatus {
+				t.Errorf("expected status code %d, but got: %d", test.expectedStatus, status)
+			}
+		})
+	}
+
+	t.Run("InvalidReload", func(t *testing.T) {
+		writeHostsFile(`{"customer.example.com": {"allowedCountries": ["UK"]}}`, time.Now().Add(-time.Minute))
+
+		if status := request("customer.example.com", "/", "185.5.82.105"); status != http.StatusTeapot {
+			t.Errorf("expected previous host policies to be kept, but got status code: %d", status)
+		}
+	})
+
+	t.Run("Reload", func(t *testing.T) {
+		writeHostsFile(`{"customer.example.com": {"allowedCountries": ["RU"]}}`, time.Now())
+
+		// NB: the hosts file is reloaded by the request that finds it due, before the request is handled.
+		if status := request("customer.example.com", "/", "77.88.8.8"); status != http.StatusTeapot {
+			t.Errorf("expected hosts file to be reloaded, but got status code: %d", status)
+		}
+		if status := request("customer.example.com", "/", "185.5.82.105"); status != http.StatusForbidden {
+			t.Errorf("expected status code %d, but got: %d", http.StatusForbidden, status)
+		}
+	})
+}
+
+func TestHostsFileWatcher_ReloadRetriesFailedLoad(t *testing.T) {
+	hostsFilePath := filepath.Join(t.TempDir(), "hosts.json")
+	if err := os.WriteFile(hostsFilePath, []byte(`{}`), 0o600); err != nil {
+		t.Fatalf("failed to write hosts file: %v", err)
+	}
+
+	loads := 0
+	var loadErr error
+	load := func() (map[string]*policy, error) {
+		loads++
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		return map[string]*policy{"example.com": {name: "host:example.com"}}, nil
+	}
+
+	w, _, err := initHostsFileWatcher(hostsFilePath, "", load, t.Logf)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	modTime := time.Now().Add(time.Minute)
+	if err = os.Chtimes(hostsFilePath, modTime, modTime); err != nil {
+		t.Fatalf("failed to set modification time of hosts file: %v", err)
+	}
+
+	hosts := &hostPolicies{}
+	loadErr = errors.New("load failed")
+	if err = w.reload(hosts); err == nil {
+		t.Fatal("expected error, but got none")
+	}
+
+	// NB: the file didn't change since the failed load, it must be loaded again nonetheless.
+	loadErr = nil
+	if err = w.reload(hosts); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if hosts.get("example.com") == nil {
+		t.Error("expected host policies to be reloaded after a failed load")
+	}
+
+	if err = w.reload(hosts); err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if loads != 3 {
+		t.Errorf("expected the unchanged file not to be loaded again, but it was loaded %d times", loads)
+	}
+}
+
+func TestNew_HostsFileErrors(t *testing.T) {
+	invalidFilePath := filepath.Join(t.TempDir(), "hosts.json")
+	if err := os.WriteFile(invalidFilePath, []byte(`{"example.com": {"allowedCountry": ["DE"]}}`), 0o600); err != nil {
+		t.Fatalf("failed to write hosts file: %v", err)
+	}
+
+	for name, cfg := range map[string]*Config{
+		"MissingFile":     {HostsFilePath: filepath.Join(t.TempDir(), "missing.json")},
+		"UnknownField":    {HostsFilePath: invalidFilePath},
+		"InvalidInterval": {HostsFilePath: invalidFilePath, HostsFileReloadInterval: "often"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg.Enabled = true
+			cfg.DatabaseFilePath = dbFilePath
+			cfg.DisallowedStatusCode = http.StatusForbidden
+
+			plugin, err := New(context.TODO(), &noopHandler{}, cfg, pluginName)
+			if err == nil {
+				t.Errorf("expected error, but got none")
+			}
+			if plugin != nil {
+				t.Error("expected plugin to be nil, but is not")
+			}
+		})
+	}
+}
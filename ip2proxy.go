@@ -0,0 +1,270 @@
+package traefik_plugin_geoblock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+)
+
+// ip2proxyProductCode identifies IP2Proxy BIN files, as opposed to IP2Location (1) ones.
+const ip2proxyProductCode = 2
+
+// Column positions of the fields in the IP2Proxy PX1 to PX12 databases, indexed by database type.
+var (
+	ip2proxyCountryPosition   = [13]uint8{0, 2, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3}
+	ip2proxyProxyTypePosition = [13]uint8{0, 0, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2}
+)
+
+// ip2proxyMeta holds the header information of an IP2Proxy BIN file.
+type ip2proxyMeta struct {
+	databaseType      uint8
+	databaseColumn    uint8
+	databaseYear      uint8
+	ipv4DatabaseCount uint32
+	ipv4DatabaseAddr  uint32
+	ipv6DatabaseCount uint32
+	ipv6DatabaseAddr  uint32
+	ipv4IndexBaseAddr uint32
+	ipv6IndexBaseAddr uint32
+	productCode       uint8
+}
+
+// ip2proxyRecord holds the fields of an IP2Proxy database entry used by this plugin.
+type ip2proxyRecord struct {
+	CountryShort string
+	ProxyType    string
+}
+
+// ip2proxyReader is a minimal reader for IP2Proxy BIN files. It only reads the country and proxy type columns,
+// straight from the file rather than loading the whole database into memory.
+type ip2proxyReader struct {
+	f    io.ReaderAt
+	meta ip2proxyMeta
+
+	countryOffset   uint32
+	proxyTypeOffset uint32
+}
+
+// openIP2Proxy opens the IP2Proxy BIN file at the given path.
+func openIP2Proxy(filePath string) (*ip2proxyReader, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := newIP2ProxyReader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+// newIP2ProxyReader reads the header of an IP2Proxy BIN file from f.
+func newIP2ProxyReader(f io.ReaderAt) (*ip2proxyReader, error) {
+	header := make([]byte, 64)
+	if _, err := f.ReadAt(header, 0); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	meta := ip2proxyMeta{
+		databaseType:      header[0],
+		databaseColumn:    header[1],
+		databaseYear:      header[2],
+		ipv4DatabaseCount: binary.LittleEndian.Uint32(header[5:]),
+		ipv4DatabaseAddr:  binary.LittleEndian.Uint32(header[9:]),
+		ipv6DatabaseCount: binary.LittleEndian.Uint32(header[13:]),
+		ipv6DatabaseAddr:  binary.LittleEndian.Uint32(header[17:]),
+		ipv4IndexBaseAddr: binary.LittleEndian.Uint32(header[21:]),
+		ipv6IndexBaseAddr: binary.LittleEndian.Uint32(header[25:]),
+		productCode:       header[29],
+	}
+
+	// Only BINs from January 2021 onwards have the product code set.
+	if (meta.productCode != ip2proxyProductCode && meta.databaseYear >= 21) || (header[0] == 'P' && header[1] == 'K') {
+		return nil, errors.New("incorrect IP2Proxy BIN file format")
+	}
+	if meta.databaseType == 0 || int(meta.databaseType) >= len(ip2proxyCountryPosition) || meta.databaseColumn < 2 {
+		return nil, fmt.Errorf("unsupported IP2Proxy database type PX%d", meta.databaseType)
+	}
+
+	// NB: lower tier or corrupt databases may lack the columns their type promises, rows are read by this count.
+	for _, pos := range []uint8{ip2proxyCountryPosition[meta.databaseType], ip2proxyProxyTypePosition[meta.databaseType]} {
+		if pos > meta.databaseColumn {
+			return nil, fmt.Errorf("IP2Proxy database type PX%d has %d columns, expected at least %d",
+				meta.databaseType, meta.databaseColumn, pos)
+		}
+	}
+
+	r := &ip2proxyReader{f: f, meta: meta}
+	r.countryOffset = uint32(ip2proxyCountryPosition[meta.databaseType]-2) << 2
+	if pos := ip2proxyProxyTypePosition[meta.databaseType]; pos != 0 {
+		r.proxyTypeOffset = uint32(pos-2) << 2
+	}
+
+	return r, nil
+}
+
+// hasProxyType indicates whether the database contains proxy types, which is the case for PX2 and later.
+func (r *ip2proxyReader) hasProxyType() bool {
+	return ip2proxyProxyTypePosition[r.meta.databaseType] != 0
+}
+
+// lookup queries the database for the given IP address.
+// Addresses that are not known to be proxies yield a record with a proxy type of "-".
+func (r *ip2proxyReader) lookup(ip net.IP) (ip2proxyRecord, error) {
+	ipNum, isIPv4 := ip2proxyIPNumber(ip)
+	if ipNum == nil {
+		return ip2proxyRecord{}, fmt.Errorf("invalid IP address %q", ip)
+	}
+
+	baseAddr, count, indexBaseAddr := r.meta.ipv6DatabaseAddr, r.meta.ipv6DatabaseCount, r.meta.ipv6IndexBaseAddr
+	firstCol := uint32(16)
+	if isIPv4 {
+		baseAddr, count, indexBaseAddr = r.meta.ipv4DatabaseAddr, r.meta.ipv4DatabaseCount, r.meta.ipv4IndexBaseAddr
+		firstCol = 4
+	}
+	if count == 0 {
+		return ip2proxyRecord{CountryShort: "-", ProxyType: "-"}, nil
+	}
+	colSize := firstCol + uint32(r.meta.databaseColumn-1)<<2
+
+	low, high := uint32(0), count
+	if indexBaseAddr > 0 {
+		// The index holds the row range for each value of the first 16 bits of the address.
+		indexPos := indexBaseAddr + (uint32(ipNum[0])<<8|uint32(ipNum[1]))<<3
+		index, err := r.readAt(indexPos, 8)
+		if err != nil {
+			return ip2proxyRecord{}, err
+		}
+		low = binary.LittleEndian.Uint32(index)
+		high = binary.LittleEndian.Uint32(index[4:])
+	}
+
+	for low <= high {
+		mid := (low + high) >> 1
+
+		row, err := r.readAt(baseAddr+mid*colSize, colSize+firstCol)
+		if err != nil {
+			return ip2proxyRecord{}, err
+		}
+
+		ipFrom := ip2proxyReadIPNumber(row[:firstCol])
+		ipTo := ip2proxyReadIPNumber(row[colSize:])
+
+		if bytes.Compare(ipNum, ipFrom) < 0 {
+			if mid == 0 {
+				break
+			}
+			high = mid - 1
+		} else if bytes.Compare(ipNum, ipTo) >= 0 {
+			low = mid + 1
+		} else {
+			return r.readRecord(row[firstCol:colSize])
+		}
+	}
+
+	return ip2proxyRecord{CountryShort: "-", ProxyType: "-"}, nil
+}
+
+// readRecord reads the fields of the given database row, excluding the leading IP number.
+func (r *ip2proxyReader) readRecord(row []byte) (ip2proxyRecord, error) {
+	var record ip2proxyRecord
+	var err error
+
+	record.CountryShort, err = r.readString(binary.LittleEndian.Uint32(row[r.countryOffset:]))
+	if err != nil {
+		return record, err
+	}
+
+	if r.hasProxyType() {
+		record.ProxyType, err = r.readString(binary.LittleEndian.Uint32(row[r.proxyTypeOffset:]))
+		if err != nil {
+			return record, err
+		}
+	}
+
+	return record, nil
+}
+
+// readAt reads size bytes at the given 1-based position.
+func (r *ip2proxyReader) readAt(pos, size uint32) ([]byte, error) {
+	if pos == 0 {
+		return nil, errors.New("invalid IP2Proxy database offset")
+	}
+
+	buf := make([]byte, size)
+	if _, err := r.f.ReadAt(buf, int64(pos)-1); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// readString reads a length-prefixed string at the given 0-based position.
+func (r *ip2proxyReader) readString(pos uint32) (string, error) {
+	buf := make([]byte, 256)
+	n, err := r.f.ReadAt(buf, int64(pos))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if n == 0 || int(buf[0]) >= n {
+		return "", errors.New("invalid IP2Proxy database string")
+	}
+
+	return string(buf[1 : 1+buf[0]]), nil
+}
+
+// ip2proxyIPNumber returns the big endian number of the given IP address, and whether it is to be looked up
+// in the IPv4 part of the database. IPv4-mapped, 6to4 and Teredo addresses are mapped to their IPv4 address.
+func ip2proxyIPNumber(ip net.IP) (net.IP, bool) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip2proxyClampIPNumber(ip4), true
+	}
+
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return nil, false
+	}
+
+	switch {
+	case ip16[0] == 0x20 && ip16[1] == 0x02:
+		// 6to4 (2002::/16) embeds the IPv4 address in bits 16 to 48.
+		return ip2proxyClampIPNumber(net.IP{ip16[2], ip16[3], ip16[4], ip16[5]}), true
+	case ip16[0] == 0x20 && ip16[1] == 0x01 && ip16[2] == 0 && ip16[3] == 0:
+		// Teredo (2001:0::/32) embeds the inverted IPv4 address in the last 32 bits.
+		return ip2proxyClampIPNumber(net.IP{^ip16[12], ^ip16[13], ^ip16[14], ^ip16[15]}), true
+	default:
+		return ip2proxyClampIPNumber(ip16), false
+	}
+}
+
+// ip2proxyClampIPNumber maps the broadcast address to the one before, as the database ranges exclude their upper bound.
+func ip2proxyClampIPNumber(ipNum net.IP) net.IP {
+	for _, b := range ipNum {
+		if b != 0xFF {
+			return ipNum
+		}
+	}
+
+	clamped := make(net.IP, len(ipNum))
+	copy(clamped, ipNum)
+	clamped[len(clamped)-1] = 0xFE
+
+	return clamped
+}
+
+// ip2proxyReadIPNumber reads a little endian IP number from the database into big endian order.
+func ip2proxyReadIPNumber(b []byte) net.IP {
+	ipNum := make(net.IP, len(b))
+	for i := range b {
+		ipNum[i] = b[len(b)-1-i]
+	}
+
+	return ipNum
+}
@@ -0,0 +1,256 @@
+package traefik_plugin_geoblock
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testIP2ProxyRange is an IPv4 range and its proxy information, to be written to a test IP2Proxy database.
+type testIP2ProxyRange struct {
+	from, to  string
+	country   string
+	proxyType string
+}
+
+// buildTestIP2Proxy builds an IP2Proxy PX2 database containing the given, sorted IPv4 ranges.
+// Gaps between the ranges are filled with non-proxy entries.
+func buildTestIP2Proxy(t *testing.T, ranges []testIP2ProxyRange) []byte {
+	t.Helper()
+
+	type row struct {
+		from               uint32
+		country, proxyType string
+	}
+
+	var rows []row
+	next := uint32(0)
+	for _, r := range ranges {
+		from := binary.BigEndian.Uint32(net.ParseIP(r.from).To4())
+		if from > next {
+			rows = append(rows, row{from: next, country: "-", proxyType: "-"})
+		}
+		rows = append(rows, row{from: from, country: r.country, proxyType: r.proxyType})
+		next = binary.BigEndian.Uint32(net.ParseIP(r.to).To4()) + 1
+	}
+	if next != 0 {
+		rows = append(rows, row{from: next, country: "-", proxyType: "-"})
+	}
+
+	const columns = 3
+	ipv4ColSize := uint32(columns * 4)
+	ipv6ColSize := uint32(16 + (columns-1)*4)
+	ipv4Addr := uint32(65)
+	// All rows plus the upper bound of the last one.
+	ipv6Addr := ipv4Addr + ipv4ColSize*uint32(len(rows)+1)
+	stringsAddr := ipv6Addr - 1 + ipv6ColSize*2
+
+	var stringsSection []byte
+	stringOffsets := make(map[string]uint32)
+	stringOffset := func(s string) uint32 {
+		if offset, ok := stringOffsets[s]; ok {
+			return offset
+		}
+		offset := stringsAddr + uint32(len(stringsSection))
+		stringsSection = append(stringsSection, byte(len(s)))
+		stringsSection = append(stringsSection, s...)
+		stringOffsets[s] = offset
+		return offset
+	}
+
+	buf := new(bytes.Buffer)
+	header := make([]byte, 64)
+	header[0] = 2
+	header[1] = columns
+	header[2] = 24
+	binary.LittleEndian.PutUint32(header[5:], uint32(len(rows)))
+	binary.LittleEndian.PutUint32(header[9:], ipv4Addr)
+	binary.LittleEndian.PutUint32(header[13:], 1)
+	binary.LittleEndian.PutUint32(header[17:], ipv6Addr)
+	header[29] = ip2proxyProductCode
+	buf.Write(header)
+
+	writeUint32 := func(v uint32) {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, v)
+		buf.Write(b)
+	}
+	for _, r := range rows {
+		writeUint32(r.from)
+		writeUint32(stringOffset(r.proxyType))
+		writeUint32(stringOffset(r.country))
+	}
+	writeUint32(0xFFFFFFFF)
+	writeUint32(0)
+	writeUint32(0)
+
+	// A single IPv6 range without proxies.
+	buf.Write(make([]byte, 16))
+	writeUint32(stringOffset("-"))
+	writeUint32(stringOffset("-"))
+	buf.Write(bytes.Repeat([]byte{0xFF}, 16))
+	buf.Write(make([]byte, 8))
+
+	if uint32(buf.Len()) != stringsAddr {
+		t.Fatalf("unexpected strings section address %d, expected %d", buf.Len(), stringsAddr)
+	}
+	buf.Write(stringsSection)
+
+	return buf.Bytes()
+}
+
+// writeTestProxyDatabase writes an IP2Proxy test database and returns its path.
+func writeTestProxyDatabase(t *testing.T) string {
+	t.Helper()
+
+	db := buildTestIP2Proxy(t, []testIP2ProxyRange{
+		{from: "8.8.8.0", to: "8.8.8.255", country: "US", proxyType: "DCH"},
+		{from: "185.5.82.0", to: "185.5.82.127", country: "DE", proxyType: "VPN"},
+		{from: "185.5.82.128", to: "185.5.82.255", country: "DE", proxyType: "TOR"},
+	})
+
+	filePath := filepath.Join(t.TempDir(), "IP2PROXY-LITE-PX2.BIN")
+	if err := os.WriteFile(filePath, db, 0o600); err != nil {
+		t.Fatalf("failed to write test database: %v", err)
+	}
+
+	return filePath
+}
+
+func TestIP2ProxyReader_Lookup(t *testing.T) {
+	reader, err := newIP2ProxyReader(bytes.NewReader(buildTestIP2Proxy(t, []testIP2ProxyRange{
+		{from: "1.0.0.0", to: "1.0.0.255", country: "AU", proxyType: "PUB"},
+		{from: "8.8.8.0", to: "8.8.8.255", country: "US", proxyType: "DCH"},
+		{from: "185.5.82.0", to: "185.5.82.127", country: "DE", proxyType: "VPN"},
+	})))
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	for ip, expected := range map[string]ip2proxyRecord{
+		"1.0.0.1":                  {CountryShort: "AU", ProxyType: "PUB"},
+		"8.8.8.8":                  {CountryShort: "US", ProxyType: "DCH"},
+		"8.8.4.4":                  {CountryShort: "-", ProxyType: "-"},
+		"185.5.82.105":             {CountryShort: "DE", ProxyType: "VPN"},
+		"185.5.82.200":             {CountryShort: "-", ProxyType: "-"},
+		"0.0.0.0":                  {CountryShort: "-", ProxyType: "-"},
+		"255.255.255.255":          {CountryShort: "-", ProxyType: "-"},
+		"::ffff:8.8.8.8":           {CountryShort: "US", ProxyType: "DCH"},
+		"2002:808:808::1":          {CountryShort: "US", ProxyType: "DCH"},
+		"2001:4860::8888":          {CountryShort: "-", ProxyType: "-"},
+		"2001:0:0:0:0:0:f7f7:f7f7": {CountryShort: "US", ProxyType: "DCH"},
+	} {
+		record, err := reader.lookup(net.ParseIP(ip))
+		if err != nil {
+			t.Errorf("expected no error for %s, but got: %v", ip, err)
+		}
+		if record != expected {
+			t.Errorf("expected %+v for %s, but got: %+v", expected, ip, record)
+		}
+	}
+}
+
+func TestNewIP2ProxyReader_Invalid(t *testing.T) {
+	for name, modify := range map[string]func(db []byte) []byte{
+		"ProductCode":    func(db []byte) []byte { db[29] = 1; return db }, // IP2Location product code
+		"MissingColumns": func(db []byte) []byte { db[1] = 2; return db },  // PX2 without country column
+		"Truncated":      func(db []byte) []byte { return db[:20] },
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := newIP2ProxyReader(bytes.NewReader(modify(buildTestIP2Proxy(t, nil)))); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestIP2ProxyReader_LookupTruncated(t *testing.T) {
+	db := buildTestIP2Proxy(t, []testIP2ProxyRange{{from: "1.2.3.0", to: "1.2.3.255", country: "US", proxyType: "VPN"}})
+
+	reader, err := newIP2ProxyReader(bytes.NewReader(db[:64]))
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if _, err := reader.lookup(net.ParseIP("1.2.3.4")); err == nil {
+		t.Error("expected error, but got none")
+	}
+}
+
+func TestPlugin_ServeHTTP_Proxy(t *testing.T) {
+	proxyDBFilePath := writeTestProxyDatabase(t)
+
+	t.Run("NoProxyDatabase", func(t *testing.T) {
+		cfg := &Config{
+			Enabled:              true,
+			DatabaseFilePath:     dbFilePath,
+			BlockedProxyTypes:    []string{"VPN"},
+			DisallowedStatusCode: http.StatusForbidden,
+		}
+
+		plugin, err := New(context.TODO(), &noopHandler{}, cfg, pluginName)
+		if err == nil {
+			t.Errorf("expected error, but got none")
+		}
+		if plugin != nil {
+			t.Error("expected plugin to be nil, but is not")
+		}
+	})
+
+	t.Run("BlockedProxyTypes", func(t *testing.T) {
+		cfg := &Config{
+			Enabled:               true,
+			DatabaseFilePath:      dbFilePath,
+			ProxyDatabaseFilePath: proxyDBFilePath,
+			AllowedCountries:      []string{"DE", "US"},
+			BlockedProxyTypes:     []string{"vpn", "TOR"},
+			DisallowedStatusCode:  http.StatusForbidden,
+		}
+
+		testRequest(t, "VPN in allowed country", cfg, "185.5.82.105", http.StatusForbidden)
+		testRequest(t, "Data center in allowed country", cfg, "8.8.8.8", http.StatusTeapot)
+		testRequest(t, "No proxy in allowed country", cfg, "1.1.1.1", http.StatusTeapot)
+
+		cfg.AllowedIPBlocks = []string{"185.5.82.105/32"}
+
+		testRequest(t, "IP CIDR allow trumps proxy block", cfg, "185.5.82.105", http.StatusTeapot)
+	})
+
+	t.Run("Decision", func(t *testing.T) {
+		cfg := &Config{
+			Enabled:               true,
+			DatabaseFilePath:      dbFilePath,
+			ProxyDatabaseFilePath: proxyDBFilePath,
+			BlockedProxyTypes:     []string{"TOR"},
+			DefaultAllow:          true,
+			DisallowedStatusCode:  http.StatusForbidden,
+		}
+
+		plugin, err := New(context.TODO(), &noopHandler{}, cfg, pluginName)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+
+		decision, err := plugin.(*Plugin).Decide("185.5.82.200")
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		expected := Decision{Allowed: false, IP: "185.5.82.200", Country: "DE", ProxyType: "TOR", Reason: reasonProxy}
+		if decision != expected {
+			t.Errorf("expected decision %+v, but got: %+v", expected, decision)
+		}
+
+		decision, err = plugin.(*Plugin).Decide("8.8.8.8")
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		expected = Decision{Allowed: true, IP: "8.8.8.8", Country: "US", ProxyType: "DCH", Reason: reasonDefault}
+		if decision != expected {
+			t.Errorf("expected decision %+v, but got: %+v", expected, decision)
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package traefik_plugin_geoblock
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Modes for handling CORS preflight requests.
+const (
+	preflightModeEvaluate        = "evaluate"
+	preflightModeAllow           = "allow"
+	preflightModeRequestedMethod = "requested-method"
+)
+
+// reasonPreflight is the reason for allowing CORS preflight requests with preflight mode "allow".
+const reasonPreflight = "preflight"
+
+// methodPolicyPrefix is followed by the methods of a method policy in decisions, e.g. "method:DELETE,POST".
+const methodPolicyPrefix = "method:"
+
+// MethodPolicyConfig defines a policy for requests with specific HTTP methods, in addition to the global, path or host policy.
+type MethodPolicyConfig struct {
+	Methods              []string // HTTP methods to apply the policy to (e.g. POST, PUT, PATCH, DELETE)
+	AllowedCountries     []string // Whitelist of countries to allow (ISO 3166-1 codes or @groups)
+	BlockedCountries     []string // Blocklist of countries to be blocked (ISO 3166-1 codes or @groups)
+	AllowedIPBlocks      []string // List of whitelist CIDR
+	BlockedIPBlocks      []string // List of blocklisted CIDRs
+	DefaultAllow         bool     // If source matches neither blocklist nor whitelist, should it be allowed through?
+	DisallowedStatusCode int      // HTTP status code to return for disallowed requests (default: the global one)
+}
+
+// methodPolicy is a policy applying to requests with specific HTTP methods.
+type methodPolicy struct {
+	methods map[string]struct{}
+	policy  *policy
+}
+
+// initMethods normalizes and validates a list of HTTP methods.
+func initMethods(methods []string) (map[string]struct{}, error) {
+	methodSet := make(map[string]struct{}, len(methods))
+
+	for _, method := range methods {
+		normalized := strings.ToUpper(strings.TrimSpace(method))
+		if normalized == "" || strings.IndexFunc(normalized, func(r rune) bool { return r < 'A' || r > 'Z' }) >= 0 {
+			return nil, fmt.Errorf("%q is not a valid HTTP method", method)
+		}
+		methodSet[normalized] = struct{}{}
+	}
+
+	return methodSet, nil
+}
+
+// initMethodPolicies compiles the configured method policies. A method may only be part of a single policy.
+func initMethodPolicies(methods []MethodPolicyConfig, global *Config, countryGroups map[string][]string,
+	logf func(format string, args ...interface{}),
+) ([]*methodPolicy, error) {
+	methodPolicies := make([]*methodPolicy, 0, len(methods))
+	seen := make(map[string]struct{})
+
+	for i, methodCfg := range methods {
+		methodSet, err := initMethods(methodCfg.Methods)
+		if err != nil {
+			return nil, fmt.Errorf("method policy #%d: %w", i+1, err)
+		}
+		if len(methodSet) == 0 {
+			return nil, fmt.Errorf("method policy #%d: no methods configured", i+1)
+		}
+
+		names := make([]string, 0, len(methodSet))
+		for method := range methodSet {
+			if _, ok := seen[method]; ok {
+				return nil, fmt.Errorf("method policy #%d: %s is part of more than one method policy", i+1, method)
+			}
+			seen[method] = struct{}{}
+			names = append(names, method)
+		}
+		sort.Strings(names)
+
+		pol, err := initOverridePolicy(methodPolicyPrefix+strings.Join(names, ","), &Config{
+			AllowedCountries: methodCfg.AllowedCountries,
+			BlockedCountries: methodCfg.BlockedCountries,
+			AllowedIPBlocks:  methodCfg.AllowedIPBlocks,
+			BlockedIPBlocks:  methodCfg.BlockedIPBlocks,
+			DefaultAllow:     methodCfg.DefaultAllow,
+			AllowPrivate:     global.AllowPrivate,
+		}, methodCfg.DisallowedStatusCode, countryGroups, logf)
+		if err != nil {
+			return nil, fmt.Errorf("method policy #%d: %w", i+1, err)
+		}
+
+		methodPolicies = append(methodPolicies, &methodPolicy{methods: methodSet, policy: pol})
+	}
+
+	return methodPolicies, nil
+}
+
+// selectMethodPolicy returns the method policy applying to the given method, if any.
+func selectMethodPolicy(methodPolicies []*methodPolicy, method string) *methodPolicy {
+	for _, mp := range methodPolicies {
+		if _, ok := mp.methods[method]; ok {
+			return mp
+		}
+	}
+
+	return nil
+}
+
+// initPreflightMode validates the mode for handling CORS preflight requests.
+func initPreflightMode(mode string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", preflightModeEvaluate:
+		return preflightModeEvaluate, nil
+	case preflightModeAllow:
+		return preflightModeAllow, nil
+	case preflightModeRequestedMethod:
+		return preflightModeRequestedMethod, nil
+	default:
+		return "", fmt.Errorf("%q is not a valid preflight mode, must be one of %q, %q or %q",
+			mode, preflightModeEvaluate, preflightModeAllow, preflightModeRequestedMethod)
+	}
+}
+
+// isPreflightRequest indicates whether the request is a CORS preflight request.
+func isPreflightRequest(req *http.Request) bool {
+	return req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// methodCondition matches a set of HTTP methods.
+type methodCondition map[string]struct{}
+
+func (c methodCondition) matches(ctx *evalContext) (bool, error) {
+	_, ok := c[ctx.method]
+	return ok, nil
+}
@@ -0,0 +1,222 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInitMethods(t *testing.T) {
+	methods, err := initMethods([]string{"get", " POST ", "PROPFIND"})
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	for _, method := range []string{"GET", "POST", "PROPFIND"} {
+		if _, ok := methods[method]; !ok {
+			t.Errorf("expected %s to be included, but it is not", method)
+		}
+	}
+
+	for _, method := range []string{"", "GET POST", "M-SEARCH", "GET,POST"} {
+		if _, err := initMethods([]string{method}); err == nil {
+			t.Errorf("expected error for %q, but got none", method)
+		}
+	}
+}
+
+func TestInitMethodPolicies_Errors(t *testing.T) {
+	for name, methods := range map[string][]MethodPolicyConfig{
+		"NoMethods":         {{AllowedCountries: []string{"DE"}}},
+		"InvalidMethod":     {{Methods: []string{"P0ST"}}},
+		"Duplicate":         {{Methods: []string{"POST"}}, {Methods: []string{"PUT", "post"}}},
+		"InvalidStatusCode": {{Methods: []string{"POST"}, DisallowedStatusCode: -1}},
+		"InvalidCountry":    {{Methods: []string{"POST"}, AllowedCountries: []string{"@MISSING"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := initMethodPolicies(methods, &Config{}, nil, t.Logf); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestInitPreflightMode(t *testing.T) {
+	for mode, expected := range map[string]string{
+		"":                 preflightModeEvaluate,
+		"evaluate":         preflightModeEvaluate,
+		"Allow":            preflightModeAllow,
+		"requested-method": preflightModeRequestedMethod,
+	} {
+		if actual, err := initPreflightMode(mode); err != nil || actual != expected {
+			t.Errorf("expected %q for %q, but got: %q (%v)", expected, mode, actual, err)
+		}
+	}
+
+	if _, err := initPreflightMode("deny"); err == nil {
+		t.Error("expected error, but got none")
+	}
+}
+
+func TestPlugin_ServeHTTP_MethodPolicies(t *testing.T) {
+	newPlugin := func(t *testing.T, preflightMode string) http.Handler {
+		plugin, err := New(context.TODO(), &noopHandler{}, &Config{
+			Enabled:          true,
+			DatabaseFilePath: dbFilePath,
+			AllowedCountries: []string{"US", "DE"},
+			Methods: []MethodPolicyConfig{
+				{Methods: []string{"post", "DELETE"}, AllowedCountries: []string{"DE"}, DisallowedStatusCode: http.StatusMethodNotAllowed},
+			},
+			Paths:                []PathPolicyConfig{{Path: "/public", DefaultAllow: true}},
+			PreflightMode:        preflightMode,
+			DisallowedStatusCode: http.StatusForbidden,
+		}, pluginName)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		return plugin
+	}
+
+	request := func(plugin http.Handler, method, path, requestedMethod, ip string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-Real-IP", ip)
+		if requestedMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", requestedMethod)
+		}
+
+		rr := httptest.NewRecorder()
+		plugin.ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	for _, test := range []struct {
+		name, preflightMode, method, path, requestedMethod, ip string
+		expectedStatus                                         int
+	}{
+		{"Reads allowed for US", "", http.MethodGet, "/", "", "8.8.8.8", http.StatusTeapot},
+		{"Reads blocked for RU", "", http.MethodGet, "/", "", "77.88.8.8", http.StatusForbidden},
+		{"Writes allowed for DE", "", http.MethodPost, "/", "", "185.5.82.105", http.StatusTeapot},
+		{"Writes blocked for US", "", http.MethodDelete, "/", "", "8.8.8.8", http.StatusMethodNotAllowed},
+		{"Other methods use global policy", "", http.MethodPut, "/", "", "8.8.8.8", http.StatusTeapot},
+		{"Path reads allowed for RU", "", http.MethodGet, "/public", "", "77.88.8.8", http.StatusTeapot},
+		{"Path writes blocked by method for RU", "", http.MethodPost, "/public", "", "77.88.8.8", http.StatusMethodNotAllowed},
+		{"Path writes allowed by method for DE", "", http.MethodPost, "/public", "", "185.5.82.105", http.StatusTeapot},
+		{"Evaluate preflight as OPTIONS", "evaluate", http.MethodOptions, "/", "POST", "8.8.8.8", http.StatusTeapot},
+		{"Evaluate preflight blocks RU", "evaluate", http.MethodOptions, "/", "POST", "77.88.8.8", http.StatusForbidden},
+		{"Allow preflight", "allow", http.MethodOptions, "/", "POST", "77.88.8.8", http.StatusTeapot},
+		{"Allow only preflight", "allow", http.MethodOptions, "/", "", "77.88.8.8", http.StatusForbidden},
+		{"Requested method blocked", "requested-method", http.MethodOptions, "/", "post", "8.8.8.8", http.StatusMethodNotAllowed},
+		{"Requested method allowed", "requested-method", http.MethodOptions, "/", "GET", "8.8.8.8", http.StatusTeapot},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			plugin := newPlugin(t, test.preflightMode)
+			if status := request(plugin, test.method, test.path, test.requestedMethod, test.ip); status != test.expectedStatus {
+				t.Errorf("expected status code %d, but got: %d", test.expectedStatus, status)
+			}
+		})
+	}
+
+	t.Run("Decision", func(t *testing.T) {
+		plugin := newPlugin(t, "").(*Plugin)
+		req := httptest.NewRequest(http.MethodDelete, "/", nil)
+
+		decision, err := plugin.DecideRequest(req, "8.8.8.8")
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if decision.Allowed || decision.Policy != "method:DELETE,POST" || decision.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("expected request to be blocked by method policy, but got: %+v", decision)
+		}
+	})
+
+	t.Run("GlobalPolicyStillApplies", func(t *testing.T) {
+		plugin, err := New(context.TODO(), &noopHandler{}, &Config{
+			Enabled:          true,
+			DatabaseFilePath: dbFilePath,
+			AllowedCountries: []string{"US"},
+			Methods: []MethodPolicyConfig{
+				{Methods: []string{"POST"}, BlockedCountries: []string{"DE"}, DefaultAllow: true, DisallowedStatusCode: http.StatusMethodNotAllowed},
+			},
+			DisallowedStatusCode: http.StatusForbidden,
+		}, pluginName)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+
+		for _, test := range []struct {
+			ip             string
+			expectedStatus int
+		}{
+			{"8.8.8.8", http.StatusTeapot},
+			{"77.88.8.8", http.StatusForbidden},
+			{"185.5.82.105", http.StatusForbidden},
+		} {
+			if status := request(plugin, http.MethodPost, "/", "", test.ip); status != test.expectedStatus {
+				t.Errorf("POST from %s: expected status code %d, but got: %d", test.ip, test.expectedStatus, status)
+			}
+		}
+	})
+
+	t.Run("CatchAllHost", func(t *testing.T) {
+		plugin, err := New(context.TODO(), &noopHandler{}, &Config{
+			Enabled:          true,
+			DatabaseFilePath: dbFilePath,
+			AllowedCountries: []string{"US", "DE"},
+			Methods: []MethodPolicyConfig{
+				{Methods: []string{"POST"}, AllowedCountries: []string{"DE"}, DisallowedStatusCode: http.StatusMethodNotAllowed},
+			},
+			Hosts:                map[string]HostPolicyConfig{"*": {DefaultAllow: true}},
+			DisallowedStatusCode: http.StatusForbidden,
+		}, pluginName)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+
+		for _, test := range []struct {
+			method, ip     string
+			expectedStatus int
+		}{
+			{http.MethodGet, "77.88.8.8", http.StatusTeapot},
+			{http.MethodPost, "77.88.8.8", http.StatusMethodNotAllowed},
+			{http.MethodPost, "185.5.82.105", http.StatusTeapot},
+		} {
+			if status := request(plugin, test.method, "/", "", test.ip); status != test.expectedStatus {
+				t.Errorf("expected status code %d for %s from %s, but got: %d", test.expectedStatus, test.method, test.ip, status)
+			}
+		}
+	})
+
+	t.Run("RuleCondition", func(t *testing.T) {
+		plugin, err := New(context.TODO(), &noopHandler{}, &Config{
+			Enabled:          true,
+			DatabaseFilePath: dbFilePath,
+			Rules: []RuleConfig{
+				{Name: "writes", Action: "block", Methods: []string{"POST"}, Countries: []string{"US"}},
+				{Action: "allow", Expression: `method == "put" && country == "US"`},
+				{Action: "allow", Methods: []string{"GET"}},
+			},
+			PreflightMode:        preflightModeRequestedMethod,
+			DisallowedStatusCode: http.StatusForbidden,
+		}, pluginName)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+
+		for _, test := range []struct {
+			method, requestedMethod string
+			expectedStatus          int
+		}{
+			{http.MethodGet, "", http.StatusTeapot},
+			{http.MethodPost, "", http.StatusForbidden},
+			{http.MethodPut, "", http.StatusTeapot},
+			{http.MethodPatch, "", http.StatusForbidden},
+			{http.MethodOptions, "PUT", http.StatusTeapot},
+			{http.MethodOptions, "POST", http.StatusForbidden},
+		} {
+			if status := request(plugin, test.method, "/", test.requestedMethod, "8.8.8.8"); status != test.expectedStatus {
+				t.Errorf("expected status code %d for %s %s, but got: %d", test.expectedStatus, test.method, test.requestedMethod, status)
+			}
+		}
+	})
+}
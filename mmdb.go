@@ -0,0 +1,360 @@
+package traefik_plugin_geoblock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// mmdbMetadataMarker separates the search tree and data section from the metadata section.
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// mmdbDataSectionSeparatorSize is the number of zero bytes between the search tree and the data section.
+const mmdbDataSectionSeparatorSize = 16
+
+// mmdb data field types, see https://maxmind.github.io/MaxMind-DB/
+const (
+	mmdbTypeExtended = iota
+	mmdbTypePointer
+	mmdbTypeString
+	mmdbTypeDouble
+	mmdbTypeBytes
+	mmdbTypeUint16
+	mmdbTypeUint32
+	mmdbTypeMap
+	mmdbTypeInt32
+	mmdbTypeUint64
+	mmdbTypeUint128
+	mmdbTypeSlice
+	mmdbTypeContainer
+	mmdbTypeEndMarker
+	mmdbTypeBool
+	mmdbTypeFloat
+)
+
+// mmdbReader is a minimal reader for MaxMind DB files, such as GeoLite2-ASN.
+// It is implemented without external dependencies, so it works when interpreted by yaegi.
+type mmdbReader struct {
+	buf          []byte
+	data         []byte
+	nodeCount    uint
+	recordSize   uint
+	ipVersion    uint
+	databaseType string
+	ipv4Start    uint
+}
+
+// openMMDB reads the MaxMind DB file at the given path into memory.
+func openMMDB(filePath string) (*mmdbReader, error) {
+	buf, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMMDBReader(buf)
+}
+
+// newMMDBReader parses the metadata of a MaxMind DB held in buf.
+func newMMDBReader(buf []byte) (*mmdbReader, error) {
+	markerIdx := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if markerIdx == -1 {
+		return nil, errors.New("invalid MaxMind DB file: metadata marker not found")
+	}
+
+	metaDecoder := mmdbDecoder{buf: buf[markerIdx+len(mmdbMetadataMarker):]}
+	metaValue, _, err := metaDecoder.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MaxMind DB metadata: %w", err)
+	}
+	meta, ok := metaValue.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid MaxMind DB metadata: not a map")
+	}
+
+	r := &mmdbReader{buf: buf}
+	r.nodeCount = mmdbUint(meta["node_count"])
+	r.recordSize = mmdbUint(meta["record_size"])
+	r.ipVersion = mmdbUint(meta["ip_version"])
+	r.databaseType, _ = meta["database_type"].(string)
+
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported MaxMind DB record size %d", r.recordSize)
+	}
+
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+mmdbDataSectionSeparatorSize > uint(markerIdx) {
+		return nil, errors.New("invalid MaxMind DB file: search tree exceeds file size")
+	}
+	r.data = buf[treeSize+mmdbDataSectionSeparatorSize : markerIdx]
+
+	if r.ipVersion == 6 {
+		// IPv4 addresses are stored in the ::/96 subtree of IPv6 databases.
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node, err = r.readNode(node, 0)
+			if err != nil {
+				return nil, err
+			}
+		}
+		r.ipv4Start = node
+	}
+
+	return r, nil
+}
+
+// lookup returns the data record for the given IP address, or nil if the address is not contained in the database.
+func (r *mmdbReader) lookup(ip net.IP) (interface{}, error) {
+	node := uint(0)
+	bitCount := 128
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bitCount = 32
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else if r.ipVersion == 4 {
+		return nil, fmt.Errorf("cannot look up IPv6 address %s in an IPv4-only database", ip)
+	}
+
+	var err error
+	for i := 0; i < bitCount && node < r.nodeCount; i++ {
+		bit := uint(ip[i>>3]>>(7-uint(i%8))) & 1
+		node, err = r.readNode(node, bit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if node == r.nodeCount {
+		return nil, nil
+	}
+	if node < r.nodeCount {
+		return nil, errors.New("invalid MaxMind DB file: search tree is deeper than the address")
+	}
+
+	offset := node - r.nodeCount - mmdbDataSectionSeparatorSize
+	decoder := mmdbDecoder{buf: r.data}
+	value, _, err := decoder.decode(offset)
+
+	return value, err
+}
+
+// readNode reads the left (bit 0) or right (bit 1) record of the given search tree node.
+func (r *mmdbReader) readNode(node, bit uint) (uint, error) {
+	nodeSize := r.recordSize / 4
+	offset := node * nodeSize
+	if offset+nodeSize > uint(len(r.buf)) {
+		return 0, errors.New("invalid MaxMind DB file: node out of bounds")
+	}
+	b := r.buf[offset : offset+nodeSize]
+
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6]), nil
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:])), nil
+	}
+}
+
+// mmdbDecoder decodes values from the data (or metadata) section of a MaxMind DB.
+type mmdbDecoder struct {
+	buf []byte
+}
+
+// decode decodes the value at the given offset and returns it along with the offset of the next value.
+func (d mmdbDecoder) decode(offset uint) (interface{}, uint, error) {
+	typeNum, size, offset, err := d.decodeControl(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typeNum == mmdbTypePointer {
+		pointer, next, err := d.decodePointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer)
+		return value, next, err
+	}
+
+	if typeNum == mmdbTypeMap || typeNum == mmdbTypeSlice {
+		return d.decodeContainer(typeNum, size, offset)
+	}
+
+	if typeNum == mmdbTypeBool {
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errors.New("invalid MaxMind DB data: value out of bounds")
+	}
+	b := d.buf[offset : offset+size]
+	next := offset + size
+
+	switch typeNum {
+	case mmdbTypeString:
+		return string(b), next, nil
+	case mmdbTypeBytes:
+		return b, next, nil
+	case mmdbTypeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid MaxMind DB data: double of size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case mmdbTypeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid MaxMind DB data: float of size %d", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case mmdbTypeUint16, mmdbTypeUint32, mmdbTypeUint64:
+		var value uint64
+		for _, c := range b {
+			value = value<<8 | uint64(c)
+		}
+		return value, next, nil
+	case mmdbTypeInt32:
+		var value uint32
+		for _, c := range b {
+			value = value<<8 | uint32(c)
+		}
+		return int64(int32(value)), next, nil
+	case mmdbTypeUint128:
+		// 128 bit integers are not needed by any of the supported databases.
+		return b, next, nil
+	default:
+		return nil, 0, fmt.Errorf("invalid MaxMind DB data: unexpected type %d", typeNum)
+	}
+}
+
+// decodeControl decodes the control byte(s) at offset, returning the type and size of the following value.
+func (d mmdbDecoder) decodeControl(offset uint) (typeNum, size, next uint, err error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, 0, 0, errors.New("invalid MaxMind DB data: offset out of bounds")
+	}
+
+	ctrl := d.buf[offset]
+	offset++
+
+	typeNum = uint(ctrl >> 5)
+	if typeNum == mmdbTypeExtended {
+		if offset >= uint(len(d.buf)) {
+			return 0, 0, 0, errors.New("invalid MaxMind DB data: offset out of bounds")
+		}
+		typeNum = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	size = uint(ctrl & 0x1F)
+	if typeNum == mmdbTypePointer || size < 29 {
+		return typeNum, size, offset, nil
+	}
+
+	extraBytes := size - 28
+	if offset+extraBytes > uint(len(d.buf)) {
+		return 0, 0, 0, errors.New("invalid MaxMind DB data: size out of bounds")
+	}
+
+	var extra uint
+	for _, c := range d.buf[offset : offset+extraBytes] {
+		extra = extra<<8 | uint(c)
+	}
+
+	switch size {
+	case 29:
+		size = 29 + extra
+	case 30:
+		size = 285 + extra
+	default:
+		size = 65821 + extra
+	}
+
+	return typeNum, size, offset + extraBytes, nil
+}
+
+// decodePointer decodes a pointer whose control byte carried the given size bits.
+func (d mmdbDecoder) decodePointer(size, offset uint) (pointer, next uint, err error) {
+	pointerSize := ((size >> 3) & 0x3) + 1
+	if offset+pointerSize > uint(len(d.buf)) {
+		return 0, 0, errors.New("invalid MaxMind DB data: pointer out of bounds")
+	}
+
+	var prefix uint
+	if pointerSize != 4 {
+		prefix = size & 0x7
+	}
+
+	pointer = prefix
+	for _, c := range d.buf[offset : offset+pointerSize] {
+		pointer = pointer<<8 | uint(c)
+	}
+
+	switch pointerSize {
+	case 2:
+		pointer += 2048
+	case 3:
+		pointer += 526336
+	}
+
+	return pointer, offset + pointerSize, nil
+}
+
+// decodeContainer decodes a map or slice of the given size starting at offset.
+func (d mmdbDecoder) decodeContainer(typeNum, size, offset uint) (interface{}, uint, error) {
+	if typeNum == mmdbTypeSlice {
+		values := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			var value interface{}
+			var err error
+			value, offset, err = d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			values = append(values, value)
+		}
+		return values, offset, nil
+	}
+
+	values := make(map[string]interface{}, size)
+	for i := uint(0); i < size; i++ {
+		var key, value interface{}
+		var err error
+		key, offset, err = d.decode(offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		keyStr, ok := key.(string)
+		if !ok {
+			return nil, 0, errors.New("invalid MaxMind DB data: map key is not a string")
+		}
+		value, offset, err = d.decode(offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		values[keyStr] = value
+	}
+
+	return values, offset, nil
+}
+
+// mmdbUint converts a decoded unsigned integer value to uint.
+func mmdbUint(value interface{}) uint {
+	switch v := value.(type) {
+	case uint64:
+		return uint(v)
+	case int64:
+		return uint(v)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,313 @@
+package traefik_plugin_geoblock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testMMDBNetwork is a network and its record, to be written to a test MaxMind DB.
+type testMMDBNetwork struct {
+	cidr   string
+	record interface{} // A map, or a pointer to the record of another network
+}
+
+// testMMDBPointer is a pointer to the given offset in the data section of a test MaxMind DB.
+type testMMDBPointer uint
+
+// buildTestMMDB builds a MaxMind DB with 24 bit records containing the given networks.
+func buildTestMMDB(t *testing.T, ipVersion int, networks []testMMDBNetwork) []byte {
+	t.Helper()
+
+	const empty = -1
+	type node struct{ records [2]int }
+	nodes := []*node{{records: [2]int{empty, empty}}}
+
+	var data []byte
+	var dataOffsets []int
+
+	for _, network := range networks {
+		_, ipNet, err := net.ParseCIDR(network.cidr)
+		if err != nil {
+			t.Fatalf("invalid test network: %v", err)
+		}
+
+		ip := ipNet.IP
+		ones, _ := ipNet.Mask.Size()
+		if ipVersion == 6 && ip.To4() != nil {
+			ip = append(make(net.IP, 12), ip.To4()...)
+			ones += 96
+		}
+
+		dataOffsets = append(dataOffsets, len(data))
+		data = append(data, encodeTestMMDBValue(t, network.record)...)
+		dataRef := -2 - (len(dataOffsets) - 1)
+
+		current := 0
+		for i := 0; i < ones; i++ {
+			bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+			if i == ones-1 {
+				nodes[current].records[bit] = dataRef
+				break
+			}
+			if nodes[current].records[bit] == empty {
+				nodes = append(nodes, &node{records: [2]int{empty, empty}})
+				nodes[current].records[bit] = len(nodes) - 1
+			}
+			current = nodes[current].records[bit]
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	for _, n := range nodes {
+		for _, record := range n.records {
+			value := record
+			if record == empty {
+				value = len(nodes)
+			} else if record < empty {
+				value = len(nodes) + mmdbDataSectionSeparatorSize + dataOffsets[-record-2]
+			}
+			buf.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	buf.Write(make([]byte, mmdbDataSectionSeparatorSize))
+	buf.Write(data)
+	buf.Write(mmdbMetadataMarker)
+	buf.Write(encodeTestMMDBValue(t, map[string]interface{}{
+		"node_count":    uint32(len(nodes)),
+		"record_size":   uint16(24),
+		"ip_version":    uint16(ipVersion),
+		"database_type": "Test-ASN",
+	}))
+
+	return buf.Bytes()
+}
+
+// encodeTestMMDBValue encodes a value in the MaxMind DB data format.
+func encodeTestMMDBValue(t *testing.T, value interface{}) []byte {
+	t.Helper()
+
+	switch v := value.(type) {
+	case string:
+		if len(v) >= 29 {
+			return append([]byte{mmdbTypeString<<5 | 29, byte(len(v) - 29)}, v...)
+		}
+		return append([]byte{mmdbTypeString<<5 | byte(len(v))}, v...)
+	case uint16:
+		b := make([]byte, 2)
+		binary.BigEndian.PutUint16(b, v)
+		return append([]byte{mmdbTypeUint16<<5 | 2}, b...)
+	case uint32:
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, v)
+		return append([]byte{mmdbTypeUint32<<5 | 4}, b...)
+	case bool:
+		if v {
+			return []byte{1, mmdbTypeBool - 7}
+		}
+		return []byte{0, mmdbTypeBool - 7}
+	case testMMDBPointer:
+		// NB: pointers are encoded with the fewest bytes, each size adds the largest pointer of the previous one.
+		switch p := uint(v); {
+		case p < 2048:
+			return []byte{mmdbTypePointer<<5 | byte(p>>8), byte(p)}
+		case p < 526336:
+			p -= 2048
+			return []byte{mmdbTypePointer<<5 | 1<<3 | byte(p>>16), byte(p >> 8), byte(p)}
+		case p < 134744064:
+			p -= 526336
+			return []byte{mmdbTypePointer<<5 | 2<<3 | byte(p>>24), byte(p >> 16), byte(p >> 8), byte(p)}
+		default:
+			return []byte{mmdbTypePointer<<5 | 3<<3, byte(p >> 24), byte(p >> 16), byte(p >> 8), byte(p)}
+		}
+	case map[string]interface{}:
+		b := []byte{mmdbTypeMap<<5 | byte(len(v))}
+		for key, item := range v {
+			b = append(b, encodeTestMMDBValue(t, key)...)
+			b = append(b, encodeTestMMDBValue(t, item)...)
+		}
+		return b
+	default:
+		t.Fatalf("unsupported test value type %T", value)
+		return nil
+	}
+}
+
+// writeTestASNDatabase writes a GeoLite2-ASN like test database and returns its path.
+func writeTestASNDatabase(t *testing.T) string {
+	t.Helper()
+
+	db := buildTestMMDB(t, 6, []testMMDBNetwork{
+		{cidr: "8.8.8.0/24", record: map[string]interface{}{
+			"autonomous_system_number":       uint32(15169),
+			"autonomous_system_organization": "GOOGLE",
+		}},
+		{cidr: "185.5.82.0/24", record: map[string]interface{}{
+			"autonomous_system_number":       uint32(24940),
+			"autonomous_system_organization": "Hetzner Online GmbH",
+		}},
+		{cidr: "2001:4860::/32", record: map[string]interface{}{
+			"autonomous_system_number":       uint32(15169),
+			"autonomous_system_organization": "GOOGLE",
+		}},
+	})
+
+	filePath := filepath.Join(t.TempDir(), "GeoLite2-ASN.mmdb")
+	if err := os.WriteFile(filePath, db, 0o600); err != nil {
+		t.Fatalf("failed to write test database: %v", err)
+	}
+
+	return filePath
+}
+
+func TestMMDBReader_Lookup(t *testing.T) {
+	networks := []testMMDBNetwork{
+		{cidr: "8.8.8.0/24", record: map[string]interface{}{
+			"autonomous_system_number":       uint32(15169),
+			"autonomous_system_organization": "GOOGLE",
+			"anycast":                        true,
+		}},
+		{cidr: "2001:4860::/32", record: map[string]interface{}{
+			"autonomous_system_number": uint32(15169),
+		}},
+	}
+
+	t.Run("IPv6Database", func(t *testing.T) {
+		reader, err := newMMDBReader(buildTestMMDB(t, 6, networks))
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if reader.databaseType != "Test-ASN" {
+			t.Errorf("expected database type %q, but got: %q", "Test-ASN", reader.databaseType)
+		}
+
+		value, err := reader.lookup(net.ParseIP("8.8.8.8"))
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		record, ok := value.(map[string]interface{})
+		if !ok {
+			t.Fatalf("expected record to be a map, but got: %T", value)
+		}
+		if mmdbUint(record["autonomous_system_number"]) != 15169 {
+			t.Errorf("expected ASN %d, but got: %v", 15169, record["autonomous_system_number"])
+		}
+		if record["autonomous_system_organization"] != "GOOGLE" {
+			t.Errorf("expected organization %q, but got: %v", "GOOGLE", record["autonomous_system_organization"])
+		}
+		if record["anycast"] != true {
+			t.Errorf("expected anycast to be true, but got: %v", record["anycast"])
+		}
+
+		value, err = reader.lookup(net.ParseIP("2001:4860:4860::8888"))
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if value == nil {
+			t.Error("expected IPv6 record, but got none")
+		}
+
+		value, err = reader.lookup(net.ParseIP("8.8.4.4"))
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if value != nil {
+			t.Errorf("expected no record, but got: %v", value)
+		}
+	})
+
+	t.Run("IPv4Database", func(t *testing.T) {
+		reader, err := newMMDBReader(buildTestMMDB(t, 4, networks[:1]))
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+
+		value, err := reader.lookup(net.ParseIP("8.8.8.8"))
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if value == nil {
+			t.Error("expected record, but got none")
+		}
+
+		if _, err = reader.lookup(net.ParseIP("2001:4860:4860::8888")); err == nil {
+			t.Error("expected error, but got none")
+		}
+	})
+
+	t.Run("PointerRecord", func(t *testing.T) {
+		// NB: the record of the first network is at the start of the data section, pointers are relative to it.
+		reader, err := newMMDBReader(buildTestMMDB(t, 6, []testMMDBNetwork{
+			networks[0],
+			{cidr: "8.8.4.0/24", record: testMMDBPointer(0)},
+		}))
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+
+		value, err := reader.lookup(net.ParseIP("8.8.4.4"))
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		record, ok := value.(map[string]interface{})
+		if !ok || record["autonomous_system_organization"] != "GOOGLE" {
+			t.Errorf("expected the record of 8.8.8.0/24, but got: %v", value)
+		}
+	})
+
+	t.Run("InvalidFile", func(t *testing.T) {
+		if _, err := newMMDBReader([]byte("not a database")); err == nil {
+			t.Error("expected error, but got none")
+		}
+	})
+}
+
+func TestMMDBDecoder_DecodePointer(t *testing.T) {
+	// NB: the targets are at the bounds of each pointer size, and use all bits of the first byte where possible.
+	targets := []uint{0, 2040, 2048, 526320, 526336, 600000}
+
+	buf := make([]byte, 600016)
+	for _, target := range targets {
+		copy(buf[target:], encodeTestMMDBValue(t, fmt.Sprint(target)))
+	}
+
+	for _, test := range []struct {
+		pointer []byte
+		target  uint
+	}{
+		{encodeTestMMDBValue(t, testMMDBPointer(0)), 0},
+		{encodeTestMMDBValue(t, testMMDBPointer(2040)), 2040},
+		{encodeTestMMDBValue(t, testMMDBPointer(2048)), 2048},
+		{encodeTestMMDBValue(t, testMMDBPointer(526320)), 526320},
+		{encodeTestMMDBValue(t, testMMDBPointer(526336)), 526336},
+		{encodeTestMMDBValue(t, testMMDBPointer(600000)), 600000},
+		// Pointers of 4 bytes don't add a base, and ignore the value bits of the control byte.
+		{[]byte{mmdbTypePointer<<5 | 3<<3 | 7, 0, 0, 0x07, 0xF8}, 2040},
+	} {
+		decoder := mmdbDecoder{buf: append(append([]byte{}, buf...), test.pointer...)}
+		offset := uint(len(buf))
+
+		value, next, err := decoder.decode(offset)
+		if err != nil {
+			t.Errorf("expected no error for pointer %x, but got: %v", test.pointer, err)
+			continue
+		}
+		if expected := fmt.Sprint(test.target); value != expected {
+			t.Errorf("expected pointer %x to point to %q, but got: %v", test.pointer, expected, value)
+		}
+		if next != offset+uint(len(test.pointer)) {
+			t.Errorf("expected the value after pointer %x at %d, but got: %d", test.pointer, offset+uint(len(test.pointer)), next)
+		}
+	}
+
+	t.Run("OutOfBounds", func(t *testing.T) {
+		decoder := mmdbDecoder{buf: []byte{mmdbTypePointer<<5 | 1<<3, 0}}
+		if _, _, err := decoder.decode(0); err == nil {
+			t.Error("expected error, but got none")
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package traefik_plugin_geoblock
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Ways to match the path of a path policy.
+const (
+	pathMatchPrefix = "prefix"
+	pathMatchExact  = "exact"
+	pathMatchGlob   = "glob"
+)
+
+// pathPolicyPrefix is followed by the path of a path policy in decisions, e.g. "path:/admin".
+const pathPolicyPrefix = "path:"
+
+// PathPolicyConfig defines a policy for requests to specific paths, overriding the global policy.
+type PathPolicyConfig struct {
+	Path                 string   // Path to match
+	Match                string   // How to match the path: "prefix", "exact" or "glob" (default: glob if the path contains *, ? or [, otherwise prefix)
+	AllowedCountries     []string // Whitelist of countries to allow (ISO 3166-1 codes or @groups)
+	BlockedCountries     []string // Blocklist of countries to be blocked (ISO 3166-1 codes or @groups)
+	AllowedIPBlocks      []string // List of whitelist CIDR
+	BlockedIPBlocks      []string // List of blocklisted CIDRs
+	DefaultAllow         bool     // If source matches neither blocklist nor whitelist, should it be allowed through?
+	DisallowedStatusCode int      // HTTP status code to return for disallowed requests (default: the global one)
+}
+
+// pathPolicy is a policy applying to requests to specific paths.
+type pathPolicy struct {
+	path        string
+	match       string
+	specificity int
+	policy      *policy
+}
+
+// initPathPolicies compiles the configured path policies, ordered from the most to the least specific.
+// Exact paths are the most specific, followed by prefixes and glob patterns with the most literal characters.
+func initPathPolicies(paths []PathPolicyConfig, global *Config, countryGroups map[string][]string,
+	logf func(format string, args ...interface{}),
+) ([]*pathPolicy, error) {
+	pathPolicies := make([]*pathPolicy, 0, len(paths))
+	seen := make(map[string]struct{}, len(paths))
+
+	for _, pathCfg := range paths {
+		pp, err := initPathPolicy(pathCfg, global, countryGroups, logf)
+		if err != nil {
+			return nil, fmt.Errorf("path policy %q: %w", pathCfg.Path, err)
+		}
+
+		key := pp.match + " " + pp.path
+		if _, ok := seen[key]; ok {
+			return nil, fmt.Errorf("path policy %q: defined more than once", pathCfg.Path)
+		}
+		seen[key] = struct{}{}
+
+		pathPolicies = append(pathPolicies, pp)
+	}
+
+	sort.SliceStable(pathPolicies, func(i, j int) bool {
+		return pathPolicies[i].specificity > pathPolicies[j].specificity
+	})
+
+	return pathPolicies, nil
+}
+
+// initPathPolicy compiles a single path policy.
+func initPathPolicy(cfg PathPolicyConfig, global *Config, countryGroups map[string][]string,
+	logf func(format string, args ...interface{}),
+) (*pathPolicy, error) {
+	if !strings.HasPrefix(cfg.Path, "/") {
+		return nil, errors.New("path must start with /")
+	}
+
+	match := strings.ToLower(strings.TrimSpace(cfg.Match))
+	if match == "" {
+		match = pathMatchPrefix
+		if isGlobPattern(cfg.Path) {
+			match = pathMatchGlob
+		}
+	}
+
+	pp := &pathPolicy{path: cfg.Path, match: match}
+
+	switch match {
+	case pathMatchExact:
+		// NB: exact paths always take precedence over prefixes and patterns.
+		pp.path = cleanPath(cfg.Path)
+		pp.specificity = int(^uint(0) >> 1)
+	case pathMatchPrefix:
+		pp.specificity = len(cfg.Path)
+	case pathMatchGlob:
+		if _, err := path.Match(cfg.Path, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+		pp.specificity = len(cfg.Path) - strings.Count(cfg.Path, "*") - strings.Count(cfg.Path, "?")
+	default:
+		return nil, fmt.Errorf("invalid match %q, must be one of %q, %q or %q", cfg.Match, pathMatchPrefix, pathMatchExact, pathMatchGlob)
+	}
+
+	pol, err := initOverridePolicy(pathPolicyPrefix+cfg.Path, &Config{
+		AllowedCountries: cfg.AllowedCountries,
+		BlockedCountries: cfg.BlockedCountries,
+		AllowedIPBlocks:  cfg.AllowedIPBlocks,
+		BlockedIPBlocks:  cfg.BlockedIPBlocks,
+		DefaultAllow:     cfg.DefaultAllow,
+		AllowPrivate:     global.AllowPrivate,
+	}, cfg.DisallowedStatusCode, countryGroups, logf)
+	if err != nil {
+		return nil, err
+	}
+	pp.policy = pol
+
+	return pp, nil
+}
+
+// matches indicates whether the policy applies to the given cleaned request path. Prefixes only match whole segments.
+func (pp *pathPolicy) matches(requestPath string) bool {
+	switch pp.match {
+	case pathMatchExact:
+		return requestPath == pp.path
+	case pathMatchGlob:
+		matched, _ := path.Match(pp.path, requestPath)
+		return matched
+	default:
+		return hasPathPrefix(requestPath, pp.path)
+	}
+}
+
+// selectPathPolicy returns the most specific path policy applying to the given cleaned request path, if any.
+func selectPathPolicy(pathPolicies []*pathPolicy, requestPath string) *pathPolicy {
+	for _, pp := range pathPolicies {
+		if pp.matches(requestPath) {
+			return pp
+		}
+	}
+
+	return nil
+}
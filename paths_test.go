@@ -0,0 +1,126 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSelectPathPolicy(t *testing.T) {
+	pathPolicies, err := initPathPolicies([]PathPolicyConfig{
+		{Path: "/api/"},
+		{Path: "/api/*/admin"},
+		{Path: "/api/v1/"},
+		{Path: "/api/v1/health", Match: "exact"},
+		{Path: "/*.php"},
+	}, &Config{}, nil, t.Logf)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	for requestPath, expected := range map[string]string{
+		"/api/users":        "/api/",
+		"/api/v1/users":     "/api/v1/",
+		"/api/v1/admin":     "/api/*/admin",
+		"/api/v1/health":    "/api/v1/health",
+		"/api/v1/health/db": "/api/v1/",
+		"/index.php":        "/*.php",
+		"/api":              "/api/",
+		"/apiv2/users":      "",
+		"/foo":              "",
+	} {
+		actual := ""
+		if pp := selectPathPolicy(pathPolicies, requestPath); pp != nil {
+			actual = pp.path
+		}
+		if actual != expected {
+			t.Errorf("expected path policy %q for %s, but got: %q", expected, requestPath, actual)
+		}
+	}
+}
+
+func TestInitPathPolicies_Errors(t *testing.T) {
+	for name, paths := range map[string][]PathPolicyConfig{
+		"RelativePath":      {{Path: "admin"}},
+		"InvalidMatch":      {{Path: "/admin", Match: "regex"}},
+		"InvalidPattern":    {{Path: "/admin/[a"}},
+		"InvalidStatusCode": {{Path: "/admin", DisallowedStatusCode: -1}},
+		"InvalidCountry":    {{Path: "/admin", AllowedCountries: []string{"UK"}}},
+		"Duplicate":         {{Path: "/admin"}, {Path: "/admin", Match: "prefix"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := initPathPolicies(paths, &Config{}, nil, t.Logf); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestPlugin_ServeHTTP_PathPolicies(t *testing.T) {
+	cfg := &Config{
+		Enabled:          true,
+		DatabaseFilePath: dbFilePath,
+		AllowedIPBlocks:  []string{"8.8.0.0/16"},
+		BlockedIPBlocks:  []string{"8.8.4.0/24"},
+		Paths: []PathPolicyConfig{
+			{Path: "/admin", AllowedCountries: []string{"DE"}, DisallowedStatusCode: http.StatusNotFound},
+			{Path: "/api/", AllowedCountries: []string{"@EU"}},
+			{Path: "/healthz", Match: "exact", DefaultAllow: true},
+			{Path: "/.well-known/acme-challenge/", DefaultAllow: true},
+		},
+		DisallowedStatusCode: http.StatusForbidden,
+	}
+
+	plugin, err := New(context.TODO(), &noopHandler{}, cfg, pluginName)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	for _, test := range []struct {
+		name, path, ip string
+		expectedStatus int
+	}{
+		{"Global policy allows", "/foobar", "8.8.8.8", http.StatusTeapot},
+		{"Global policy blocks", "/foobar", "185.5.82.105", http.StatusForbidden},
+		{"Admin allows DE", "/admin/users", "185.5.82.105", http.StatusTeapot},
+		{"Admin blocks US with own status code", "/admin/users", "8.8.8.8", http.StatusNotFound},
+		{"API allows EU", "/api/v1", "185.5.82.105", http.StatusTeapot},
+		{"API blocks US", "/api/v1", "8.8.8.8", http.StatusForbidden},
+		{"Health check open to everyone", "/healthz", "77.88.8.8", http.StatusTeapot},
+		{"Exact path only", "/healthz/db", "77.88.8.8", http.StatusForbidden},
+		{"ACME challenge open to everyone", "/.well-known/acme-challenge/token", "77.88.8.8", http.StatusTeapot},
+		{"Global IP blocks apply globally", "/foobar", "8.8.4.4", http.StatusForbidden},
+		{"Global IP blocks not inherited", "/healthz", "8.8.4.4", http.StatusTeapot},
+		{"Duplicate slashes don't skip admin", "//admin/users", "8.8.8.8", http.StatusNotFound},
+		{"Dot segments don't skip admin", "/./admin", "8.8.8.8", http.StatusNotFound},
+		{"Dot dot segments don't skip admin", "/public/../admin/users", "8.8.8.8", http.StatusNotFound},
+		{"Admin prefix matches whole segments only", "/administrator", "8.8.8.8", http.StatusTeapot},
+		{"Exact path after cleaning", "/healthz/", "77.88.8.8", http.StatusTeapot},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			req.Header.Set("X-Real-IP", test.ip)
+
+			rr := httptest.NewRecorder()
+			plugin.ServeHTTP(rr, req)
+
+			if rr.Code != test.expectedStatus {
+				t.Errorf("expected status code %d, but got: %d", test.expectedStatus, rr.Code)
+			}
+		})
+	}
+
+	t.Run("Decision", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+
+		decision, err := plugin.(*Plugin).DecideRequest(req, "8.8.8.8")
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		expected := Decision{Allowed: false, IP: "8.8.8.8", Country: "US", Reason: reasonDefault, Policy: "path:/admin", StatusCode: http.StatusNotFound}
+		if decision != expected {
+			t.Errorf("expected decision %+v, but got: %+v", expected, decision)
+		}
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/ip2location/ip2location-go/v9"
 )
@@ -16,15 +17,63 @@ import (
 
 // Config defines the plugin configuration.
 type Config struct {
-	Enabled              bool     // Enable this plugin?
-	DatabaseFilePath     string   // Path to ip2location database file
-	AllowedCountries     []string // Whitelist of countries to allow (ISO 3166-1 alpha-2)
-	BlockedCountries     []string // Blocklist of countries to be blocked (ISO 3166-1 alpha-2)
-	DefaultAllow         bool     // If source matches neither blocklist nor whitelist, should it be allowed through?
-	AllowPrivate         bool     // Allow requests from private / internal networks?
-	DisallowedStatusCode int      // HTTP status code to return for disallowed requests
-	AllowedIPBlocks      []string // List of whitelist CIDR
-	BlockedIPBlocks      []string // List of blocklisted CIDRs
+	Enabled                 bool                        // Enable this plugin?
+	Mode                    string                      // "enforce" (default) blocks requests, "report" only logs and counts requests that would be blocked
+	AddWouldBlockHeader     bool                        // Add the X-Geoblock-Would-Block header to requests that would be blocked, but are passed on in report mode
+	EnforcePercent          *int                        // Percentage of clients to enforce decisions for, the rest run in report mode (default: 100)
+	DatabaseFilePath        string                      // Path to ip2location database file
+	AllowedCountries        []string                    // Whitelist of countries to allow (ISO 3166-1 codes or @groups)
+	BlockedCountries        []string                    // Blocklist of countries to be blocked (ISO 3166-1 codes or @groups)
+	DefaultAllow            bool                        // If source matches neither blocklist nor whitelist, should it be allowed through?
+	AllowPrivate            bool                        // Allow requests from private / internal networks?
+	DisallowedStatusCode    int                         // HTTP status code to return for disallowed requests
+	CountryStatusCodes      map[string]int              // Status codes overriding the above for requests from specific countries or @groups
+	BlockBody               *BlockBodyConfig            // Body of responses to disallowed requests (default: empty)
+	CountryBlockBodies      map[string]BlockBodyConfig  // Bodies overriding the above for requests from specific countries or @groups
+	BlockRedirect           *BlockRedirectConfig        // Redirect disallowed requests instead of responding with the above
+	NegotiateBlockBody      bool                        // Negotiate the body on the Accept header: problem+json, HTML or plain text
+	BlockBodyFormats        BlockBodyFormatsConfig      // Bodies overriding the built-in negotiated ones
+	RequestIDHeader         string                      // Header with the request ID available in block bodies (default: X-Request-Id)
+	AllowedIPBlocks         []string                    // List of whitelist CIDR
+	AllowedHostnames        []string                    // Whitelist of hostnames (e.g. dynamic DNS names), resolved periodically
+	HostnameRefreshInterval string                      // Interval in which the allowed hostnames are resolved (default: 5m)
+	BlockedIPBlocks         []string                    // List of blocklisted CIDRs
+	ASNDatabaseFilePath     string                      // Path to MaxMind GeoLite2-ASN database file
+	AllowedASNs             []string                    // Whitelist of autonomous system numbers (e.g. AS15169)
+	BlockedASNs             []string                    // Blocklist of autonomous system numbers
+	AllowedISPs             []string                    // Whitelist of ISP / AS organization name patterns (e.g. *telekom*)
+	BlockedISPs             []string                    // Blocklist of ISP / AS organization name patterns
+	AllowedUsageTypes       []string                    // Whitelist of usage types (e.g. MOB, ISP), requires ip2location DB24 or later
+	BlockedUsageTypes       []string                    // Blocklist of usage types (e.g. DCH, SES)
+	UnknownUsageType        string                      // Action for IPs with missing or unrecognized usage type: "ignore" (default), "allow" or "block"
+	ProxyDatabaseFilePath   string                      // Path to IP2Proxy database file (PX2 or later)
+	BlockedProxyTypes       []string                    // Blocklist of proxy types (e.g. VPN, TOR, PUB)
+	CountryGroups           map[string][]string         // Named groups of countries, to be referenced as @NAME in country lists
+	AllowedRegions          []string                    // Whitelist of regions (ISO 3166-2 codes or "CC:Region name"), requires ip2location DB3 or later
+	BlockedRegions          []string                    // Blocklist of regions (ISO 3166-2 codes or "CC:Region name")
+	Preset                  string                      // Built-in policy preset to enforce (e.g. sanctions)
+	PresetStatusCode        int                         // HTTP status code to return for requests blocked by the preset (default depends on preset)
+	Rules                   []RuleConfig                // Ordered list of rules, the first matching rule decides (replaces the allowed / blocked lists)
+	Paths                   []PathPolicyConfig          // Policies overriding the above for specific paths, the most specific matching path applies
+	Hosts                   map[string]HostPolicyConfig // Policies overriding the above for specific hosts (e.g. example.com, *.example.com or *)
+	HostsFilePath           string                      // Path to a JSON or YAML file with additional host policies, reloaded on change
+	HostsFileReloadInterval string                      // Interval in which the hosts file is checked for changes (default: 10s)
+	Methods                 []MethodPolicyConfig        // Policies overriding the above for specific HTTP methods (e.g. POST, PUT, PATCH, DELETE)
+	PreflightMode           string                      // How to handle CORS preflight requests: "evaluate" (default), "allow" or "requested-method"
+	BypassHeader            string                      // Header carrying keys to skip the geo check with (e.g. X-Geoblock-Bypass)
+	BypassKeys              []BypassKeyConfig           // Keys accepted in the bypass header, as SHA-256 hashes
+	BypassTokens            *BypassTokenConfig          // Signed, time-limited tokens to skip the geo check with, exchanged for a cookie
+	BypassClientCerts       []ClientCertConfig          // Client certificates presented via mutual TLS to skip the geo check with
+	VerifiedCrawlers        []string                    // Built-in crawlers to allow if verified by reverse and forward DNS (e.g. googlebot, bingbot)
+	CustomCrawlers          []CrawlerConfig             // Additional crawlers to allow if verified by reverse and forward DNS
+	DNSServer               string                      // Address of the DNS server (host:port) for reverse and forward lookups (default: system resolver)
+	DNSTimeout              string                      // Timeout of DNS lookups (default: 2s)
+	DNSCacheTTL             string                      // How long the hostnames of IP addresses are cached (default: 1h)
+	DNSCacheSize            int                         // Maximum number of IP addresses to cache the hostnames of (default: 10000)
+	AllowedReverseDNS       []string                    // Whitelist of forward-confirmed hostnames (e.g. example.com or *.example.com)
+	BlockedReverseDNS       []string                    // Blocklist of forward-confirmed hostnames (e.g. *.compute.amazonaws.com)
+	ReverseDNSFailureMode   string                      // What happens if hostnames can't be looked up: "open" (default, conditions don't match) or "closed" (conditions only match block rules)
+	ShadowPolicy            *ShadowPolicyConfig         // Candidate policy evaluated alongside the global policy, disagreements are logged but not enforced
 }
 
 // CreateConfig creates the default plugin configuration.
@@ -33,21 +82,40 @@ func CreateConfig() *Config {
 }
 
 type Plugin struct {
-	next                 http.Handler
-	name                 string
-	db                   *ip2location.DB
-	enabled              bool
-	allowedCountries     []string
-	blockedCountries     []string
-	defaultAllow         bool
-	allowPrivate         bool
-	disallowedStatusCode int
-	allowedIPBlocks      []*net.IPNet
-	blockedIPBlocks      []*net.IPNet
+	next                  http.Handler
+	name                  string
+	logf                  func(format string, args ...interface{})
+	db                    *ip2location.DB
+	enabled               bool
+	disallowedStatusCode  int
+	asnDB                 *mmdbReader
+	proxyDB               *ip2proxyReader
+	preset                *activePreset
+	policy                *policy
+	pathPolicies          []*pathPolicy
+	hosts                 *hostPolicies
+	methodPolicies        []*methodPolicy
+	preflightMode         string
+	bypass                *bypassKeys
+	tokens                *bypassTokens
+	bypassCerts           []clientCertMatcher
+	crawlers              []crawler
+	rdns                  *reverseDNS
+	mode                  string
+	addWouldBlockHeader   bool
+	rollout               *rollout
+	blockResponses        *blockResponses
+	redirect              *blockRedirect
+	countryStatusCodes    map[string]int
+	requestIDHeader       string
+	wouldBlock            *countryCounter
+	shadow                *policy
+	shadowDisagreements   *countryCounter
+	shadowDisagreementsBy *countryCounter
 }
 
 // New creates a new plugin instance.
-func New(_ context.Context, next http.Handler, cfg *Config, name string) (http.Handler, error) {
+func New(ctx context.Context, next http.Handler, cfg *Config, name string) (http.Handler, error) {
 	if next == nil {
 		return nil, fmt.Errorf("%s: no next handler provided", name)
 	}
@@ -79,28 +147,233 @@ func New(_ context.Context, next http.Handler, cfg *Config, name string) (http.H
 		return nil, fmt.Errorf("%s: failed to open database: %w", name, err)
 	}
 
-	allowedIPBlocks, err := initIPBlocks(cfg.AllowedIPBlocks)
+	countryGroups, err := initCountryGroups(cfg.CountryGroups)
 	if err != nil {
-		return nil, fmt.Errorf("%s: failed loading allowed CIDR blocks: %w", name, err)
+		return nil, fmt.Errorf("%s: failed loading country groups: %w", name, err)
 	}
 
-	blockedIPBlocks, err := initIPBlocks(cfg.BlockedIPBlocks)
+	logf := func(format string, args ...interface{}) {
+		log.Printf("%s: "+format, append([]interface{}{name}, args...)...)
+	}
+
+	globalPolicy, err := initPolicy(cfg, countryGroups, logf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	pathPolicies, err := initPathPolicies(cfg.Paths, cfg, countryGroups, logf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	shadowPolicy, err := initShadowPolicy(cfg.ShadowPolicy, cfg, countryGroups, logf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed loading shadow policy: %w", name, err)
+	}
+
+	var preset *activePreset
+	if cfg.Preset != "" {
+		preset, err = initPreset(cfg.Preset, cfg.PresetStatusCode)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed loading preset: %w", name, err)
+		}
+		log.Printf("%s: enforcing preset %s: %s", name, preset, preset.describe())
+	}
+
+	methodPolicies, err := initMethodPolicies(cfg.Methods, cfg, countryGroups, logf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	mode, err := initMode(cfg.Mode)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	blockResponses, err := initBlockResponses(cfg, countryGroups)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	countryStatusCodes, err := initCountryStatusCodes(cfg.CountryStatusCodes, countryGroups)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	requestIDHeader := defaultRequestIDHeader
+	if cfg.RequestIDHeader != "" {
+		requestIDHeader = cfg.RequestIDHeader
+	}
+
+	redirect, err := initBlockRedirect(cfg.BlockRedirect, countryGroups)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed loading block redirect: %w", name, err)
+	}
+
+	rollout, err := initRollout(cfg.EnforcePercent)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	preflightMode, err := initPreflightMode(cfg.PreflightMode)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	bypass, err := initBypassKeys(cfg.BypassHeader, cfg.BypassKeys, logf)
 	if err != nil {
-		return nil, fmt.Errorf("%s: failed loading allowed CIDR blocks: %w", name, err)
+		return nil, fmt.Errorf("%s: failed loading bypass keys: %w", name, err)
+	}
+
+	tokens, err := initBypassTokens(cfg.BypassTokens)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid bypass token configuration: %w", name, err)
+	}
+
+	bypassCerts, err := initClientCertMatchers(cfg.BypassClientCerts, true)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed loading bypass client certificates: %w", name, err)
+	}
+
+	crawlers, err := initCrawlers(cfg.VerifiedCrawlers, cfg.CustomCrawlers)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed loading crawlers: %w", name, err)
+	}
+
+	var hosts *hostPolicies
+	var hostsWatcher *hostsFileWatcher
+	if len(cfg.Hosts) > 0 || cfg.HostsFilePath != "" {
+		loadHosts := func() (map[string]*policy, error) {
+			hostCfgs := make(map[string]HostPolicyConfig, len(cfg.Hosts))
+			for host, hostCfg := range cfg.Hosts {
+				hostCfgs[host] = hostCfg
+			}
+
+			if cfg.HostsFilePath != "" {
+				fileHostCfgs, err := loadHostsFile(cfg.HostsFilePath)
+				if err != nil {
+					return nil, err
+				}
+				// NB: host policies of the file take precedence over those of the configuration.
+				for host, hostCfg := range fileHostCfgs {
+					hostCfgs[host] = hostCfg
+				}
+			}
+
+			return initHostPolicies(hostCfgs, cfg, countryGroups, logf)
+		}
+
+		var policies map[string]*policy
+		if cfg.HostsFilePath != "" {
+			hostsWatcher, policies, err = initHostsFileWatcher(cfg.HostsFilePath, cfg.HostsFileReloadInterval, loadHosts, logf)
+		} else {
+			policies, err = loadHosts()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed loading host policies: %w", name, err)
+		}
+
+		hosts = &hostPolicies{watcher: hostsWatcher}
+		hosts.set(policies)
+	}
+
+	rules := globalPolicy.rules
+	for _, pathPolicy := range pathPolicies {
+		rules = append(rules, pathPolicy.policy.rules...)
+	}
+	for _, methodPolicy := range methodPolicies {
+		rules = append(rules, methodPolicy.policy.rules...)
+	}
+	if shadowPolicy != nil {
+		rules = append(rules, shadowPolicy.rules...)
+	}
+	requirements := requirementsOf(rules)
+
+	// NB: hostnames are resolved once upfront, so requests from their addresses are allowed right away.
+	resolvedHostnames := resolvedHostnamesOf(rules)
+	refreshAll(ctx, resolvedHostnames, logf)
+
+	var rdns *reverseDNS
+	if len(crawlers) > 0 || requirements.reverseDNS {
+		rdns, err = initReverseDNS(cfg, logf)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	var asnDB *mmdbReader
+	if cfg.ASNDatabaseFilePath != "" {
+		asnDB, err = openMMDB(cfg.ASNDatabaseFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to open ASN database: %w", name, err)
+		}
+	} else if requirements.asn {
+		return nil, fmt.Errorf("%s: ASN rules require an ASN database file path", name)
+	} else if requirements.isp {
+		record, err := db.Get_isp("8.8.8.8")
+		if err != nil || strings.HasPrefix(record.Isp, ip2locationNotSupported) {
+			return nil, fmt.Errorf("%s: ISP rules require an ASN database or an ip2location database with ISP information", name)
+		}
+	}
+
+	if requirements.usageType {
+		record, err := db.Get_usagetype("8.8.8.8")
+		if err != nil || strings.HasPrefix(record.Usagetype, ip2locationNotSupported) {
+			return nil, fmt.Errorf("%s: usage type rules require an ip2location database with usage type information (DB24 or later)", name)
+		}
+	}
+
+	if requirements.region || (preset != nil && len(preset.regions) > 0) {
+		record, err := db.Get_region("8.8.8.8")
+		if err != nil || strings.HasPrefix(record.Region, ip2locationNotSupported) {
+			return nil, fmt.Errorf("%s: region rules require an ip2location database with region information (DB3 or later)", name)
+		}
+	}
+
+	var proxyDB *ip2proxyReader
+	if cfg.ProxyDatabaseFilePath != "" {
+		proxyDB, err = openIP2Proxy(cfg.ProxyDatabaseFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to open proxy database: %w", name, err)
+		}
+		if !proxyDB.hasProxyType() {
+			return nil, fmt.Errorf("%s: proxy database does not contain proxy types, PX2 or later is required", name)
+		}
+	} else if requirements.proxyType {
+		return nil, fmt.Errorf("%s: proxy type rules require a proxy database file path", name)
 	}
 
 	return &Plugin{
-		next:                 next,
-		name:                 name,
-		db:                   db,
-		enabled:              cfg.Enabled,
-		allowedCountries:     cfg.AllowedCountries,
-		blockedCountries:     cfg.BlockedCountries,
-		defaultAllow:         cfg.DefaultAllow,
-		allowPrivate:         cfg.AllowPrivate,
-		disallowedStatusCode: cfg.DisallowedStatusCode,
-		allowedIPBlocks:      allowedIPBlocks,
-		blockedIPBlocks:      blockedIPBlocks,
+		next:                  next,
+		name:                  name,
+		logf:                  logf,
+		db:                    db,
+		enabled:               cfg.Enabled,
+		disallowedStatusCode:  cfg.DisallowedStatusCode,
+		asnDB:                 asnDB,
+		proxyDB:               proxyDB,
+		preset:                preset,
+		policy:                globalPolicy,
+		pathPolicies:          pathPolicies,
+		hosts:                 hosts,
+		methodPolicies:        methodPolicies,
+		preflightMode:         preflightMode,
+		bypass:                bypass,
+		tokens:                tokens,
+		bypassCerts:           bypassCerts,
+		crawlers:              crawlers,
+		rdns:                  rdns,
+		mode:                  mode,
+		addWouldBlockHeader:   cfg.AddWouldBlockHeader,
+		rollout:               rollout,
+		blockResponses:        blockResponses,
+		redirect:              redirect,
+		countryStatusCodes:    countryStatusCodes,
+		requestIDHeader:       requestIDHeader,
+		wouldBlock:            newCountryCounter(),
+		shadow:                shadowPolicy,
+		shadowDisagreements:   newCountryCounter(),
+		shadowDisagreementsBy: newCountryCounter(),
 	}, nil
 }
 
@@ -111,20 +384,72 @@ func (p Plugin) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if p.mode == modeReport || p.rollout != nil {
+		// NB: the header must not be spoofed by clients.
+		req.Header.Del(wouldBlockHeader)
+	}
+
 	for _, ip := range p.GetRemoteIPs(req) {
-		allowed, country, err := p.CheckAllowed(ip)
+		decision, shadow, err := p.decideRequest(req, ip)
+		// NB: presets are always enforced, even in report mode or for clients a rollout doesn't enforce yet.
+		report := (p.mode == modeReport || !p.rollout.enforced(ip)) && !strings.HasPrefix(decision.Reason, reasonPresetPrefix)
+		if shadow != nil && shadow.Allowed != decision.Allowed {
+			p.shadowDisagreements.add(decision.Country)
+			p.shadowDisagreementsBy.add(decision.decidedBy())
+			log.Printf("%s: [%s %s %s] shadow policy disagrees with %s: %s, shadow: %s (disagreements so far: %s, by decider: %s)",
+				p.name, req.Host, req.Method, req.URL.Path, decision.decidedBy(), decision, shadow, p.shadowDisagreements, p.shadowDisagreementsBy)
+		}
 		if err != nil {
 			log.Printf("%s: [%s %s %s] - %v", p.name, req.Host, req.Method, req.URL.Path, err)
-			rw.WriteHeader(p.disallowedStatusCode)
+			if report {
+				continue
+			}
+			p.writeBlocked(rw, req, Decision{IP: ip}, p.disallowedStatusCode)
 			return
 		}
-		if !allowed {
-			log.Printf("%s: [%s %s %s] blocked request from %s", p.name, req.Host, req.Method, req.URL.Path, country)
-			rw.WriteHeader(p.disallowedStatusCode)
+		if !decision.Allowed && report {
+			total := p.wouldBlock.add(decision.Country)
+			log.Printf("%s: [%s %s %s] would block request from %s (%s), %d requests would have been blocked so far",
+				p.name, req.Host, req.Method, req.URL.Path, decision.Country, decision, total)
+			if p.addWouldBlockHeader {
+				req.Header.Add(wouldBlockHeader, decision.String())
+			}
+			continue
+		}
+		if !decision.Allowed {
+			var target string
+			if redirect := p.redirectFor(decision); redirect != nil {
+				var self bool
+				target, self, err = redirect.resolve(req, decision.Country)
+				if err != nil {
+					log.Printf("%s: [%s %s %s] not redirecting blocked request: %v", p.name, req.Host, req.Method, req.URL.Path, err)
+				}
+				if self {
+					// NB: the redirect target is never blocked, so blocked requests can't be redirected in a loop.
+					log.Printf("%s: [%s %s %s] passing on blocked request from %s (%s), reason=%s",
+						p.name, req.Host, req.Method, req.URL.Path, decision.Country, decision, reasonRedirectTarget)
+					continue
+				}
+			}
+
+			log.Printf("%s: [%s %s %s] blocked request from %s (%s)", p.name, req.Host, req.Method, req.URL.Path, decision.Country, decision)
+			if target != "" {
+				http.Redirect(rw, req, target, p.redirectStatusCode())
+				return
+			}
+			p.writeBlocked(rw, req, decision, p.blockStatusCode(decision))
 			return
 		}
 	}
 
+	// NB: bypass keys and tokens are secrets, they must not be passed on to the next handler.
+	if p.bypass != nil {
+		req.Header.Del(p.bypass.header)
+	}
+	if p.tokens != nil {
+		p.tokens.exchange(rw, req, time.Now())
+	}
+
 	p.next.ServeHTTP(rw, req)
 }
 
@@ -161,91 +486,166 @@ func (p Plugin) GetRemoteIPs(req *http.Request) []string {
 
 // CheckAllowed checks whether a given IP address is allowed according to the configured allowed countries.
 func (p Plugin) CheckAllowed(ip string) (allow bool, country string, err error) {
-	var allowedCountry, allowedIP, blockedCountry, blockedIP bool
-	var allowedNetworkLength, blockedNetworkLength int
-
-	country, err = p.Lookup(ip)
+	decision, err := p.Decide(ip)
 	if err != nil {
-		return false, ip, fmt.Errorf("lookup of %s failed: %w", ip, err)
+		return false, ip, err
 	}
 
-	if country == "-" {
-		return p.allowPrivate, country, nil
-	}
+	return decision.Allowed, decision.Country, nil
+}
 
-	if country != "-" {
-		for _, item := range p.blockedCountries {
-			if item == country {
-				blockedCountry = true
+// Decide checks whether a given IP address is allowed according to the configured rules,
+// and describes which kind of rule the decision is based on.
+func (p Plugin) Decide(ip string) (Decision, error) {
+	return p.DecideRequest(nil, ip)
+}
 
-				break
-			}
-		}
+// DecideRequest checks whether a request from the given IP address is allowed according to the configured
+// rules. The request may be nil, in which case rules depending on it (e.g. on headers or paths) don't match.
+func (p Plugin) DecideRequest(req *http.Request, ip string) (Decision, error) {
+	decision, _, err := p.decideRequest(req, ip)
+	return decision, err
+}
 
-		for _, item := range p.allowedCountries {
-			if item == country {
-				allowedCountry = true
-			}
-		}
+// decideRequest is like DecideRequest, but also returns the decision of the shadow policy, if one is configured.
+// The shadow policy is evaluated for every request, whatever the real decision is based on.
+func (p Plugin) decideRequest(req *http.Request, ip string) (Decision, *Decision, error) {
+	decision, ctx, err := p.decideEnforced(req, ip)
+	if err != nil || p.shadow == nil {
+		return decision, nil, err
 	}
 
-	blocked, blockedNetworkLength, err := p.isBlockedIPBlocks(ip)
+	// NB: the shadow policy must never affect the real decision, so its errors are only logged.
+	shadow, err := p.decideShadow(ctx)
 	if err != nil {
-		return false, ip, fmt.Errorf("failed to check if IP %q is blocked by IP block: %w", ip, err)
+		log.Printf("%s: failed to evaluate shadow policy: %v", p.name, err)
+		return decision, nil, nil
 	}
 
-	if blocked {
-		blockedIP = true
-	}
+	return decision, &shadow, nil
+}
 
-	for _, allowedCountry := range p.allowedCountries {
-		if allowedCountry == country {
-			return true, ip, nil
+// decideShadow evaluates the shadow policy in the context of a request that has already been decided.
+func (p Plugin) decideShadow(ctx *evalContext) (Decision, error) {
+	base := *ctx.decision
+	shadowDecision := Decision{IP: base.IP, Country: base.Country, Region: base.Region, ProxyType: base.ProxyType}
+
+	// NB: decisions made before the policy is evaluated, e.g. for bypasses, don't look up the proxy type.
+	if p.proxyDB != nil && shadowDecision.Country != "-" && shadowDecision.ProxyType == "" {
+		proxyType, err := p.LookupProxyType(shadowDecision.IP)
+		if err != nil {
+			return Decision{}, fmt.Errorf("proxy lookup of %s failed: %w", shadowDecision.IP, err)
 		}
+		shadowDecision.ProxyType = proxyType
 	}
 
-	allowed, allowedNetBits, err := p.isAllowedIPBlocks(ip)
+	ctx.decision = &shadowDecision
+
+	return p.shadow.decide(ctx)
+}
+
+// decideEnforced decides a request from the given IP address like DecideRequest, and returns the context it was
+// evaluated in, so further policies can reuse its lookups.
+func (p Plugin) decideEnforced(req *http.Request, ip string) (Decision, *evalContext, error) {
+	country, err := p.Lookup(ip)
 	if err != nil {
-		return false, ip, fmt.Errorf("failed to check if IP %q is allowed by IP block: %w", ip, err)
+		return Decision{}, nil, fmt.Errorf("lookup of %s failed: %w", ip, err)
 	}
 
-	if allowed {
-		allowedIP = true
-		allowedNetworkLength = allowedNetBits
+	ipAddress := net.ParseIP(ip)
+	if ipAddress == nil {
+		return Decision{}, nil, fmt.Errorf("unable parse IP address from address [%s]", ip)
 	}
 
-	// Handle final values
-	//
-	// NB: discrete IPs have higher priority than countries:  more specific to less specific.
+	decision := Decision{IP: ip, Country: country}
+	ctx := &evalContext{p: &p, req: req, ip: ipAddress, now: time.Now(), decision: &decision}
+	if req != nil {
+		ctx.method = req.Method
+		ctx.path = cleanPath(req.URL.Path)
+	}
 
-	// NB: whichever matched prefix is longer has higher priority: more specific to less specific.
-	if allowedNetworkLength < blockedNetworkLength {
-		if blockedIP {
-			return false, country, nil
+	// NB: presets take precedence over all other rules, they can't be overridden by configuration.
+	if p.preset != nil && country != "-" {
+		var region string
+		if len(p.preset.regions) > 0 {
+			region, err = ctx.region()
+			if err != nil {
+				return Decision{}, nil, err
+			}
 		}
 
-		if allowedIP {
-			return true, country, nil
+		if p.preset.matches(country, region) {
+			decision = decision.with(false, reasonPresetPrefix+p.preset.String())
+			decision.StatusCode = p.preset.statusCode
+
+			return decision, ctx, nil
 		}
-	} else {
-		if allowedIP {
-			return true, country, nil
+	}
+
+	if id, ok := p.bypass.match(req, ctx.now); ok {
+		return decision.with(true, reasonBypassPrefix+id), ctx, nil
+	}
+	if id, ok := p.tokens.match(req, ctx.now); ok {
+		return decision.with(true, reasonBypassTokenPrefix+id), ctx, nil
+	}
+	if m, ok := matchClientCert(p.bypassCerts, req); ok {
+		return decision.with(true, reasonBypassCertPrefix+m.id), ctx, nil
+	}
+
+	// NB: crawlers are only verified if the User-Agent header claims the request is from one.
+	if req != nil && country != "-" {
+		if claimed := claimedCrawlers(p.crawlers, req.UserAgent()); len(claimed) > 0 {
+			// NB: failed lookups are logged once by the reverse DNS lookup, the request is evaluated as usual then.
+			hostnames, _ := ctx.hostnameList()
+			for _, c := range claimed {
+				if c.verifiedBy(hostnames) {
+					return decision.with(true, reasonCrawlerPrefix+c.name), ctx, nil
+				}
+			}
 		}
+	}
 
-		if blockedIP {
-			return false, country, nil
+	if p.proxyDB != nil && country != "-" {
+		decision.ProxyType, err = p.LookupProxyType(ip)
+		if err != nil {
+			return Decision{}, nil, fmt.Errorf("proxy lookup of %s failed: %w", ip, err)
 		}
 	}
 
-	if allowedCountry {
-		return true, country, nil
+	if req != nil && isPreflightRequest(req) {
+		switch p.preflightMode {
+		case preflightModeAllow:
+			return decision.with(true, reasonPreflight), ctx, nil
+		case preflightModeRequestedMethod:
+			ctx.method = strings.ToUpper(req.Header.Get("Access-Control-Request-Method"))
+		}
 	}
 
-	if blockedCountry {
-		return false, country, nil
+	// NB: path policies take precedence over host policies, followed by the global policy. Method policies add to
+	// whichever of them is selected, so requests must be allowed by both.
+	pol := p.policy
+	var methodPol *policy
+	if req != nil {
+		p.hosts.reloadIfDue(ctx.now)
+		if pathPolicy := selectPathPolicy(p.pathPolicies, ctx.path); pathPolicy != nil {
+			pol = pathPolicy.policy
+		} else if hostPolicy := p.hosts.get(req.Host); hostPolicy != nil {
+			pol = hostPolicy
+		}
+		if methodPolicy := selectMethodPolicy(p.methodPolicies, ctx.method); methodPolicy != nil {
+			methodPol = methodPolicy.policy
+		}
 	}
 
-	return p.defaultAllow, country, nil
+	result, err := pol.decide(ctx)
+	if err == nil && result.Allowed && methodPol != nil {
+		result, err = methodPol.decide(ctx)
+	}
+	if err != nil {
+		return Decision{}, nil, err
+	}
+
+	return result, ctx, nil
 }
 
 // Lookup queries the ip2location database for a given IP address.
@@ -278,32 +678,3 @@ func initIPBlocks(ipBlocks []string) ([]*net.IPNet, error) {
 
 	return ipBlocksNet, nil
 }
-
-// isAllowedIPBlocks checks if an IP is allowed base on the allowed CIDR blocks
-func (p Plugin) isAllowedIPBlocks(ip string) (bool, int, error) {
-	return p.isInIPBlocks(ip, p.allowedIPBlocks)
-}
-
-// isBlockedIPBlocks checks if an IP is allowed base on the blocked CIDR blocks
-func (p Plugin) isBlockedIPBlocks(ip string) (bool, int, error) {
-	return p.isInIPBlocks(ip, p.blockedIPBlocks)
-}
-
-// isInIPBlocks indicates whether the given IP exists in any of the IP subnets contained within ipBlocks.
-func (p Plugin) isInIPBlocks(ip string, ipBlocks []*net.IPNet) (bool, int, error) {
-	ipAddress := net.ParseIP(ip)
-
-	if ipAddress == nil {
-		return false, 0, fmt.Errorf("unable parse IP address from address [%s]", ip)
-	}
-
-	for _, block := range ipBlocks {
-		if block.Contains(ipAddress) {
-			ones, _ := block.Mask.Size()
-
-			return true, ones, nil
-		}
-	}
-
-	return false, 0, nil
-}
@@ -203,6 +203,20 @@ func TestPlugin_ServeHTTP(t *testing.T) {
 
 		testRequest(t, "Default allow false", cfg, "8.8.4.4", http.StatusForbidden)
 	})
+
+	t.Run("Precedence", func(t *testing.T) {
+		cfg := &Config{
+			Enabled:              true,
+			DatabaseFilePath:     dbFilePath,
+			AllowedCountries:     []string{"US"},
+			BlockedIPBlocks:      []string{"8.8.8.0/24"},
+			DisallowedStatusCode: http.StatusForbidden,
+		}
+
+		testRequest(t, "Allowed country trumps IP CIDR block", cfg, "8.8.8.8", http.StatusTeapot)
+		testRequest(t, "Allowed country outside of IP CIDR block", cfg, "8.8.4.4", http.StatusTeapot)
+
+	})
 }
 
 func testRequest(t *testing.T, testName string, cfg *Config, ip string, expectedStatus int) {
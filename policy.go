@@ -0,0 +1,102 @@
+package traefik_plugin_geoblock
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// policy is a compiled, ordered rule list, with the outcome for requests no rule matches.
+type policy struct {
+	name         string // Name of the policy, included in decisions, empty for the global policy
+	rules        []rule
+	defaultAllow bool
+	statusCode   int // HTTP status code for blocked requests, 0 for the configured default
+}
+
+// initPolicy compiles the rules or, if none are configured, the allowed / blocked lists of a configuration.
+func initPolicy(cfg *Config, countryGroups map[string][]string, logf func(format string, args ...interface{})) (*policy, error) {
+	pol := &policy{defaultAllow: cfg.DefaultAllow}
+
+	if len(cfg.Rules) == 0 {
+		rules, err := listRules(cfg, countryGroups, logf)
+		if err != nil {
+			return nil, err
+		}
+		pol.rules = rules
+
+		return pol, nil
+	}
+
+	if hasListRules(cfg) {
+		return nil, errors.New("rules can't be combined with allowed / blocked lists, translate them into rules instead")
+	}
+
+	rules, err := initRules(cfg.Rules, countryGroups)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading rules: %w", err)
+	}
+
+	// NB: without allowPrivate, private networks are blocked unless a rule explicitly allows them.
+	privateRule := rule{allow: cfg.AllowPrivate, reason: reasonPrivate, conditions: []condition{privateCondition{}}}
+	if cfg.AllowPrivate {
+		pol.rules = append([]rule{privateRule}, rules...)
+	} else {
+		pol.rules = append(rules, privateRule)
+	}
+
+	return pol, nil
+}
+
+// initOverridePolicy compiles a policy overriding the global one for some requests, e.g. those to specific paths.
+// The status code overrides the configured default if not zero.
+func initOverridePolicy(name string, cfg *Config, statusCode int, countryGroups map[string][]string,
+	logf func(format string, args ...interface{}),
+) (*policy, error) {
+	if statusCode != 0 && http.StatusText(statusCode) == "" {
+		return nil, fmt.Errorf("%d is not a valid http status code", statusCode)
+	}
+
+	pol, err := initPolicy(cfg, countryGroups, logf)
+	if err != nil {
+		return nil, err
+	}
+	pol.name = name
+	pol.statusCode = statusCode
+
+	return pol, nil
+}
+
+// decide evaluates the rules in order, the first matching rule decides.
+func (pol *policy) decide(ctx *evalContext) (Decision, error) {
+	ctx.decision.Policy = pol.name
+
+	for _, r := range pol.rules {
+		matched, err := r.matches(ctx)
+		if err != nil {
+			return Decision{}, err
+		}
+		if matched {
+			decision := pol.outcome(*ctx.decision, r.allow, r.reason)
+			if !r.allow && r.action != nil {
+				decision.action = r.action
+				if r.action.statusCode != 0 {
+					decision.StatusCode = r.action.statusCode
+				}
+			}
+			return decision, nil
+		}
+	}
+
+	return pol.outcome(*ctx.decision, pol.defaultAllow, reasonDefault), nil
+}
+
+// outcome returns a copy of the decision with the given outcome and reason, and the policy's status code.
+func (pol *policy) outcome(decision Decision, allowed bool, reason string) Decision {
+	decision = decision.with(allowed, reason)
+	if !allowed {
+		decision.StatusCode = pol.statusCode
+	}
+
+	return decision
+}
@@ -0,0 +1,97 @@
+package traefik_plugin_geoblock
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// policyPreset is a built-in, versioned set of countries and regions to block.
+type policyPreset struct {
+	version    string   // Version of the preset, to be updated whenever its contents change
+	countries  []string // Countries to block entirely
+	regions    []string // Regions to block, see initRegions
+	statusCode int      // Default HTTP status code for requests blocked by the preset
+}
+
+// policyPresets are the built-in presets, by name.
+var policyPresets = map[string]policyPreset{
+	// Comprehensively embargoed countries and regions according to OFAC and EU sanctions.
+	// This list is provided on a best effort basis and does not constitute legal advice.
+	"sanctions": {
+		version:    "2026.1",
+		countries:  []string{"CU", "IR", "KP", "SY"},
+		regions:    []string{"UA-09", "UA-14", "UA-40", "UA-43"},
+		statusCode: http.StatusUnavailableForLegalReasons,
+	},
+}
+
+// activePreset is a preset enabled by the configuration.
+type activePreset struct {
+	name       string
+	version    string
+	countries  map[string]struct{}
+	regions    regionSet
+	statusCode int
+}
+
+// initPreset loads the preset with the given name. The status code overrides the preset's default if not zero.
+func initPreset(name string, statusCode int) (*activePreset, error) {
+	preset, ok := policyPresets[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		var names []string
+		for presetName := range policyPresets {
+			names = append(names, presetName)
+		}
+		sort.Strings(names)
+
+		return nil, fmt.Errorf("unknown preset %q, must be one of: %s", name, strings.Join(names, ", "))
+	}
+
+	if statusCode == 0 {
+		statusCode = preset.statusCode
+	} else if http.StatusText(statusCode) == "" {
+		return nil, fmt.Errorf("%d is not a valid http status code", statusCode)
+	}
+
+	regions, err := initRegions(preset.regions)
+	if err != nil {
+		return nil, err
+	}
+
+	countries := make(map[string]struct{}, len(preset.countries))
+	for _, country := range preset.countries {
+		countries[country] = struct{}{}
+	}
+
+	return &activePreset{
+		name:       strings.ToLower(strings.TrimSpace(name)),
+		version:    preset.version,
+		countries:  countries,
+		regions:    regions,
+		statusCode: statusCode,
+	}, nil
+}
+
+// String returns the name and version of the preset, e.g. "sanctions@2026.1".
+func (p *activePreset) String() string {
+	return p.name + "@" + p.version
+}
+
+// describe returns a human-readable summary of the preset's contents, for logging.
+func (p *activePreset) describe() string {
+	preset := policyPresets[p.name]
+
+	return fmt.Sprintf("countries %s; regions %s; status code %d",
+		strings.Join(preset.countries, ", "), strings.Join(preset.regions, ", "), p.statusCode)
+}
+
+// matches indicates whether the given country and region are blocked by the preset.
+func (p *activePreset) matches(country, region string) bool {
+	if _, ok := p.countries[country]; ok {
+		return true
+	}
+
+	return region != "" && p.regions.contains(country, region)
+}
@@ -0,0 +1,132 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// registerTestPreset registers a preset blocking RU for the duration of the test and returns its name, as the DB1
+// database used for tests does not contain regions, which the built-in presets depend on.
+func registerTestPreset(t *testing.T) string {
+	t.Helper()
+
+	const name = "test"
+	policyPresets[name] = policyPreset{
+		version:    "test",
+		countries:  []string{"RU"},
+		statusCode: http.StatusUnavailableForLegalReasons,
+	}
+	t.Cleanup(func() { delete(policyPresets, name) })
+
+	return name
+}
+
+func TestInitPreset(t *testing.T) {
+	t.Run("Sanctions", func(t *testing.T) {
+		preset, err := initPreset("Sanctions", 0)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if preset.String() != "sanctions@"+policyPresets["sanctions"].version {
+			t.Errorf("unexpected preset name: %s", preset)
+		}
+		if preset.statusCode != http.StatusUnavailableForLegalReasons {
+			t.Errorf("expected status code %d, but got: %d", http.StatusUnavailableForLegalReasons, preset.statusCode)
+		}
+
+		for _, test := range []struct {
+			country, region string
+			expected        bool
+		}{
+			{"KP", "", true},
+			{"IR", "Tehran", true},
+			{"UA", "Avtonomna Respublika Krym", true},
+			{"RU", "Sevastopol", true},
+			{"UA", "Donetska oblast", true},
+			{"UA", "Kyyiv", false},
+			{"UA", "", false},
+			{"RU", "Moskva", false},
+			{"DE", "", false},
+		} {
+			if actual := preset.matches(test.country, test.region); actual != test.expected {
+				t.Errorf("expected %s/%q to match: %t, but got: %t", test.country, test.region, test.expected, actual)
+			}
+		}
+	})
+
+	t.Run("StatusCode", func(t *testing.T) {
+		preset, err := initPreset("sanctions", http.StatusForbidden)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if preset.statusCode != http.StatusForbidden {
+			t.Errorf("expected status code %d, but got: %d", http.StatusForbidden, preset.statusCode)
+		}
+
+		if _, err = initPreset("sanctions", -1); err == nil {
+			t.Error("expected error, but got none")
+		}
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		if _, err := initPreset("foo", 0); err == nil {
+			t.Error("expected error, but got none")
+		}
+	})
+}
+
+func TestNew_PresetUnsupportedDatabase(t *testing.T) {
+	cfg := &Config{
+		Enabled:              true,
+		DatabaseFilePath:     dbFilePath,
+		Preset:               "sanctions",
+		DisallowedStatusCode: http.StatusForbidden,
+	}
+
+	// The DB1 database used for tests does not contain regions, so regional embargoes can't be enforced.
+	plugin, err := New(context.TODO(), &noopHandler{}, cfg, pluginName)
+	if err == nil {
+		t.Errorf("expected error, but got none")
+	}
+	if plugin != nil {
+		t.Error("expected plugin to be nil, but is not")
+	}
+}
+
+func TestPlugin_ServeHTTP_PresetAlwaysEnforced(t *testing.T) {
+	for name, cfg := range map[string]*Config{
+		"Report": {Mode: modeReport},
+		// NB: neither 77.88.8.8 nor 185.5.82.105 are in the first bucket.
+		"PartialRollout": {EnforcePercent: percentOf(1)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg.Enabled = true
+			cfg.DatabaseFilePath = dbFilePath
+			cfg.AllowedCountries = []string{"US"}
+			cfg.DisallowedStatusCode = http.StatusForbidden
+			cfg.Preset = registerTestPreset(t)
+
+			plugin, err := New(context.TODO(), &noopHandler{}, cfg, pluginName)
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+
+			for ip, expected := range map[string]int{
+				"77.88.8.8":    http.StatusUnavailableForLegalReasons,
+				"185.5.82.105": http.StatusTeapot,
+			} {
+				req := httptest.NewRequest(http.MethodGet, "/foobar", nil)
+				req.Header.Set("X-Real-IP", ip)
+
+				rr := httptest.NewRecorder()
+				plugin.ServeHTTP(rr, req)
+
+				if rr.Code != expected {
+					t.Errorf("expected status code %d for %s, but got: %d", expected, ip, rr.Code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package traefik_plugin_geoblock
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// knownProxyTypes are the proxy types reported by IP2Proxy PX2 and later.
+var knownProxyTypes = map[string]string{
+	"VPN": "Anonymizing VPN service",
+	"TOR": "Tor exit node",
+	"DCH": "Hosting provider, data center or content delivery network",
+	"PUB": "Public proxy",
+	"WEB": "Web proxy",
+	"SES": "Search engine robot",
+	"RES": "Residential proxy",
+	"CPN": "Consumer privacy network",
+	"EPN": "Enterprise private network",
+}
+
+// initProxyTypes normalizes and validates a list of proxy types.
+func initProxyTypes(proxyTypes []string) (map[string]struct{}, error) {
+	proxyTypeSet := make(map[string]struct{}, len(proxyTypes))
+
+	for _, proxyType := range proxyTypes {
+		normalized := strings.ToUpper(strings.TrimSpace(proxyType))
+		if _, ok := knownProxyTypes[normalized]; !ok {
+			return nil, fmt.Errorf("%q is not a known proxy type", proxyType)
+		}
+		proxyTypeSet[normalized] = struct{}{}
+	}
+
+	return proxyTypeSet, nil
+}
+
+// LookupProxyType queries the IP2Proxy database for the proxy type of a given IP address.
+// "-" is returned for addresses that are not known to be proxies.
+func (p Plugin) LookupProxyType(ip string) (string, error) {
+	ipAddress := net.ParseIP(ip)
+	if ipAddress == nil {
+		return "", fmt.Errorf("unable parse IP address from address [%s]", ip)
+	}
+
+	record, err := p.proxyDB.lookup(ipAddress)
+	if err != nil {
+		return "", err
+	}
+
+	return record.ProxyType, nil
+}
@@ -0,0 +1,80 @@
+package traefik_plugin_geoblock
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Failure modes for reverse DNS conditions, deciding what happens when hostnames can't be looked up.
+const (
+	reverseDNSFailureModeOpen   = "open"
+	reverseDNSFailureModeClosed = "closed"
+)
+
+// errHostnamesUnavailable is returned by reverse DNS conditions in the closed failure mode if hostnames can't be
+// looked up. Rules treat such conditions as matching if they block requests, and as not matching if they allow them.
+var errHostnamesUnavailable = errors.New("hostnames unavailable")
+
+// initReverseDNSFailureMode validates the failure mode of reverse DNS conditions.
+func initReverseDNSFailureMode(mode string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", reverseDNSFailureModeOpen:
+		return reverseDNSFailureModeOpen, nil
+	case reverseDNSFailureModeClosed:
+		return reverseDNSFailureModeClosed, nil
+	default:
+		return "", fmt.Errorf("%q is not a valid reverse DNS failure mode, must be %q or %q",
+			mode, reverseDNSFailureModeOpen, reverseDNSFailureModeClosed)
+	}
+}
+
+// initReverseDNSPatterns normalizes and validates a list of hostname patterns. A pattern is either a domain,
+// matching the domain and its subdomains, or a wildcard pattern like *.compute.amazonaws.com, matching subdomains only.
+func initReverseDNSPatterns(patterns []string) ([]string, error) {
+	var normalized []string
+
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(pattern)), ".")
+		domain := strings.TrimPrefix(pattern, "*.")
+		if domain == "" || strings.ContainsAny(domain, "*/: ") || strings.Contains(domain, "..") || strings.HasPrefix(domain, ".") {
+			return nil, fmt.Errorf("invalid hostname pattern %q, must be a domain or a pattern like *.example.com", pattern)
+		}
+		normalized = append(normalized, pattern)
+	}
+
+	return normalized, nil
+}
+
+// matchesReverseDNSPattern indicates whether the hostname matches the pattern.
+func matchesReverseDNSPattern(hostname, pattern string) bool {
+	if domain := strings.TrimPrefix(pattern, "*."); domain != pattern {
+		return strings.HasSuffix(hostname, "."+domain)
+	}
+
+	return matchesDomain(hostname, pattern)
+}
+
+// reverseDNSCondition matches the forward-confirmed hostnames of the IP address against a list of patterns.
+type reverseDNSCondition []string
+
+func (c reverseDNSCondition) matches(ctx *evalContext) (bool, error) {
+	hostnames, err := ctx.hostnameList()
+	if err != nil {
+		// NB: failed lookups are logged once by the reverse DNS lookup, not for every condition or request.
+		if ctx.p.rdns.failureMode == reverseDNSFailureModeClosed {
+			return false, fmt.Errorf("%w: %v", errHostnamesUnavailable, err)
+		}
+		return false, nil
+	}
+
+	for _, hostname := range hostnames {
+		for _, pattern := range c {
+			if matchesReverseDNSPattern(hostname, pattern) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
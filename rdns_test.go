@@ -0,0 +1,217 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInitReverseDNSPatterns(t *testing.T) {
+	patterns, err := initReverseDNSPatterns([]string{" Example.COM. ", "*.compute.amazonaws.com"})
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if len(patterns) != 2 || patterns[0] != "example.com" || patterns[1] != "*.compute.amazonaws.com" {
+		t.Errorf("expected normalized patterns, but got: %v", patterns)
+	}
+
+	for _, pattern := range []string{"", "*.", "*.*.example.com", "example.*", "https://example.com", ".example.com"} {
+		if _, err := initReverseDNSPatterns([]string{pattern}); err == nil {
+			t.Errorf("expected error for %q, but got none", pattern)
+		}
+	}
+}
+
+func TestMatchesReverseDNSPattern(t *testing.T) {
+	for _, test := range []struct {
+		hostname, pattern string
+		expected          bool
+	}{
+		{"example.com", "example.com", true},
+		{"vpn.example.com", "example.com", true},
+		{"badexample.com", "example.com", false},
+		{"ec2-1-2-3-4.compute.amazonaws.com", "*.compute.amazonaws.com", true},
+		{"compute.amazonaws.com", "*.compute.amazonaws.com", false},
+		{"ec2-1-2-3-4.eu-central-1.compute.amazonaws.com", "*.compute.amazonaws.com", true},
+	} {
+		if actual := matchesReverseDNSPattern(test.hostname, test.pattern); actual != test.expected {
+			t.Errorf("expected %t for %s and %s, but got: %t", test.expected, test.hostname, test.pattern, actual)
+		}
+	}
+}
+
+func TestPlugin_ServeHTTP_ReverseDNS(t *testing.T) {
+	server := startTestDNSServer(t, map[string][]string{
+		"8.8.8.8":                            {"ec2-8-8-8-8.compute.amazonaws.com."},
+		"ec2-8-8-8-8.compute.amazonaws.com.": {"8.8.8.8"},
+		"77.88.8.8":                          {"vpn.corp.example.com."},
+		"vpn.corp.example.com.":              {"77.88.8.8"},
+		"1.1.1.1":                            {"spoofed.corp.example.com."},
+		"spoofed.corp.example.com.":          {"1.0.0.1"},
+	})
+
+	newPlugin := func(t *testing.T, cfg *Config) http.Handler {
+		cfg.Enabled = true
+		cfg.DatabaseFilePath = dbFilePath
+		cfg.DNSServer = server.addr
+		cfg.DisallowedStatusCode = http.StatusForbidden
+
+		plugin, err := New(context.TODO(), &noopHandler{}, cfg, pluginName)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		return plugin
+	}
+
+	request := func(plugin http.Handler, ip string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-IP", ip)
+
+		rr := httptest.NewRecorder()
+		plugin.ServeHTTP(rr, req)
+
+		return rr.Code
+	}
+
+	t.Run("Lists", func(t *testing.T) {
+		plugin := newPlugin(t, &Config{
+			AllowedCountries:  []string{"US"},
+			AllowedReverseDNS: []string{"corp.example.com"},
+			BlockedReverseDNS: []string{"*.compute.amazonaws.com"},
+		})
+
+		for _, test := range []struct {
+			name, ip       string
+			expectedStatus int
+		}{
+			{"BlockedHostnameInAllowedCountry", "8.8.8.8", http.StatusForbidden},
+			{"AllowedHostnameInOtherCountry", "77.88.8.8", http.StatusTeapot},
+			{"NoHostname", "185.5.82.105", http.StatusForbidden},
+		} {
+			if status := request(plugin, test.ip); status != test.expectedStatus {
+				t.Errorf("%s: expected status code %d, but got: %d", test.name, test.expectedStatus, status)
+			}
+		}
+
+		decision, err := plugin.(*Plugin).Decide("8.8.8.8")
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if decision.Allowed || decision.Reason != reasonReverseDNS {
+			t.Errorf("expected request to be blocked by reverse DNS, but got: %+v", decision)
+		}
+	})
+
+	t.Run("RulesAndExpressions", func(t *testing.T) {
+		plugin := newPlugin(t, &Config{
+			Rules: []RuleConfig{
+				{Name: "cloud", Action: "block", ReverseDNS: []string{"*.compute.amazonaws.com"}},
+				{Name: "corp", Action: "allow", Expression: `rdns("corp.example.com") && country == "RU"`},
+				{Action: "allow", Countries: []string{"US"}},
+			},
+		})
+
+		for ip, expectedStatus := range map[string]int{
+			"8.8.8.8":   http.StatusForbidden,
+			"77.88.8.8": http.StatusTeapot,
+			"1.1.1.1":   http.StatusTeapot,
+		} {
+			if status := request(plugin, ip); status != expectedStatus {
+				t.Errorf("%s: expected status code %d, but got: %d", ip, expectedStatus, status)
+			}
+		}
+	})
+
+	t.Run("FailureModes", func(t *testing.T) {
+		server.setDelay(200 * time.Millisecond)
+		t.Cleanup(func() { server.setDelay(0) })
+
+		for mode, expectedStatus := range map[string]int{
+			"":       http.StatusTeapot,
+			"open":   http.StatusTeapot,
+			"closed": http.StatusForbidden,
+		} {
+			plugin := newPlugin(t, &Config{
+				AllowedCountries:      []string{"US"},
+				BlockedReverseDNS:     []string{"*.compute.amazonaws.com"},
+				DNSTimeout:            "20ms",
+				ReverseDNSFailureMode: mode,
+			})
+
+			if status := request(plugin, "8.8.8.8"); status != expectedStatus {
+				t.Errorf("%q: expected status code %d, but got: %d", mode, expectedStatus, status)
+			}
+		}
+	})
+
+	t.Run("ClosedFailureModeRules", func(t *testing.T) {
+		server.setDelay(200 * time.Millisecond)
+		t.Cleanup(func() { server.setDelay(0) })
+
+		for name, test := range map[string]struct {
+			cfg            *Config
+			expectedStatus int
+		}{
+			"AllowListNotMatching": {&Config{
+				AllowedCountries:  []string{"US"},
+				AllowedReverseDNS: []string{"corp.example.com"},
+			}, http.StatusTeapot},
+			"AllowRuleNotMatching": {&Config{Rules: []RuleConfig{
+				{Action: "allow", Expression: `rdns("corp.example.com")`},
+				{Action: "allow", Countries: []string{"US"}},
+			}}, http.StatusTeapot},
+			"BlockRuleMatching": {&Config{Rules: []RuleConfig{
+				{Action: "block", ReverseDNS: []string{"*.compute.amazonaws.com"}},
+				{Action: "allow", Countries: []string{"US"}},
+			}}, http.StatusForbidden},
+			"BlockRuleOtherCondition": {&Config{Rules: []RuleConfig{
+				{Action: "block", ReverseDNS: []string{"*.compute.amazonaws.com"}, Countries: []string{"RU"}},
+				{Action: "allow", Countries: []string{"US"}},
+			}}, http.StatusTeapot},
+			"AllowExpressionOtherOperandDecides": {&Config{Rules: []RuleConfig{
+				{Action: "allow", Expression: `rdns("corp.example.com") || country == "US"`},
+				{Action: "block"},
+			}}, http.StatusTeapot},
+			"AllowExpressionDependsOnHostnames": {&Config{Rules: []RuleConfig{
+				{Action: "allow", Expression: `rdns("corp.example.com") || country == "RU"`},
+				{Action: "block"},
+			}}, http.StatusForbidden},
+			"BlockExpressionOtherOperandDecides": {&Config{Rules: []RuleConfig{
+				{Action: "block", Expression: `rdns("*.compute.amazonaws.com") && country == "RU"`},
+				{Action: "allow", Countries: []string{"US"}},
+			}}, http.StatusTeapot},
+			"BlockExpressionDependsOnHostnames": {&Config{Rules: []RuleConfig{
+				{Action: "block", Expression: `rdns("*.compute.amazonaws.com") && country == "US"`},
+				{Action: "allow", Countries: []string{"US"}},
+			}}, http.StatusForbidden},
+		} {
+			test.cfg.DNSTimeout = "20ms"
+			test.cfg.ReverseDNSFailureMode = reverseDNSFailureModeClosed
+			plugin := newPlugin(t, test.cfg)
+
+			if status := request(plugin, "8.8.8.8"); status != test.expectedStatus {
+				t.Errorf("%s: expected status code %d, but got: %d", name, test.expectedStatus, status)
+			}
+		}
+	})
+}
+
+func TestNew_ReverseDNSErrors(t *testing.T) {
+	for name, cfg := range map[string]*Config{
+		"InvalidPattern":     {BlockedReverseDNS: []string{"*.*.example.com"}},
+		"InvalidExpression":  {Rules: []RuleConfig{{Action: "allow", Expression: `rdns("*")`}}},
+		"InvalidFailureMode": {BlockedReverseDNS: []string{"example.com"}, ReverseDNSFailureMode: "ajar"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg.Enabled = true
+			cfg.DatabaseFilePath = dbFilePath
+			cfg.DisallowedStatusCode = http.StatusForbidden
+
+			if _, err := New(context.TODO(), &noopHandler{}, cfg, pluginName); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package traefik_plugin_geoblock
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// reasonRedirectTarget is logged for blocked requests that are passed on because they are at their redirect target.
+const reasonRedirectTarget = "redirect-target"
+
+// BlockRedirectConfig defines a redirect for blocked requests, replacing the block response.
+type BlockRedirectConfig struct {
+	URL        string            // URL template of the redirect target (e.g. https://{{lower .Country}}.example.com{{.Path}})
+	StatusCode int               // HTTP status code of the redirect: 302 (default), 307 or 308
+	Countries  map[string]string // URL templates overriding the above for requests from specific countries or @groups
+}
+
+// redirectData holds the variables available in templates of redirect targets.
+//
+// NB: the Host header is deliberately not available, as clients control it, which would make redirects to it open
+// redirects on routers without a Host rule.
+type redirectData struct {
+	Country string // ISO 3166-1 alpha-2 country code, "-" for private networks
+	Path    string // Escaped path of the original request
+	Query   string // Raw query of the original request, without the leading ?
+}
+
+// redirectFuncs are the functions available in templates of redirect targets.
+var redirectFuncs = map[string]interface{}{
+	"lower": strings.ToLower,
+}
+
+// redirectTarget is a compiled redirect target.
+type redirectTarget struct {
+	template      *template.Template
+	dependsOnPath bool // Whether the target includes the path or query of the original request
+}
+
+// blockRedirect redirects blocked requests.
+type blockRedirect struct {
+	statusCode int
+	target     *redirectTarget
+	countries  map[string]*redirectTarget
+}
+
+// initBlockRedirect compiles and validates the redirect of blocked requests. If none is configured, nil is returned.
+func initBlockRedirect(cfg *BlockRedirectConfig, countryGroups map[string][]string) (*blockRedirect, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.URL == "" && len(cfg.Countries) == 0 {
+		return nil, errors.New("no redirect URL configured")
+	}
+
+	r := &blockRedirect{statusCode: http.StatusFound}
+	switch cfg.StatusCode {
+	case 0:
+	case http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		r.statusCode = cfg.StatusCode
+	default:
+		return nil, fmt.Errorf("%d is not a valid redirect status code, must be 302, 307 or 308", cfg.StatusCode)
+	}
+
+	if cfg.URL != "" {
+		target, err := initRedirectTarget(cfg.URL)
+		if err != nil {
+			return nil, err
+		}
+		r.target = target
+	}
+
+	if len(cfg.Countries) > 0 {
+		targets := make(map[string]*redirectTarget, len(cfg.Countries))
+		keys := make([]string, 0, len(cfg.Countries))
+		for key, targetURL := range cfg.Countries {
+			target, err := initRedirectTarget(targetURL)
+			if err != nil {
+				return nil, fmt.Errorf("redirect for %q: %w", key, err)
+			}
+			targets[key] = target
+			keys = append(keys, key)
+		}
+
+		byCountry, err := expandCountryKeys(keys, countryGroups)
+		if err != nil {
+			return nil, fmt.Errorf("redirects: %w", err)
+		}
+		r.countries = make(map[string]*redirectTarget, len(byCountry))
+		for country, key := range byCountry {
+			r.countries[country] = targets[key]
+		}
+	}
+
+	return r, nil
+}
+
+// initRedirectTarget parses the URL template of a redirect target and validates it by rendering it with sample data.
+// Targets on the same host must not include the original path, as they would redirect in a loop.
+func initRedirectTarget(text string) (*redirectTarget, error) {
+	tmpl, err := template.New("redirect").Funcs(redirectFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect URL template: %w", err)
+	}
+	target := &redirectTarget{template: tmpl}
+
+	sample := redirectData{Country: "DE", Path: "/sample", Query: "sample=1"}
+	first, err := target.render(sample)
+	if err != nil {
+		return nil, err
+	}
+
+	sample.Path, sample.Query = "/other", "other=1"
+	second, err := target.render(sample)
+	if err != nil {
+		return nil, err
+	}
+
+	target.dependsOnPath = first.String() != second.String()
+	if target.dependsOnPath && first.Host == "" {
+		return nil, fmt.Errorf("relative redirect URL %q can't include the original path or query", text)
+	}
+
+	return target, nil
+}
+
+// render renders the redirect target. It must be an absolute http(s) URL or an absolute path.
+func (t *redirectTarget) render(data redirectData) (*url.URL, error) {
+	var buf bytes.Buffer
+	if err := t.template.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("invalid redirect URL template: %w", err)
+	}
+
+	target, err := url.Parse(buf.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect URL %q: %w", buf.String(), err)
+	}
+	if target.IsAbs() {
+		if (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return nil, fmt.Errorf("invalid redirect URL %q, must be an http(s) URL or an absolute path", buf.String())
+		}
+	} else if !strings.HasPrefix(target.Path, "/") || target.Host != "" {
+		return nil, fmt.Errorf("invalid redirect URL %q, must be an http(s) URL or an absolute path", buf.String())
+	}
+
+	return target, nil
+}
+
+// redirectFor returns the redirect target for a blocked request, if any. Settings of the rule the decision is based on
+// take precedence over those of countries, followed by the default target. A status code or body for the rule or country
+// replaces the default target, just like a status code of the preset or of the policy the decision is based on.
+func (p Plugin) redirectFor(decision Decision) *redirectTarget {
+	if decision.action != nil {
+		return decision.action.redirect
+	}
+	if p.redirect == nil || decision.StatusCode != 0 {
+		return nil
+	}
+	if target, ok := p.redirect.countries[decision.Country]; ok {
+		return target
+	}
+	if _, ok := p.countryStatusCodes[decision.Country]; ok {
+		return nil
+	}
+	if p.blockResponses != nil {
+		if _, ok := p.blockResponses.countries[decision.Country]; ok {
+			return nil
+		}
+	}
+
+	return p.redirect.target
+}
+
+// redirectStatusCode returns the status code of redirects.
+func (p Plugin) redirectStatusCode() int {
+	if p.redirect == nil {
+		return http.StatusFound
+	}
+
+	return p.redirect.statusCode
+}
+
+// resolve renders the redirect target for a blocked request from the given country. If the request is already at its
+// target, self is set, so the target itself is never blocked. Targets on the same host including the original path
+// result in an error, as they would redirect in a loop.
+func (t *redirectTarget) resolve(req *http.Request, country string) (target string, self bool, err error) {
+	u, err := t.render(redirectData{Country: country, Path: req.URL.EscapedPath(), Query: req.URL.RawQuery})
+	if err != nil {
+		return "", false, err
+	}
+
+	if u.Host == "" || sameHost(u.Host, req.Host) {
+		// NB: targets including the original path would always be at the request itself.
+		if t.dependsOnPath {
+			return "", false, fmt.Errorf("redirect target %s is on the same host and includes the original path", u)
+		}
+		if u.EscapedPath() == req.URL.EscapedPath() {
+			return "", true, nil
+		}
+	}
+
+	return u.String(), false, nil
+}
+
+// sameHost indicates whether both hosts are equal, ignoring ports and case.
+func sameHost(a, b string) bool {
+	if host, _, err := net.SplitHostPort(a); err == nil {
+		a = host
+	}
+	if host, _, err := net.SplitHostPort(b); err == nil {
+		b = host
+	}
+
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
@@ -0,0 +1,152 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInitBlockRedirect_Errors(t *testing.T) {
+	for name, cfg := range map[string]*BlockRedirectConfig{
+		"NoURL":           {},
+		"StatusCode":      {URL: "https://example.com", StatusCode: http.StatusMovedPermanently},
+		"SyntaxError":     {URL: "https://example.com/{{.Country"},
+		"UnknownVariable": {URL: "https://example.com/{{.City}}"},
+		"HostVariable":    {URL: "https://{{.Host}}/blocked"},
+		"Scheme":          {URL: "ftp://example.com/"},
+		"RelativePath":    {URL: "blocked.html"},
+		"RelativeLoop":    {URL: "/{{lower .Country}}{{.Path}}"},
+		"InvalidCountry":  {Countries: map[string]string{"XX": "https://example.com"}},
+		"DuplicateCountry": {Countries: map[string]string{
+			"DE": "https://example.de", "DEU": "https://example.de",
+		}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := initBlockRedirect(cfg, nil); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestRedirectTarget_Resolve(t *testing.T) {
+	r, err := initBlockRedirect(&BlockRedirectConfig{
+		URL: "/not-available",
+		Countries: map[string]string{
+			"DE": "https://{{lower .Country}}.example.com{{.Path}}?{{.Query}}",
+			"AT": "https://example.com{{.Path}}",
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	for name, test := range map[string]struct {
+		url     string
+		country string
+		target  string
+		self    bool
+		err     bool
+	}{
+		"Default":         {"https://example.com/shop", "RU", "/not-available", false, false},
+		"Country":         {"https://example.com/shop/a%2Fb?x=1", "DE", "https://de.example.com/shop/a%2Fb?x=1", false, false},
+		"Self":            {"https://example.com/not-available", "RU", "", true, false},
+		"SameHostAndPath": {"https://example.com:443/shop", "AT", "", false, true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, test.url, nil)
+
+			redirect, ok := r.countries[test.country]
+			if !ok {
+				redirect = r.target
+			}
+
+			target, self, err := redirect.resolve(req, test.country)
+			if (err != nil) != test.err {
+				t.Errorf("expected error to be %t, but got: %v", test.err, err)
+			}
+			if target != test.target {
+				t.Errorf("expected target %q, but got: %q", test.target, target)
+			}
+			if self != test.self {
+				t.Errorf("expected self to be %t, but got: %t", test.self, self)
+			}
+		})
+	}
+}
+
+func TestPlugin_ServeHTTP_BlockRedirect(t *testing.T) {
+	plugin, err := New(context.TODO(), &noopHandler{}, &Config{
+		Enabled:              true,
+		DatabaseFilePath:     dbFilePath,
+		AllowedCountries:     []string{"US"},
+		DisallowedStatusCode: http.StatusForbidden,
+		BlockRedirect: &BlockRedirectConfig{
+			URL:        "/not-available",
+			StatusCode: http.StatusTemporaryRedirect,
+			Countries:  map[string]string{"DE": "https://example.de{{.Path}}"},
+		},
+		Paths: []PathPolicyConfig{{Path: "/admin", AllowedCountries: []string{"US"}, DisallowedStatusCode: http.StatusNotFound}},
+	}, pluginName)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	for name, test := range map[string]struct {
+		ip       string
+		path     string
+		code     int
+		location string
+	}{
+		"Default": {"77.88.8.8", "/shop", http.StatusTemporaryRedirect, "/not-available"},
+		"Country": {"185.5.82.105", "/shop", http.StatusTemporaryRedirect, "https://example.de/shop"},
+		"Target":  {"77.88.8.8", "/not-available", http.StatusTeapot, ""},
+		"Allowed": {"8.8.8.8", "/shop", http.StatusTeapot, ""},
+		// NB: status codes of policies take precedence over the default redirect, and over those of countries.
+		"PolicyStatusCode":        {"77.88.8.8", "/admin", http.StatusNotFound, ""},
+		"PolicyStatusCodeCountry": {"185.5.82.105", "/admin", http.StatusNotFound, ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			req.Header.Set("X-Real-IP", test.ip)
+
+			rr := httptest.NewRecorder()
+			plugin.ServeHTTP(rr, req)
+
+			if rr.Code != test.code {
+				t.Errorf("expected status code %d, but got: %d", test.code, rr.Code)
+			}
+			if location := rr.Header().Get("Location"); location != test.location {
+				t.Errorf("expected location %q, but got: %q", test.location, location)
+			}
+		})
+	}
+}
+
+func TestPlugin_ServeHTTP_BlockRedirectPreset(t *testing.T) {
+	plugin, err := New(context.TODO(), &noopHandler{}, &Config{
+		Enabled:              true,
+		DatabaseFilePath:     dbFilePath,
+		AllowedCountries:     []string{"US"},
+		DisallowedStatusCode: http.StatusForbidden,
+		BlockRedirect:        &BlockRedirectConfig{URL: "/not-available"},
+		Preset:               registerTestPreset(t),
+	}, pluginName)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/shop", nil)
+	req.Header.Set("X-Real-IP", "77.88.8.8")
+
+	rr := httptest.NewRecorder()
+	plugin.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnavailableForLegalReasons {
+		t.Errorf("expected status code %d, but got: %d", http.StatusUnavailableForLegalReasons, rr.Code)
+	}
+	if location := rr.Header().Get("Location"); location != "" {
+		t.Errorf("expected no location, but got: %q", location)
+	}
+}
@@ -0,0 +1,136 @@
+package traefik_plugin_geoblock
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// regionSeparator separates the country from the region name in a region rule, e.g. "UA:Luhanska oblast".
+const regionSeparator = ":"
+
+// subdivisionCodePattern matches ISO 3166-2 subdivision codes, e.g. "UA-43".
+var subdivisionCodePattern = regexp.MustCompile(`^[A-Z]{2}-[A-Z0-9]{1,3}$`)
+
+// subdivision describes how an ISO 3166-2 subdivision appears in geolocation databases.
+type subdivision struct {
+	countries []string // Countries the subdivision may be attributed to
+	names     []string // Names the subdivision may be reported as
+}
+
+// knownSubdivisions are the ISO 3166-2 subdivisions that may be referenced by code in region rules.
+// Geolocation databases report regions by name, and the names of these differ between databases.
+// Occupied regions are attributed to the occupying country by some databases, so both countries are matched.
+var knownSubdivisions = map[string]subdivision{
+	"UA-09": {
+		countries: []string{"UA", "RU"},
+		names:     []string{"Luhanska oblast", "Luhans'ka Oblast'", "Luhansk Oblast", "Luhansk", "Lugansk", "Luganskaya Oblast", "Luhansk People's Republic"},
+	},
+	"UA-14": {
+		countries: []string{"UA", "RU"},
+		names:     []string{"Donetska oblast", "Donets'ka Oblast'", "Donetsk Oblast", "Donetsk", "Donetskaya Oblast", "Donetsk People's Republic"},
+	},
+	"UA-23": {
+		countries: []string{"UA", "RU"},
+		names:     []string{"Zaporizka oblast", "Zaporiz'ka Oblast'", "Zaporizhzhia Oblast", "Zaporizhzhia", "Zaporozhye", "Zaporozhskaya Oblast"},
+	},
+	"UA-40": {
+		countries: []string{"UA", "RU"},
+		names:     []string{"Sevastopol", "Misto Sevastopol", "Sevastopol City", "Gorod Sevastopol"},
+	},
+	"UA-43": {
+		countries: []string{"UA", "RU"},
+		names:     []string{"Avtonomna Respublika Krym", "Krym", "Crimea", "Autonomous Republic of Crimea", "Republic of Crimea", "Respublika Krym"},
+	},
+	"UA-65": {
+		countries: []string{"UA", "RU"},
+		names:     []string{"Khersonska oblast", "Khersons'ka Oblast'", "Kherson Oblast", "Kherson", "Khersonskaya Oblast"},
+	},
+}
+
+// regionSet holds normalized region names by country.
+type regionSet map[string]map[string]struct{}
+
+// initRegions parses a list of region rules. Each rule is either a known ISO 3166-2 subdivision code
+// (e.g. "UA-43"), or a country and region name as reported by the database (e.g. "UA:Luhanska oblast").
+func initRegions(regions []string) (regionSet, error) {
+	set := make(regionSet)
+
+	for _, region := range regions {
+		trimmed := strings.TrimSpace(region)
+
+		if country, name, ok := strings.Cut(trimmed, regionSeparator); ok {
+			normalizedCountry, err := normalizeCountry(country)
+			if err != nil {
+				return nil, fmt.Errorf("invalid region %q: %w", region, err)
+			}
+			if strings.TrimSpace(name) == "" {
+				return nil, fmt.Errorf("invalid region %q: no region name", region)
+			}
+			set.add(normalizedCountry, name)
+			continue
+		}
+
+		code := strings.ToUpper(trimmed)
+		if !subdivisionCodePattern.MatchString(code) {
+			return nil, fmt.Errorf("invalid region %q, expected an ISO 3166-2 code or %q", region, "CC"+regionSeparator+"Region name")
+		}
+
+		sub, ok := knownSubdivisions[code]
+		if !ok {
+			return nil, fmt.Errorf("unknown ISO 3166-2 subdivision %q, use %q instead", region, code[:2]+regionSeparator+"Region name")
+		}
+		for _, country := range sub.countries {
+			for _, name := range sub.names {
+				set.add(country, name)
+			}
+		}
+	}
+
+	return set, nil
+}
+
+// add adds the given region name of a country to the set.
+func (s regionSet) add(country, name string) {
+	if s[country] == nil {
+		s[country] = make(map[string]struct{})
+	}
+	s[country][normalizeRegionName(name)] = struct{}{}
+}
+
+// contains indicates whether the given region of a country is contained in the set.
+func (s regionSet) contains(country, region string) bool {
+	names, ok := s[country]
+	if !ok {
+		return false
+	}
+
+	_, ok = names[normalizeRegionName(region)]
+	return ok
+}
+
+// normalizeRegionName normalizes a region name for comparison, ignoring case, apostrophes and extra whitespace.
+func normalizeRegionName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.NewReplacer("'", "", "’", "", "ʼ", "", "ʹ", "", "`", "").Replace(name)
+
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// LookupRegion queries the ip2location database for the region of a given IP address.
+func (p Plugin) LookupRegion(ip string) (string, error) {
+	record, err := p.db.Get_region(ip)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(strings.ToLower(record.Region), "invalid") {
+		return "", errors.New(record.Region)
+	}
+	if strings.HasPrefix(record.Region, ip2locationNotSupported) || record.Region == "-" {
+		return "", nil
+	}
+
+	return record.Region, nil
+}
@@ -0,0 +1,147 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestInitRegions(t *testing.T) {
+	regions, err := initRegions([]string{"ua-43", "DE:Bayern", "AUT: Wien "})
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	for _, test := range []struct {
+		country, region string
+		expected        bool
+	}{
+		{"UA", "Avtonomna Respublika Krym", true},
+		{"UA", "Crimea", true},
+		{"RU", "Respublika Krym", true},
+		{"UA", "Kyyiv", false},
+		{"DE", "bayern", true},
+		{"DE", "Berlin", false},
+		{"AT", "Wien", true},
+		{"AT", "Bayern", false},
+		{"UA", "", false},
+	} {
+		if actual := regions.contains(test.country, test.region); actual != test.expected {
+			t.Errorf("expected %s/%q to be contained: %t, but got: %t", test.country, test.region, test.expected, actual)
+		}
+	}
+
+	for _, invalid := range []string{"UA-99", "Bayern", "XX:Bayern", "DE:", "DE-BY"} {
+		if _, err = initRegions([]string{invalid}); err == nil {
+			t.Errorf("expected error for %q, but got none", invalid)
+		}
+	}
+}
+
+func TestNormalizeRegionName(t *testing.T) {
+	for input, expected := range map[string]string{
+		"Luhans'ka Oblast'":  "luhanska oblast",
+		"Donets’ka  Oblast’": "donetska oblast",
+		" Sevastopol ":       "sevastopol",
+	} {
+		if actual := normalizeRegionName(input); actual != expected {
+			t.Errorf("expected %q to be normalized to %q, but got: %q", input, expected, actual)
+		}
+	}
+}
+
+func TestRegionCondition(t *testing.T) {
+	for name, test := range map[string]struct {
+		country, region string
+		expectedAllow   bool
+		expectedReason  string
+	}{
+		"Allowed":             {"DE", "Bayern", true, reasonRegion},
+		"AllowedOtherCountry": {"AT", "Bayern", true, reasonDefault},
+		"Blocked":             {"UA", "Crimea", false, reasonRegion},
+		"BlockedOtherCountry": {"PL", "Crimea", true, reasonDefault},
+		"BlockedOccupier":     {"RU", "Respublika Krym", false, reasonRegion},
+		"Other":               {"DE", "Berlin", true, reasonDefault},
+		"Unknown":             {"DE", "", true, reasonDefault},
+	} {
+		t.Run(name, func(t *testing.T) {
+			pol, err := initPolicy(&Config{
+				AllowedRegions: []string{"DE:Bayern"},
+				BlockedRegions: []string{"UA-43"},
+				DefaultAllow:   true,
+			}, nil, t.Logf)
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+
+			ctx := &evalContext{decision: &Decision{IP: "8.8.8.8", Country: test.country, Region: test.region}, regionLoaded: true}
+			decision, err := pol.decide(ctx)
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if decision.Allowed != test.expectedAllow || decision.Reason != test.expectedReason {
+				t.Errorf("expected allowed %t by %q, but got: %t by %q", test.expectedAllow, test.expectedReason, decision.Allowed, decision.Reason)
+			}
+		})
+	}
+
+	t.Run("UnknownBlockedByDefault", func(t *testing.T) {
+		regions, _ := initRegions([]string{"DE:Bayern"})
+		pol := &policy{rules: []rule{{allow: true, reason: reasonRegion, conditions: []condition{regionCondition(regions)}}}}
+
+		decision, err := pol.decide(&evalContext{decision: &Decision{IP: "8.8.8.8", Country: "DE"}, regionLoaded: true})
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if decision.Allowed || decision.Reason != reasonDefault {
+			t.Errorf("expected an unknown region to be blocked by default, but got: %t by %q", decision.Allowed, decision.Reason)
+		}
+	})
+}
+
+func TestEvalContext_Region(t *testing.T) {
+	cfg := &Config{
+		Enabled:              true,
+		DatabaseFilePath:     dbFilePath,
+		DisallowedStatusCode: http.StatusForbidden,
+	}
+
+	plugin, err := New(context.TODO(), &noopHandler{}, cfg, pluginName)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	p := plugin.(*Plugin)
+
+	t.Run("Lookup", func(t *testing.T) {
+		// The DB1 database used for tests does not contain regions.
+		ctx := &evalContext{p: p, decision: &Decision{IP: "8.8.8.8", Country: "US"}}
+		region, err := ctx.region()
+		if err != nil {
+			t.Errorf("expected no error, but got: %v", err)
+		}
+		if region != "" || !ctx.regionLoaded {
+			t.Errorf("expected an unknown region to be loaded, but got: %q (loaded: %t)", region, ctx.regionLoaded)
+		}
+	})
+
+	t.Run("Loaded", func(t *testing.T) {
+		ctx := &evalContext{p: p, decision: &Decision{IP: "8.8.8.8", Country: "US", Region: "California"}, regionLoaded: true}
+		region, err := ctx.region()
+		if err != nil {
+			t.Errorf("expected no error, but got: %v", err)
+		}
+		if region != "California" {
+			t.Errorf("expected the loaded region, but got: %q", region)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		ctx := &evalContext{p: p, decision: &Decision{IP: "foobar"}}
+		if _, err := ctx.region(); err == nil {
+			t.Error("expected error, but got none")
+		}
+		if ctx.regionLoaded {
+			t.Error("expected the region not to be loaded after a failed lookup")
+		}
+	})
+}
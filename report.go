@@ -0,0 +1,97 @@
+package traefik_plugin_geoblock
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Modes of the plugin.
+const (
+	modeEnforce = "enforce"
+	modeReport  = "report"
+)
+
+// wouldBlockHeader is added to requests passed on in report mode that would have been blocked.
+const wouldBlockHeader = "X-Geoblock-Would-Block"
+
+// initMode validates the mode of the plugin.
+func initMode(mode string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", modeEnforce:
+		return modeEnforce, nil
+	case modeReport:
+		return modeReport, nil
+	default:
+		return "", fmt.Errorf("%q is not a valid mode, must be %q or %q", mode, modeEnforce, modeReport)
+	}
+}
+
+// countryCounter counts events by country, e.g. requests that would have been blocked, or by any other key.
+type countryCounter struct {
+	mu        sync.Mutex
+	total     uint64
+	byCountry map[string]uint64
+}
+
+// newCountryCounter creates an empty counter.
+func newCountryCounter() *countryCounter {
+	return &countryCounter{byCountry: make(map[string]uint64)}
+}
+
+// add counts an event for the country and returns the total number of events.
+func (c *countryCounter) add(country string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.total++
+	c.byCountry[country]++
+
+	return c.total
+}
+
+// counts returns the number of events by country.
+func (c *countryCounter) counts() map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := make(map[string]uint64, len(c.byCountry))
+	for country, count := range c.byCountry {
+		counts[country] = count
+	}
+
+	return counts
+}
+
+// String returns the counts by country, most frequent first, e.g. "RU=12 CN=3".
+func (c *countryCounter) String() string {
+	counts := c.counts()
+
+	countries := make([]string, 0, len(counts))
+	for country := range counts {
+		countries = append(countries, country)
+	}
+	sort.Slice(countries, func(i, j int) bool {
+		if counts[countries[i]] != counts[countries[j]] {
+			return counts[countries[i]] > counts[countries[j]]
+		}
+		return countries[i] < countries[j]
+	})
+
+	formatted := make([]string, 0, len(countries))
+	for _, country := range countries {
+		formatted = append(formatted, fmt.Sprintf("%s=%d", country, counts[country]))
+	}
+
+	return strings.Join(formatted, " ")
+}
+
+// WouldBlockCounts returns the number of requests that would have been blocked in report mode, by country.
+func (p Plugin) WouldBlockCounts() map[string]uint64 {
+	if p.wouldBlock == nil {
+		return map[string]uint64{}
+	}
+
+	return p.wouldBlock.counts()
+}
@@ -0,0 +1,154 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInitMode(t *testing.T) {
+	for input, expected := range map[string]string{
+		"":        modeEnforce,
+		"enforce": modeEnforce,
+		"Report":  modeReport,
+	} {
+		t.Run(input, func(t *testing.T) {
+			mode, err := initMode(input)
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if mode != expected {
+				t.Errorf("expected mode %q, but got: %q", expected, mode)
+			}
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		if _, err := initMode("dry-run"); err == nil {
+			t.Error("expected error, but got none")
+		}
+	})
+}
+
+func TestCountryCounter(t *testing.T) {
+	counter := newCountryCounter()
+	counter.add("RU")
+	counter.add("CN")
+	if total := counter.add("RU"); total != 3 {
+		t.Errorf("expected total of 3, but got: %d", total)
+	}
+
+	counts := counter.counts()
+	if counts["RU"] != 2 || counts["CN"] != 1 || len(counts) != 2 {
+		t.Errorf("expected RU=2 and CN=1, but got: %v", counts)
+	}
+
+	counts["RU"] = 0
+	if counter.counts()["RU"] != 2 {
+		t.Error("expected counts to be a copy, but got the internal map")
+	}
+}
+
+type headerRecordingHandler struct {
+	header http.Header
+}
+
+func (h *headerRecordingHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	h.header = req.Header.Clone()
+	rw.WriteHeader(http.StatusTeapot)
+}
+
+func TestPlugin_ServeHTTP_Report(t *testing.T) {
+	newPlugin := func(t *testing.T, addHeader bool) (*Plugin, *headerRecordingHandler) {
+		next := &headerRecordingHandler{}
+		plugin, err := New(context.TODO(), next, &Config{
+			Enabled:              true,
+			DatabaseFilePath:     dbFilePath,
+			AllowedCountries:     []string{"US"},
+			Mode:                 "report",
+			AddWouldBlockHeader:  addHeader,
+			DisallowedStatusCode: http.StatusForbidden,
+		}, pluginName)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+
+		return plugin.(*Plugin), next
+	}
+
+	t.Run("WouldBlock", func(t *testing.T) {
+		plugin, next := newPlugin(t, true)
+
+		req := httptest.NewRequest(http.MethodGet, "/foobar", nil)
+		req.Header.Set("X-Real-IP", "77.88.8.8")
+
+		rr := httptest.NewRecorder()
+		plugin.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusTeapot {
+			t.Errorf("expected status code %d, but got: %d", http.StatusTeapot, rr.Code)
+		}
+		if header := next.header.Get(wouldBlockHeader); header != "blocked ip=77.88.8.8 country=RU reason=default" {
+			t.Errorf("expected header %q, but got: %q", "blocked ip=77.88.8.8 country=RU reason=default", header)
+		}
+		if counts := plugin.WouldBlockCounts(); counts["RU"] != 1 || len(counts) != 1 {
+			t.Errorf("expected RU=1, but got: %v", counts)
+		}
+	})
+
+	t.Run("Allowed", func(t *testing.T) {
+		plugin, next := newPlugin(t, true)
+
+		req := httptest.NewRequest(http.MethodGet, "/foobar", nil)
+		req.Header.Set("X-Real-IP", "8.8.8.8")
+		req.Header.Set(wouldBlockHeader, "spoofed")
+
+		rr := httptest.NewRecorder()
+		plugin.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusTeapot {
+			t.Errorf("expected status code %d, but got: %d", http.StatusTeapot, rr.Code)
+		}
+		if header := next.header.Get(wouldBlockHeader); header != "" {
+			t.Errorf("expected no header, but got: %q", header)
+		}
+		if counts := plugin.WouldBlockCounts(); len(counts) != 0 {
+			t.Errorf("expected no counts, but got: %v", counts)
+		}
+	})
+
+	t.Run("WithoutHeader", func(t *testing.T) {
+		plugin, next := newPlugin(t, false)
+
+		req := httptest.NewRequest(http.MethodGet, "/foobar", nil)
+		req.Header.Set("X-Real-IP", "77.88.8.8")
+
+		rr := httptest.NewRecorder()
+		plugin.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusTeapot {
+			t.Errorf("expected status code %d, but got: %d", http.StatusTeapot, rr.Code)
+		}
+		if header := next.header.Get(wouldBlockHeader); header != "" {
+			t.Errorf("expected no header, but got: %q", header)
+		}
+		if counts := plugin.WouldBlockCounts(); counts["RU"] != 1 {
+			t.Errorf("expected RU=1, but got: %v", counts)
+		}
+	})
+
+	t.Run("InvalidIP", func(t *testing.T) {
+		plugin, _ := newPlugin(t, false)
+
+		req := httptest.NewRequest(http.MethodGet, "/foobar", nil)
+		req.Header.Set("X-Real-IP", "not-an-ip")
+
+		rr := httptest.NewRecorder()
+		plugin.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusTeapot {
+			t.Errorf("expected status code %d, but got: %d", http.StatusTeapot, rr.Code)
+		}
+	})
+}
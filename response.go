@@ -0,0 +1,434 @@
+package traefik_plugin_geoblock
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Defaults for the body of responses to blocked requests.
+const (
+	defaultBlockContentType = "text/plain; charset=utf-8"
+	defaultRequestIDHeader  = "X-Request-Id"
+)
+
+// Formats of block bodies negotiated on the Accept header, in order of preference if clients accept several equally.
+const (
+	blockFormatText        = "text"
+	blockFormatProblemJSON = "problem+json"
+	blockFormatHTML        = "html"
+)
+
+// defaultBlockFormats are the built-in bodies of the negotiated formats.
+var defaultBlockFormats = map[string]BlockBodyConfig{
+	blockFormatProblemJSON: {
+		ContentType: "application/problem+json",
+		Template: `{"type":"about:blank","title":{{json .Title}},"status":{{.Status}},` +
+			`"detail":"Access from your location is not allowed.","country":{{json .Country}},"reason":{{json .Reason}}` +
+			`{{if .RequestID}},"requestId":{{json .RequestID}}{{end}}}`,
+	},
+	blockFormatHTML: {
+		ContentType: "text/html; charset=utf-8",
+		Template: `<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>{{.Status}} {{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Access from your location ({{.Country}}) is not allowed.</p>
+{{if .RequestID}}<p>Request ID: {{.RequestID}}</p>
+{{end}}</body>
+</html>
+`,
+	},
+	blockFormatText: {
+		ContentType: "text/plain; charset=utf-8",
+		Template: `{{.Status}} {{.Title}}: access from your location ({{.Country}}) is not allowed.
+{{if .RequestID}}Request ID: {{.RequestID}}
+{{end}}`,
+	},
+}
+
+// BlockBodyConfig defines the body of responses to blocked requests, as a Go template.
+type BlockBodyConfig struct {
+	Template     string // Inline template of the body (e.g. "Not available in {{.Country}}")
+	TemplateFile string // Path to a file with the template of the body
+	ContentType  string // Content-Type of the body (default: text/plain; charset=utf-8)
+}
+
+// BlockBodyFormatsConfig overrides the bodies negotiated on the Accept header. Formats that aren't configured
+// use a built-in body.
+type BlockBodyFormatsConfig struct {
+	ProblemJSON *BlockBodyConfig // Body for API clients (default: an RFC 9457 application/problem+json document)
+	HTML        *BlockBodyConfig // Body for browsers (default: a simple HTML page)
+	Text        *BlockBodyConfig // Body for all other clients (default: a plain text message)
+}
+
+// blockBodyData holds the variables available in templates of block bodies.
+type blockBodyData struct {
+	Status    int    // HTTP status code of the response
+	Title     string // HTTP status text of the response (e.g. Forbidden)
+	Country   string // ISO 3166-1 alpha-2 country code, "-" for private networks
+	IP        string // The blocked IP address
+	Reason    string // The kind of rule that led to the decision
+	RequestID string // The ID of the request, from the request ID header
+	Timestamp string // Time of the request (RFC 3339, UTC)
+}
+
+// blockBodyFuncs are the functions available in templates of block bodies.
+var blockBodyFuncs = map[string]interface{}{
+	"json": jsonValue,
+}
+
+// jsonValue encodes a value as JSON, e.g. a string including its quotes.
+func jsonValue(v interface{}) (string, error) {
+	encoded, err := json.Marshal(v)
+	return string(encoded), err
+}
+
+// bodyTemplate is implemented by text and HTML templates.
+type bodyTemplate interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// blockBody is a compiled block body.
+type blockBody struct {
+	contentType string
+	template    bodyTemplate
+}
+
+// blockResponses holds the bodies of responses to blocked requests.
+type blockResponses struct {
+	body      *blockBody
+	countries map[string]*blockBody
+	formats   map[string]*blockBody // Bodies by negotiated format, nil unless negotiation is enabled
+}
+
+// blockAction overrides the response to requests blocked by a rule.
+type blockAction struct {
+	statusCode int
+	body       *blockBody
+	redirect   *redirectTarget
+}
+
+// initBlockAction compiles the response of a rule. If the rule doesn't override it, nil is returned.
+func initBlockAction(cfg RuleConfig, allow bool) (*blockAction, error) {
+	if cfg.StatusCode == 0 && cfg.BlockBody == nil && cfg.RedirectURL == "" {
+		return nil, nil
+	}
+	if allow {
+		return nil, errors.New("status code, block body and redirect URL can only be set for block rules")
+	}
+
+	if cfg.StatusCode != 0 && http.StatusText(cfg.StatusCode) == "" {
+		return nil, fmt.Errorf("%d is not a valid http status code", cfg.StatusCode)
+	}
+	a := &blockAction{statusCode: cfg.StatusCode}
+
+	var err error
+	if cfg.BlockBody != nil {
+		if a.body, err = initBlockBody(*cfg.BlockBody, defaultBlockContentType); err != nil {
+			return nil, fmt.Errorf("block body: %w", err)
+		}
+	}
+	if cfg.RedirectURL != "" {
+		if a.redirect, err = initRedirectTarget(cfg.RedirectURL); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// initBlockResponses compiles and validates the configured block bodies. If none are configured, nil is returned.
+func initBlockResponses(cfg *Config, countryGroups map[string][]string) (*blockResponses, error) {
+	if cfg.NegotiateBlockBody {
+		if cfg.BlockBody != nil {
+			return nil, errors.New("block body can't be combined with negotiated block bodies, configure block body formats instead")
+		}
+	} else if cfg.BlockBodyFormats != (BlockBodyFormatsConfig{}) {
+		return nil, errors.New("block body formats require negotiated block bodies to be enabled")
+	}
+	if cfg.BlockBody == nil && len(cfg.CountryBlockBodies) == 0 && !cfg.NegotiateBlockBody {
+		return nil, nil
+	}
+
+	r := &blockResponses{}
+
+	defaultContentType := defaultBlockContentType
+	if cfg.BlockBody != nil {
+		body, err := initBlockBody(*cfg.BlockBody, defaultContentType)
+		if err != nil {
+			return nil, fmt.Errorf("block body: %w", err)
+		}
+		r.body = body
+		defaultContentType = body.contentType
+	}
+
+	if cfg.NegotiateBlockBody {
+		r.formats = make(map[string]*blockBody, len(defaultBlockFormats))
+		for format, bodyCfg := range map[string]*BlockBodyConfig{
+			blockFormatProblemJSON: cfg.BlockBodyFormats.ProblemJSON,
+			blockFormatHTML:        cfg.BlockBodyFormats.HTML,
+			blockFormatText:        cfg.BlockBodyFormats.Text,
+		} {
+			formatDefault := defaultBlockFormats[format]
+			if bodyCfg == nil {
+				bodyCfg = &formatDefault
+			}
+
+			body, err := initBlockBody(*bodyCfg, formatDefault.ContentType)
+			if err != nil {
+				return nil, fmt.Errorf("%s block body: %w", format, err)
+			}
+			r.formats[format] = body
+		}
+	}
+
+	if len(cfg.CountryBlockBodies) > 0 {
+		bodies := make(map[string]*blockBody, len(cfg.CountryBlockBodies))
+		keys := make([]string, 0, len(cfg.CountryBlockBodies))
+		for key, bodyCfg := range cfg.CountryBlockBodies {
+			body, err := initBlockBody(bodyCfg, defaultContentType)
+			if err != nil {
+				return nil, fmt.Errorf("block body for %q: %w", key, err)
+			}
+			bodies[key] = body
+			keys = append(keys, key)
+		}
+
+		byCountry, err := expandCountryKeys(keys, countryGroups)
+		if err != nil {
+			return nil, fmt.Errorf("block bodies: %w", err)
+		}
+		r.countries = make(map[string]*blockBody, len(byCountry))
+		for country, key := range byCountry {
+			r.countries[country] = bodies[key]
+		}
+	}
+
+	return r, nil
+}
+
+// initCountryStatusCodes validates the status codes for blocked requests from specific countries or country groups.
+func initCountryStatusCodes(statusCodes map[string]int, countryGroups map[string][]string) (map[string]int, error) {
+	if len(statusCodes) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, 0, len(statusCodes))
+	for key, statusCode := range statusCodes {
+		if http.StatusText(statusCode) == "" {
+			return nil, fmt.Errorf("status code for %q: %d is not a valid http status code", key, statusCode)
+		}
+		keys = append(keys, key)
+	}
+
+	byCountry, err := expandCountryKeys(keys, countryGroups)
+	if err != nil {
+		return nil, fmt.Errorf("status codes: %w", err)
+	}
+
+	countryStatusCodes := make(map[string]int, len(byCountry))
+	for country, key := range byCountry {
+		countryStatusCodes[country] = statusCodes[key]
+	}
+
+	return countryStatusCodes, nil
+}
+
+// initBlockBody parses the template of a block body and validates it by executing it with sample data.
+// Templates of HTML bodies escape their variables.
+func initBlockBody(cfg BlockBodyConfig, defaultContentType string) (*blockBody, error) {
+	if (cfg.Template == "") == (cfg.TemplateFile == "") {
+		return nil, errors.New("either a template or a template file must be configured")
+	}
+
+	text := cfg.Template
+	if cfg.TemplateFile != "" {
+		content, err := os.ReadFile(cfg.TemplateFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template file: %w", err)
+		}
+		text = string(content)
+	}
+
+	body := &blockBody{contentType: defaultContentType}
+	if cfg.ContentType != "" {
+		body.contentType = cfg.ContentType
+	}
+	mediaType, _, err := mime.ParseMediaType(body.contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type %q: %w", body.contentType, err)
+	}
+
+	if mediaType == "text/html" {
+		body.template, err = htmltemplate.New("body").Funcs(blockBodyFuncs).Parse(text)
+	} else {
+		body.template, err = template.New("body").Funcs(blockBodyFuncs).Parse(text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+
+	sample := blockBodyData{
+		Status:    http.StatusForbidden,
+		Title:     http.StatusText(http.StatusForbidden),
+		Country:   "DE",
+		IP:        "192.0.2.1",
+		Reason:    reasonDefault,
+		RequestID: "sample",
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := body.template.Execute(io.Discard, sample); err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+
+	return body, nil
+}
+
+// bodyFor returns the block body for requests from the given country, if any. Bodies for specific countries take
+// precedence over negotiated bodies.
+func (r *blockResponses) bodyFor(country, accept string) *blockBody {
+	if r == nil {
+		return nil
+	}
+	if body, ok := r.countries[country]; ok {
+		return body
+	}
+	if r.formats != nil {
+		return r.formats[negotiateBlockFormat(accept)]
+	}
+
+	return r.body
+}
+
+// negotiateBlockFormat selects the format of a block body from the Accept header. The format with the highest
+// quality wins, followed by the one matched most specifically. Plain text is used if nothing else is acceptable.
+func negotiateBlockFormat(accept string) string {
+	format, bestQuality, bestSpecificity := blockFormatText, 0.0, -1
+
+	for _, offer := range []struct {
+		format     string
+		mediaTypes []string
+	}{
+		{blockFormatText, []string{"text/plain"}},
+		{blockFormatProblemJSON, []string{"application/problem+json", "application/json"}},
+		{blockFormatHTML, []string{"text/html", "application/xhtml+xml"}},
+	} {
+		for _, mediaType := range offer.mediaTypes {
+			quality, specificity := acceptQuality(accept, mediaType)
+			if quality > bestQuality || (quality == bestQuality && quality > 0 && specificity > bestSpecificity) {
+				format, bestQuality, bestSpecificity = offer.format, quality, specificity
+			}
+		}
+	}
+
+	return format
+}
+
+// acceptQuality returns the quality of a media type according to the Accept header, along with the specificity of
+// the matching media range: 2 for an exact match, 1 for type/* and 0 for */*.
+func acceptQuality(accept, mediaType string) (float64, int) {
+	quality, specificity := 0.0, -1
+	mainType, _, _ := strings.Cut(mediaType, "/")
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		rangeType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+
+		var rangeSpecificity int
+		switch rangeType {
+		case mediaType:
+			rangeSpecificity = 2
+		case mainType + "/*":
+			rangeSpecificity = 1
+		case "*/*":
+			rangeSpecificity = 0
+		default:
+			continue
+		}
+		if rangeSpecificity < specificity {
+			continue
+		}
+
+		rangeQuality := 1.0
+		if q, ok := params["q"]; ok {
+			if rangeQuality, err = strconv.ParseFloat(q, 64); err != nil || rangeQuality < 0 || rangeQuality > 1 {
+				continue
+			}
+		}
+		quality, specificity = rangeQuality, rangeSpecificity
+	}
+
+	return quality, specificity
+}
+
+// writeBlocked responds to a blocked request with the given status code and the configured block body, if any.
+func (p Plugin) writeBlocked(rw http.ResponseWriter, req *http.Request, decision Decision, statusCode int) {
+	if p.blockResponses != nil && p.blockResponses.formats != nil {
+		rw.Header().Add("Vary", "Accept")
+	}
+
+	var body *blockBody
+	if decision.action != nil && decision.action.body != nil {
+		body = decision.action.body
+	} else {
+		body = p.blockResponses.bodyFor(decision.Country, req.Header.Get("Accept"))
+	}
+	if body == nil || !bodyAllowedForStatus(statusCode) {
+		rw.WriteHeader(statusCode)
+		return
+	}
+
+	var buf bytes.Buffer
+	err := body.template.Execute(&buf, blockBodyData{
+		Status:    statusCode,
+		Title:     http.StatusText(statusCode),
+		Country:   decision.Country,
+		IP:        decision.IP,
+		Reason:    decision.Reason,
+		RequestID: req.Header.Get(p.requestIDHeader),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		log.Printf("%s: failed to render block body: %v", p.name, err)
+		rw.WriteHeader(statusCode)
+		return
+	}
+
+	rw.Header().Set("Content-Type", body.contentType)
+	rw.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	rw.WriteHeader(statusCode)
+	_, _ = rw.Write(buf.Bytes())
+}
+
+// blockStatusCode returns the status code for a blocked request. Status codes of the decision, e.g. of rules or
+// path policies, take precedence over those of countries, followed by the configured default.
+func (p Plugin) blockStatusCode(decision Decision) int {
+	if decision.StatusCode != 0 {
+		return decision.StatusCode
+	}
+	if statusCode, ok := p.countryStatusCodes[decision.Country]; ok {
+		return statusCode
+	}
+
+	return p.disallowedStatusCode
+}
+
+// bodyAllowedForStatus indicates whether responses with the given status code may have a body.
+func bodyAllowedForStatus(statusCode int) bool {
+	return statusCode >= 200 && statusCode != http.StatusNoContent && statusCode != http.StatusNotModified
+}
@@ -0,0 +1,348 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInitBlockResponses(t *testing.T) {
+	t.Run("None", func(t *testing.T) {
+		r, err := initBlockResponses(&Config{}, nil)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if r != nil {
+			t.Errorf("expected no block responses, but got: %v", r)
+		}
+	})
+
+	t.Run("ContentTypeInherited", func(t *testing.T) {
+		r, err := initBlockResponses(&Config{
+			BlockBody:          &BlockBodyConfig{Template: "<p>blocked</p>", ContentType: "text/html; charset=utf-8"},
+			CountryBlockBodies: map[string]BlockBodyConfig{"deu": {Template: "<p>gesperrt</p>"}},
+		}, nil)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if body := r.bodyFor("DE", ""); body == nil || body.contentType != "text/html; charset=utf-8" {
+			t.Errorf("expected country body with inherited content type, but got: %v", body)
+		}
+		if body := r.bodyFor("US", ""); body != r.body {
+			t.Errorf("expected default body, but got: %v", body)
+		}
+	})
+
+	for name, cfg := range map[string]*Config{
+		"NoTemplate":         {BlockBody: &BlockBodyConfig{}},
+		"TemplateAndFile":    {BlockBody: &BlockBodyConfig{Template: "x", TemplateFile: "body.html"}},
+		"MissingFile":        {BlockBody: &BlockBodyConfig{TemplateFile: "does-not-exist.html"}},
+		"SyntaxError":        {BlockBody: &BlockBodyConfig{Template: "{{.Country"}},
+		"UnknownVariable":    {BlockBody: &BlockBodyConfig{Template: "{{.City}}"}},
+		"InvalidContentType": {BlockBody: &BlockBodyConfig{Template: "x", ContentType: "text/"}},
+		"InvalidCountry":     {CountryBlockBodies: map[string]BlockBodyConfig{"XX": {Template: "x"}}},
+		"DuplicateCountry": {CountryBlockBodies: map[string]BlockBodyConfig{
+			"DE": {Template: "x"}, "DEU": {Template: "y"},
+		}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := initBlockResponses(cfg, nil); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestPlugin_ServeHTTP_BlockBody(t *testing.T) {
+	templateFile := filepath.Join(t.TempDir(), "blocked.html")
+	err := os.WriteFile(templateFile, []byte("<p>Request {{.RequestID}} from {{.IP}} blocked</p>"), 0o600)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	plugin, err := New(context.TODO(), &noopHandler{}, &Config{
+		Enabled:              true,
+		DatabaseFilePath:     dbFilePath,
+		AllowedCountries:     []string{"US"},
+		DisallowedStatusCode: http.StatusForbidden,
+		BlockBody:            &BlockBodyConfig{Template: "Not available in {{.Country}} ({{.IP}})"},
+		CountryBlockBodies: map[string]BlockBodyConfig{
+			"DE": {TemplateFile: templateFile, ContentType: "text/html; charset=utf-8"},
+		},
+	}, pluginName)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	for _, test := range []struct {
+		ip          string
+		requestID   string
+		contentType string
+		body        string
+	}{
+		{"77.88.8.8", "", "text/plain; charset=utf-8", "Not available in RU (77.88.8.8)"},
+		{"185.5.82.105", "<script>", "text/html; charset=utf-8", "<p>Request &lt;script&gt; from 185.5.82.105 blocked</p>"},
+	} {
+		t.Run(test.ip, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/foobar", nil)
+			req.Header.Set("X-Real-IP", test.ip)
+			req.Header.Set("X-Request-Id", test.requestID)
+
+			rr := httptest.NewRecorder()
+			plugin.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("expected status code %d, but got: %d", http.StatusForbidden, rr.Code)
+			}
+			if contentType := rr.Header().Get("Content-Type"); contentType != test.contentType {
+				t.Errorf("expected content type %q, but got: %q", test.contentType, contentType)
+			}
+			if body := rr.Body.String(); body != test.body {
+				t.Errorf("expected body %q, but got: %q", test.body, body)
+			}
+		})
+	}
+}
+
+func TestPlugin_ServeHTTP_BlockBodyNoContent(t *testing.T) {
+	plugin, err := New(context.TODO(), &noopHandler{}, &Config{
+		Enabled:              true,
+		DatabaseFilePath:     dbFilePath,
+		AllowedCountries:     []string{"US"},
+		DisallowedStatusCode: http.StatusNoContent,
+		BlockBody:            &BlockBodyConfig{Template: "blocked"},
+	}, pluginName)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/foobar", nil)
+	req.Header.Set("X-Real-IP", "77.88.8.8")
+
+	rr := httptest.NewRecorder()
+	plugin.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected status code %d, but got: %d", http.StatusNoContent, rr.Code)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("expected no body, but got: %q", rr.Body.String())
+	}
+}
+
+func TestNegotiateBlockFormat(t *testing.T) {
+	for accept, expected := range map[string]string{
+		"": blockFormatText,
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": blockFormatHTML,
+		"application/json":                blockFormatProblemJSON,
+		"application/problem+json":        blockFormatProblemJSON,
+		"application/*":                   blockFormatProblemJSON,
+		"*/*":                             blockFormatText,
+		"text/*":                          blockFormatText,
+		"text/plain;q=0.5, text/html":     blockFormatHTML,
+		"application/json, */*;q=0.1":     blockFormatProblemJSON,
+		"text/html;q=0, */*":              blockFormatText,
+		"image/png":                       blockFormatText,
+		"application/json;q=invalid, foo": blockFormatText,
+	} {
+		t.Run(accept, func(t *testing.T) {
+			if actual := negotiateBlockFormat(accept); actual != expected {
+				t.Errorf("expected format %q, but got: %q", expected, actual)
+			}
+		})
+	}
+}
+
+func TestInitBlockResponses_Negotiate(t *testing.T) {
+	for name, cfg := range map[string]*Config{
+		"WithBlockBody": {
+			NegotiateBlockBody: true,
+			BlockBody:          &BlockBodyConfig{Template: "x"},
+		},
+		"FormatsWithoutNegotiation": {
+			BlockBodyFormats: BlockBodyFormatsConfig{Text: &BlockBodyConfig{Template: "x"}},
+		},
+		"InvalidFormat": {
+			NegotiateBlockBody: true,
+			BlockBodyFormats:   BlockBodyFormatsConfig{HTML: &BlockBodyConfig{Template: "{{.Foo}}"}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := initBlockResponses(cfg, nil); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestPlugin_ServeHTTP_NegotiatedBlockBody(t *testing.T) {
+	plugin, err := New(context.TODO(), &noopHandler{}, &Config{
+		Enabled:              true,
+		DatabaseFilePath:     dbFilePath,
+		AllowedCountries:     []string{"US"},
+		DisallowedStatusCode: http.StatusForbidden,
+		NegotiateBlockBody:   true,
+		BlockBodyFormats: BlockBodyFormatsConfig{
+			Text: &BlockBodyConfig{Template: "blocked in {{.Country}}"},
+		},
+		CountryBlockBodies: map[string]BlockBodyConfig{
+			"DE": {Template: "gesperrt"},
+		},
+	}, pluginName)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	for name, test := range map[string]struct {
+		ip          string
+		accept      string
+		contentType string
+		body        string
+	}{
+		"ProblemJSON": {
+			"77.88.8.8", "application/json", "application/problem+json",
+			`{"type":"about:blank","title":"Forbidden","status":403,"detail":"Access from your location is not allowed.",` +
+				`"country":"RU","reason":"default","requestId":"abc\"123"}`,
+		},
+		"Text":    {"77.88.8.8", "", "text/plain; charset=utf-8", "blocked in RU"},
+		"Country": {"185.5.82.105", "application/json", "text/plain; charset=utf-8", "gesperrt"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/foobar", nil)
+			req.Header.Set("X-Real-IP", test.ip)
+			req.Header.Set("X-Request-Id", `abc"123`)
+			req.Header.Set("Accept", test.accept)
+
+			rr := httptest.NewRecorder()
+			plugin.ServeHTTP(rr, req)
+
+			if contentType := rr.Header().Get("Content-Type"); contentType != test.contentType {
+				t.Errorf("expected content type %q, but got: %q", test.contentType, contentType)
+			}
+			if body := rr.Body.String(); body != test.body {
+				t.Errorf("expected body %s, but got: %s", test.body, body)
+			}
+			if vary := rr.Header().Get("Vary"); vary != "Accept" {
+				t.Errorf("expected Vary header %q, but got: %q", "Accept", vary)
+			}
+		})
+	}
+
+	t.Run("HTML", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/foobar", nil)
+		req.Header.Set("X-Real-IP", "77.88.8.8")
+		req.Header.Set("X-Request-Id", "<b>")
+		req.Header.Set("Accept", "text/html")
+
+		rr := httptest.NewRecorder()
+		plugin.ServeHTTP(rr, req)
+
+		if contentType := rr.Header().Get("Content-Type"); contentType != "text/html; charset=utf-8" {
+			t.Errorf("expected content type %q, but got: %q", "text/html; charset=utf-8", contentType)
+		}
+		if body := rr.Body.String(); !strings.Contains(body, "<p>Request ID: &lt;b&gt;</p>") || !strings.Contains(body, "(RU)") {
+			t.Errorf("expected escaped HTML page, but got: %s", body)
+		}
+	})
+}
+
+func TestInitCountryStatusCodes(t *testing.T) {
+	groups, err := initCountryGroups(nil)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	statusCodes, err := initCountryStatusCodes(map[string]int{"@EU": http.StatusNotFound, "DE": http.StatusGone}, groups)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if statusCodes["DE"] != http.StatusGone || statusCodes["FR"] != http.StatusNotFound || len(statusCodes) != len(euCountries) {
+		t.Errorf("expected DE=410 and other EU countries 404, but got: %v", statusCodes)
+	}
+
+	for name, codes := range map[string]map[string]int{
+		"InvalidStatusCode": {"DE": 999},
+		"UnknownGroup":      {"@FOO": http.StatusNotFound},
+		"OverlappingGroups": {"@EU": http.StatusNotFound, "@EEA": http.StatusGone},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := initCountryStatusCodes(codes, groups); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestInitBlockAction_Errors(t *testing.T) {
+	for name, cfg := range map[string]RuleConfig{
+		"AllowRule":         {Action: "allow", Countries: []string{"DE"}, StatusCode: http.StatusTooManyRequests},
+		"InvalidStatusCode": {Action: "block", Countries: []string{"DE"}, StatusCode: 999},
+		"InvalidBody":       {Action: "block", Countries: []string{"DE"}, BlockBody: &BlockBodyConfig{}},
+		"InvalidRedirect":   {Action: "block", Countries: []string{"DE"}, RedirectURL: "/{{.Path}}"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := initRule(cfg, nil); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestPlugin_ServeHTTP_ResponseOverrides(t *testing.T) {
+	plugin, err := New(context.TODO(), &noopHandler{}, &Config{
+		Enabled:              true,
+		DatabaseFilePath:     dbFilePath,
+		DisallowedStatusCode: http.StatusForbidden,
+		CountryStatusCodes:   map[string]int{"@EU": http.StatusNotFound},
+		BlockRedirect:        &BlockRedirectConfig{URL: "/blocked"},
+		Rules: []RuleConfig{
+			{
+				Name: "sanctioned", Action: "block", Countries: []string{"RU"},
+				StatusCode: http.StatusUnavailableForLegalReasons,
+				BlockBody:  &BlockBodyConfig{Template: "Unavailable for legal reasons ({{.Status}})"},
+			},
+			{Name: "datacenter", Action: "block", IPBlocks: []string{"1.1.1.1/32"}, StatusCode: http.StatusTooManyRequests},
+			{Name: "moved", Action: "block", IPBlocks: []string{"8.8.4.4/32"}, RedirectURL: "https://example.org/blocked"},
+			{Name: "us", Action: "allow", Countries: []string{"US"}},
+		},
+	}, pluginName)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	for name, test := range map[string]struct {
+		ip       string
+		code     int
+		location string
+		body     string
+	}{
+		"RuleStatusAndBody": {"77.88.8.8", http.StatusUnavailableForLegalReasons, "", "Unavailable for legal reasons (451)"},
+		"RuleStatus":        {"1.1.1.1", http.StatusTooManyRequests, "", ""},
+		"RuleRedirect":      {"8.8.4.4", http.StatusFound, "https://example.org/blocked", ""},
+		"GroupStatus":       {"185.5.82.105", http.StatusNotFound, "", ""},
+		"DefaultRedirect":   {"192.168.178.66", http.StatusFound, "/blocked", ""},
+		"Allowed":           {"8.8.8.8", http.StatusTeapot, "", ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/shop", nil)
+			req.Header.Set("X-Real-IP", test.ip)
+
+			rr := httptest.NewRecorder()
+			plugin.ServeHTTP(rr, req)
+
+			if rr.Code != test.code {
+				t.Errorf("expected status code %d, but got: %d", test.code, rr.Code)
+			}
+			if location := rr.Header().Get("Location"); location != test.location {
+				t.Errorf("expected location %q, but got: %q", test.location, location)
+			}
+			if test.body != "" && rr.Body.String() != test.body {
+				t.Errorf("expected body %q, but got: %q", test.body, rr.Body.String())
+			}
+		})
+	}
+}
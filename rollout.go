@@ -0,0 +1,62 @@
+package traefik_plugin_geoblock
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+)
+
+// rolloutBuckets is the number of buckets clients are assigned to, one per percent.
+const rolloutBuckets = 100
+
+// rollout enforces decisions for a stable percentage of clients only, the rest are handled like in report mode.
+type rollout struct {
+	percent int
+}
+
+// initRollout validates the percentage of clients to enforce decisions for. If the percentage is unset or all clients
+// are enforced, no rollout is returned. At 0%, decisions are enforced for no client at all.
+func initRollout(percent *int) (*rollout, error) {
+	if percent == nil {
+		return nil, nil
+	}
+	if *percent < 0 || *percent > 100 {
+		return nil, fmt.Errorf("invalid enforce percentage %d, must be between 0 and 100", *percent)
+	}
+	if *percent == 100 {
+		return nil, nil
+	}
+
+	return &rollout{percent: *percent}, nil
+}
+
+// enforced indicates whether decisions for the client IP are enforced. A client is always assigned to the same bucket,
+// and raising the percentage only adds buckets, so enforced clients stay enforced.
+//
+// NB: clients are bucketed by nothing but their IP, as they could pick a value in a bucket that isn't enforced
+// otherwise. IPv6 addresses are bucketed by their /64 network, which is commonly assigned to a single subscriber.
+func (r *rollout) enforced(ip string) bool {
+	if r == nil {
+		return true
+	}
+
+	return rolloutBucket(rolloutKey(ip)) < r.percent
+}
+
+// rolloutKey returns the key a client IP is bucketed by.
+func rolloutKey(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.To4() != nil {
+		return ip
+	}
+
+	return parsed.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// rolloutBucket assigns a key to one of the buckets.
+func rolloutBucket(key string) int {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+
+	return int(h.Sum64() % rolloutBuckets)
+}
@@ -0,0 +1,193 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInitRollout(t *testing.T) {
+	for name, percent := range map[string]*int{
+		"Unset": nil,
+		"100":   percentOf(100),
+	} {
+		t.Run(name, func(t *testing.T) {
+			r, err := initRollout(percent)
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if r != nil {
+				t.Errorf("expected no rollout, but got: %v", r)
+			}
+		})
+	}
+
+	t.Run("0", func(t *testing.T) {
+		r, err := initRollout(percentOf(0))
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if r == nil || r.enforced("8.8.8.8") {
+			t.Errorf("expected rollout enforcing no client, but got: %v", r)
+		}
+	})
+
+	for name, percent := range map[string]int{
+		"Negative": -1,
+		"TooLarge": 101,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := initRollout(&percent); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestRollout_Enforced(t *testing.T) {
+	t.Run("Nil", func(t *testing.T) {
+		var r *rollout
+		if !r.enforced("8.8.8.8") {
+			t.Error("expected all clients to be enforced without rollout")
+		}
+	})
+
+	t.Run("Distribution", func(t *testing.T) {
+		r := &rollout{percent: 25}
+
+		enforced := 0
+		for i := 0; i < 4000; i++ {
+			ip := fmt.Sprintf("10.%d.%d.1", i/256, i%256)
+			if r.enforced(ip) {
+				enforced++
+			}
+			if r.enforced(ip) != r.enforced(ip) {
+				t.Fatalf("expected stable bucket for %s", ip)
+			}
+		}
+		if enforced < 800 || enforced > 1200 {
+			t.Errorf("expected about 1000 of 4000 clients to be enforced, but got: %d", enforced)
+		}
+	})
+
+	t.Run("Monotonic", func(t *testing.T) {
+		for i := 0; i < 1000; i++ {
+			ip := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+			if (&rollout{percent: 10}).enforced(ip) && !(&rollout{percent: 50}).enforced(ip) {
+				t.Fatalf("expected %s to stay enforced when raising the percentage", ip)
+			}
+		}
+	})
+
+	t.Run("IPv6Network", func(t *testing.T) {
+		r := &rollout{percent: 50}
+		for i := 0; i < 100; i++ {
+			if r.enforced(fmt.Sprintf("2001:db8:1:2::%x", i)) != r.enforced("2001:db8:1:2::1") {
+				t.Fatal("expected addresses of the same /64 network to share a bucket")
+			}
+		}
+	})
+}
+
+// percentOf returns a pointer to the given enforce percentage.
+func percentOf(percent int) *int {
+	return &percent
+}
+
+// findRolloutIPs returns an IP address that is enforced and one that isn't at the given percentage.
+func findRolloutIPs(t *testing.T, percent int) (string, string) {
+	t.Helper()
+
+	var enforced, reported string
+	for i := 0; i < 1000 && (enforced == "" || reported == ""); i++ {
+		ip := fmt.Sprintf("77.88.%d.%d", i/256, i%256)
+		if rolloutBucket(rolloutKey(ip)) < percent {
+			enforced = ip
+		} else {
+			reported = ip
+		}
+	}
+	if enforced == "" || reported == "" {
+		t.Fatal("expected to find IP addresses in both groups")
+	}
+
+	return enforced, reported
+}
+
+func TestPlugin_ServeHTTP_EnforcePercent(t *testing.T) {
+	plugin, err := New(context.TODO(), &noopHandler{}, &Config{
+		Enabled:              true,
+		DatabaseFilePath:     dbFilePath,
+		AllowedCountries:     []string{"US"},
+		DisallowedStatusCode: http.StatusForbidden,
+		EnforcePercent:       percentOf(50),
+	}, pluginName)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	enforcedIP, reportedIP := findRolloutIPs(t, 50)
+
+	for ip, expected := range map[string]int{
+		enforcedIP: http.StatusForbidden,
+		reportedIP: http.StatusTeapot,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/foobar", nil)
+		req.Header.Set("X-Real-IP", ip)
+
+		rr := httptest.NewRecorder()
+		plugin.ServeHTTP(rr, req)
+
+		if rr.Code != expected {
+			t.Errorf("expected status code %d for %s, but got: %d", expected, ip, rr.Code)
+		}
+	}
+
+	if counts := plugin.(*Plugin).WouldBlockCounts(); counts["RU"] != 1 {
+		t.Errorf("expected RU=1, but got: %v", counts)
+	}
+}
+
+func TestPlugin_ServeHTTP_EnforcePercentDefault(t *testing.T) {
+	testEnforcePercent(t, &Config{
+		Enabled:              true,
+		DatabaseFilePath:     dbFilePath,
+		AllowedCountries:     []string{"US"},
+		DisallowedStatusCode: http.StatusForbidden,
+	}, http.StatusForbidden)
+}
+
+func TestPlugin_ServeHTTP_EnforcePercentZero(t *testing.T) {
+	testEnforcePercent(t, &Config{
+		Enabled:              true,
+		DatabaseFilePath:     dbFilePath,
+		AllowedCountries:     []string{"US"},
+		DisallowedStatusCode: http.StatusForbidden,
+		EnforcePercent:       percentOf(0),
+	}, http.StatusTeapot)
+}
+
+// testEnforcePercent asserts that requests from clients in any bucket result in the expected status code.
+func testEnforcePercent(t *testing.T, cfg *Config, expected int) {
+	t.Helper()
+
+	plugin, err := New(context.TODO(), &noopHandler{}, cfg, pluginName)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	enforcedIP, reportedIP := findRolloutIPs(t, 50)
+	for _, ip := range []string{enforcedIP, reportedIP} {
+		req := httptest.NewRequest(http.MethodGet, "/foobar", nil)
+		req.Header.Set("X-Real-IP", ip)
+
+		rr := httptest.NewRecorder()
+		plugin.ServeHTTP(rr, req)
+
+		if rr.Code != expected {
+			t.Errorf("expected status code %d for %s, but got: %d", expected, ip, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,750 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule actions.
+const (
+	actionAllow = "allow"
+	actionBlock = "block"
+)
+
+// reasonRulePrefix is followed by the name or position of the matching rule, e.g. "rule:office".
+const reasonRulePrefix = "rule:"
+
+// RuleConfig defines a rule of an ordered rule list. A rule matches if all of its conditions match,
+// and a condition matches if any of its values match. A rule without conditions matches every request.
+type RuleConfig struct {
+	Name        string             // Optional name of the rule, included in logs
+	Action      string             // Action to take if the rule matches: "allow" or "block"
+	Countries   []string           // Countries to match (ISO 3166-1 codes or @groups)
+	Regions     []string           // Regions to match (ISO 3166-2 codes or "CC:Region name")
+	IPBlocks    []string           // CIDRs to match
+	ASNs        []string           // Autonomous system numbers to match
+	ISPs        []string           // ISP / AS organization name patterns to match
+	UsageTypes  []string           // Usage types to match
+	ProxyTypes  []string           // Proxy types to match
+	Headers     map[string]string  // Request headers to match, by name, with a value pattern (e.g. "*" for any value)
+	Paths       []string           // Request paths to match, as prefixes or glob patterns (e.g. /api/*/admin)
+	Methods     []string           // HTTP methods to match (e.g. POST, DELETE)
+	ClientCerts []ClientCertConfig // Client certificates presented via mutual TLS to match
+	ReverseDNS  []string           // Forward-confirmed hostnames of the IP address to match (e.g. example.com or *.compute.amazonaws.com)
+	Schedule    *ScheduleConfig    // When the rule applies, e.g. on weekdays during business hours or for a fixed period
+	Expression  string             // Policy expression to match, e.g. country in @EU && !(asn in [16509, 14618])
+	StatusCode  int                // HTTP status code to respond with if the rule blocks (default: that of the country or the global one)
+	BlockBody   *BlockBodyConfig   // Body of the response if the rule blocks
+	RedirectURL string             // URL template to redirect to if the rule blocks, instead of responding
+}
+
+// condition is a single condition of a rule.
+type condition interface {
+	matches(ctx *evalContext) (bool, error)
+}
+
+// rule is a compiled rule.
+type rule struct {
+	allow      bool
+	reason     string
+	conditions []condition
+	action     *blockAction // Response to requests blocked by the rule, nil for the default
+}
+
+// matches indicates whether all conditions of the rule match.
+func (r rule) matches(ctx *evalContext) (bool, error) {
+	for _, cond := range r.conditions {
+		matched, err := cond.matches(ctx)
+		if errors.Is(err, errHostnamesUnavailable) {
+			// NB: in the closed failure mode, unknown hostnames must neither allow requests nor let them skip blocks.
+			matched, err = !r.allow, nil
+		}
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// evalContext holds the request being evaluated, and lazily looks up the information rules depend on.
+type evalContext struct {
+	p        *Plugin
+	req      *http.Request
+	method   string // HTTP method of the request, or the requested method of CORS preflight requests
+	path     string // Cleaned path of the request, see cleanPath
+	ip       net.IP
+	now      time.Time
+	decision *Decision
+
+	regionLoaded     bool
+	asn              *ASNInfo
+	usageTypesLoaded bool
+	usageTypes       []string
+	hostnamesLoaded  bool
+	hostnames        []string
+	hostnamesErr     error
+}
+
+// context returns the context of the request, for lookups that should be abandoned when the request is.
+func (ctx *evalContext) context() context.Context {
+	if ctx.req == nil {
+		return context.Background()
+	}
+
+	return ctx.req.Context()
+}
+
+// region returns the region of the IP address, looking it up on first use.
+func (ctx *evalContext) region() (string, error) {
+	if !ctx.regionLoaded {
+		region, err := ctx.p.LookupRegion(ctx.decision.IP)
+		if err != nil {
+			return "", fmt.Errorf("region lookup of %s failed: %w", ctx.decision.IP, err)
+		}
+		ctx.decision.Region = region
+		ctx.regionLoaded = true
+	}
+
+	return ctx.decision.Region, nil
+}
+
+// asnInfo returns the autonomous system information of the IP address, looking it up on first use.
+func (ctx *evalContext) asnInfo() (ASNInfo, error) {
+	if ctx.asn == nil {
+		info, err := ctx.p.LookupASN(ctx.decision.IP)
+		if err != nil {
+			return ASNInfo{}, fmt.Errorf("ASN lookup of %s failed: %w", ctx.decision.IP, err)
+		}
+		ctx.asn = &info
+	}
+
+	return *ctx.asn, nil
+}
+
+// usageTypeList returns the usage types of the IP address, looking them up on first use.
+func (ctx *evalContext) usageTypeList() ([]string, error) {
+	if !ctx.usageTypesLoaded {
+		usageTypes, err := ctx.p.LookupUsageType(ctx.decision.IP)
+		if err != nil {
+			return nil, fmt.Errorf("usage type lookup of %s failed: %w", ctx.decision.IP, err)
+		}
+		ctx.usageTypes = usageTypes
+		ctx.usageTypesLoaded = true
+	}
+
+	return ctx.usageTypes, nil
+}
+
+// hostnameList returns the forward-confirmed hostnames of the IP address, looking them up on first use.
+// Failed lookups aren't repeated for the same request.
+func (ctx *evalContext) hostnameList() ([]string, error) {
+	if !ctx.hostnamesLoaded {
+		ctx.hostnames, ctx.hostnamesErr = ctx.p.rdns.hostnames(ctx.context(), ctx.ip, ctx.now)
+		ctx.hostnamesLoaded = true
+	}
+
+	return ctx.hostnames, ctx.hostnamesErr
+}
+
+// privateCondition matches private / internal networks, which have no country.
+type privateCondition struct{}
+
+func (privateCondition) matches(ctx *evalContext) (bool, error) {
+	return ctx.decision.Country == "-", nil
+}
+
+// countryCondition matches a set of countries.
+type countryCondition map[string]struct{}
+
+func (c countryCondition) matches(ctx *evalContext) (bool, error) {
+	_, ok := c[ctx.decision.Country]
+	return ok, nil
+}
+
+// notCondition matches if the wrapped condition doesn't match.
+type notCondition struct {
+	condition
+}
+
+func (c notCondition) matches(ctx *evalContext) (bool, error) {
+	matched, err := c.condition.matches(ctx)
+	return !matched, err
+}
+
+// ipBlockCondition matches a list of CIDRs.
+type ipBlockCondition []*net.IPNet
+
+func (c ipBlockCondition) matches(ctx *evalContext) (bool, error) {
+	for _, block := range c {
+		if block.Contains(ctx.ip) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// regionCondition matches a set of regions.
+type regionCondition regionSet
+
+func (c regionCondition) matches(ctx *evalContext) (bool, error) {
+	region, err := ctx.region()
+	if err != nil || region == "" {
+		return false, err
+	}
+
+	return regionSet(c).contains(ctx.decision.Country, region), nil
+}
+
+// asnCondition matches a set of autonomous system numbers.
+type asnCondition map[uint]struct{}
+
+func (c asnCondition) matches(ctx *evalContext) (bool, error) {
+	info, err := ctx.asnInfo()
+	if err != nil || info.Number == 0 {
+		return false, err
+	}
+
+	_, ok := c[info.Number]
+	return ok, nil
+}
+
+// ispCondition matches a list of ISP / AS organization name patterns.
+type ispCondition []string
+
+func (c ispCondition) matches(ctx *evalContext) (bool, error) {
+	info, err := ctx.asnInfo()
+	if err != nil || info.Organization == "" {
+		return false, err
+	}
+
+	return matchesISPPattern(info.Organization, c), nil
+}
+
+// usageTypeCondition matches a set of usage types, or, if unknown is set, addresses without a known usage type.
+type usageTypeCondition struct {
+	usageTypes map[string]struct{}
+	unknown    bool
+}
+
+func (c usageTypeCondition) matches(ctx *evalContext) (bool, error) {
+	usageTypes, err := ctx.usageTypeList()
+	if err != nil {
+		return false, err
+	}
+
+	known := false
+	for _, usageType := range usageTypes {
+		if _, ok := knownUsageTypes[usageType]; !ok {
+			continue
+		}
+		known = true
+
+		if _, ok := c.usageTypes[usageType]; ok {
+			return true, nil
+		}
+	}
+
+	return c.unknown && !known, nil
+}
+
+// proxyTypeCondition matches a set of proxy types.
+type proxyTypeCondition map[string]struct{}
+
+func (c proxyTypeCondition) matches(ctx *evalContext) (bool, error) {
+	_, ok := c[ctx.decision.ProxyType]
+	return ok, nil
+}
+
+// headerCondition matches a request header against a value pattern.
+type headerCondition struct {
+	name    string
+	pattern string
+}
+
+func (c headerCondition) matches(ctx *evalContext) (bool, error) {
+	if ctx.req == nil {
+		return false, nil
+	}
+
+	for _, value := range ctx.req.Header.Values(c.name) {
+		if matched, _ := path.Match(c.pattern, value); matched {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// pathCondition matches the request path against a list of prefixes and glob patterns.
+type pathCondition []string
+
+func (c pathCondition) matches(ctx *evalContext) (bool, error) {
+	if ctx.req == nil {
+		return false, nil
+	}
+
+	return matchesAnyPathPattern(c, ctx.path), nil
+}
+
+// isGlobPattern indicates whether the given pattern contains glob meta characters.
+func isGlobPattern(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// cleanPath returns the shortest path equivalent to the request path, e.g. /admin for //admin or /public/../admin,
+// so requests can't sneak past path matching with unusual but equivalent paths.
+func cleanPath(requestPath string) string {
+	return path.Clean("/" + requestPath)
+}
+
+// hasPathPrefix indicates whether the cleaned request path is the prefix or below it, matching whole segments only,
+// e.g. /api matches /api and /api/orders, but not /api-internal. A trailing slash of the prefix is ignored.
+func hasPathPrefix(requestPath, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	return prefix == "" || requestPath == prefix || strings.HasPrefix(requestPath, prefix+"/")
+}
+
+// matchesPathPattern matches a cleaned request path against a glob pattern, or against a prefix if the pattern
+// contains no meta characters.
+func matchesPathPattern(pattern, requestPath string) bool {
+	if !isGlobPattern(pattern) {
+		return hasPathPrefix(requestPath, pattern)
+	}
+
+	matched, _ := path.Match(pattern, requestPath)
+	return matched
+}
+
+// matchesAnyPathPattern indicates whether the request path matches any of the given prefixes or glob patterns.
+func matchesAnyPathPattern(patterns []string, requestPath string) bool {
+	for _, pattern := range patterns {
+		if matchesPathPattern(pattern, requestPath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// initRules compiles the configured rule list.
+func initRules(rulesCfg []RuleConfig, countryGroups map[string][]string) ([]rule, error) {
+	rules := make([]rule, 0, len(rulesCfg))
+
+	for i, ruleCfg := range rulesCfg {
+		reason := reasonRulePrefix + ruleCfg.Name
+		if ruleCfg.Name == "" {
+			reason = reasonRulePrefix + "#" + strconv.Itoa(i+1)
+		}
+
+		r, err := initRule(ruleCfg, countryGroups)
+		if err == nil && ruleCfg.Schedule != nil {
+			var schedule scheduleCondition
+			schedule, err = initSchedule(*ruleCfg.Schedule, reason)
+
+			// NB: the schedule is checked first, so expiry is noticed regardless of the other conditions.
+			r.conditions = append([]condition{schedule}, r.conditions...)
+		}
+		if err != nil {
+			if ruleCfg.Name != "" {
+				return nil, fmt.Errorf("rule %q: %w", ruleCfg.Name, err)
+			}
+			return nil, fmt.Errorf("rule #%d: %w", i+1, err)
+		}
+
+		r.reason = reason
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+// initRule compiles a single rule.
+func initRule(cfg RuleConfig, countryGroups map[string][]string) (rule, error) {
+	var r rule
+
+	switch strings.ToLower(strings.TrimSpace(cfg.Action)) {
+	case actionAllow:
+		r.allow = true
+	case actionBlock:
+		r.allow = false
+	default:
+		return r, fmt.Errorf("invalid action %q, must be %q or %q", cfg.Action, actionAllow, actionBlock)
+	}
+
+	action, err := initBlockAction(cfg, r.allow)
+	if err != nil {
+		return r, err
+	}
+	r.action = action
+
+	if len(cfg.Countries) > 0 {
+		countries, _, err := expandCountries(cfg.Countries, countryGroups)
+		if err != nil {
+			return r, err
+		}
+		r.conditions = append(r.conditions, newCountryCondition(countries))
+	}
+
+	if len(cfg.Regions) > 0 {
+		regions, err := initRegions(cfg.Regions)
+		if err != nil {
+			return r, err
+		}
+		r.conditions = append(r.conditions, regionCondition(regions))
+	}
+
+	if len(cfg.IPBlocks) > 0 {
+		ipBlocks, err := initIPBlocks(cfg.IPBlocks)
+		if err != nil {
+			return r, err
+		}
+		r.conditions = append(r.conditions, ipBlockCondition(ipBlocks))
+	}
+
+	if len(cfg.ASNs) > 0 {
+		asns, err := initASNs(cfg.ASNs)
+		if err != nil {
+			return r, err
+		}
+		r.conditions = append(r.conditions, asnCondition(asns))
+	}
+
+	if len(cfg.ISPs) > 0 {
+		isps, err := initISPPatterns(cfg.ISPs)
+		if err != nil {
+			return r, err
+		}
+		r.conditions = append(r.conditions, ispCondition(isps))
+	}
+
+	if len(cfg.UsageTypes) > 0 {
+		usageTypes, err := initUsageTypes(cfg.UsageTypes)
+		if err != nil {
+			return r, err
+		}
+		r.conditions = append(r.conditions, usageTypeCondition{usageTypes: usageTypes})
+	}
+
+	if len(cfg.ProxyTypes) > 0 {
+		proxyTypes, err := initProxyTypes(cfg.ProxyTypes)
+		if err != nil {
+			return r, err
+		}
+		r.conditions = append(r.conditions, proxyTypeCondition(proxyTypes))
+	}
+
+	headerNames := make([]string, 0, len(cfg.Headers))
+	for name := range cfg.Headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	for _, name := range headerNames {
+		pattern := cfg.Headers[name]
+		if _, err := path.Match(pattern, ""); err != nil {
+			return r, fmt.Errorf("invalid pattern %q for header %q: %w", pattern, name, err)
+		}
+		r.conditions = append(r.conditions, headerCondition{name: http.CanonicalHeaderKey(name), pattern: pattern})
+	}
+
+	if len(cfg.Paths) > 0 {
+		for _, pattern := range cfg.Paths {
+			if _, err := path.Match(pattern, ""); err != nil || !strings.HasPrefix(pattern, "/") {
+				return r, fmt.Errorf("invalid path pattern %q", pattern)
+			}
+		}
+		r.conditions = append(r.conditions, pathCondition(cfg.Paths))
+	}
+
+	if len(cfg.Methods) > 0 {
+		methods, err := initMethods(cfg.Methods)
+		if err != nil {
+			return r, err
+		}
+		r.conditions = append(r.conditions, methodCondition(methods))
+	}
+
+	if len(cfg.ReverseDNS) > 0 {
+		patterns, err := initReverseDNSPatterns(cfg.ReverseDNS)
+		if err != nil {
+			return r, err
+		}
+		r.conditions = append(r.conditions, reverseDNSCondition(patterns))
+	}
+
+	if len(cfg.ClientCerts) > 0 {
+		matchers, err := initClientCertMatchers(cfg.ClientCerts, false)
+		if err != nil {
+			return r, err
+		}
+		r.conditions = append(r.conditions, clientCertCondition(matchers))
+	}
+
+	if strings.TrimSpace(cfg.Expression) != "" {
+		expr, err := initExpression(cfg.Expression, countryGroups)
+		if err != nil {
+			return r, fmt.Errorf("invalid expression: %w", err)
+		}
+		r.conditions = append(r.conditions, expr)
+	}
+
+	return r, nil
+}
+
+// newCountryCondition creates a condition matching the given list of countries.
+func newCountryCondition(countries []string) countryCondition {
+	c := make(countryCondition, len(countries))
+	for _, country := range countries {
+		c[country] = struct{}{}
+	}
+
+	return c
+}
+
+// listRules translates the allowed* and blocked* lists of the configuration into an equivalent rule list.
+// Rules are ordered from more specific to less specific: IP blocks (the longest prefix first), proxy types,
+// ASNs and ISPs, usage types, regions and finally countries. Within the same level, allow rules come first.
+// Blocked IP blocks are the exception, they don't apply to allowed countries.
+func listRules(cfg *Config, countryGroups map[string][]string, logf func(format string, args ...interface{})) ([]rule, error) {
+	rules := []rule{{allow: cfg.AllowPrivate, reason: reasonPrivate, conditions: []condition{privateCondition{}}}}
+
+	allowedCountries, expanded, err := expandCountries(cfg.AllowedCountries, countryGroups)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading allowed countries: %w", err)
+	}
+	if expanded {
+		logf("allowed countries: %s", strings.Join(allowedCountries, ", "))
+	}
+
+	blockedCountries, expanded, err := expandCountries(cfg.BlockedCountries, countryGroups)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading blocked countries: %w", err)
+	}
+	if expanded {
+		logf("blocked countries: %s", strings.Join(blockedCountries, ", "))
+	}
+
+	for _, country := range intersectCountries(allowedCountries, blockedCountries) {
+		logf("%s is both allowed and blocked, it will be allowed", country)
+	}
+
+	allowedIPBlocks, err := initIPBlocks(cfg.AllowedIPBlocks)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading allowed CIDR blocks: %w", err)
+	}
+
+	blockedIPBlocks, err := initIPBlocks(cfg.BlockedIPBlocks)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading blocked CIDR blocks: %w", err)
+	}
+
+	// NB: addresses of allowed hostnames are as specific as it gets, so they take precedence over CIDRs.
+	if len(cfg.AllowedHostnames) > 0 {
+		hostnames, err := initResolvedHostnames(cfg.AllowedHostnames, cfg.HostnameRefreshInterval, cfg, logf)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading allowed hostnames: %w", err)
+		}
+		rules = append(rules, rule{allow: true, reason: reasonHostname, conditions: []condition{resolvedHostnameCondition{hostnames}}})
+	}
+
+	// NB: allowed countries take precedence over blocked IP blocks, as they always did before rule lists.
+	ipRules := ipBlockRules(allowedIPBlocks, blockedIPBlocks)
+	if len(allowedCountries) > 0 {
+		for i := range ipRules {
+			if !ipRules[i].allow {
+				ipRules[i].conditions = append(ipRules[i].conditions, notCondition{newCountryCondition(allowedCountries)})
+			}
+		}
+	}
+	rules = append(rules, ipRules...)
+
+	blockedProxyTypes, err := initProxyTypes(cfg.BlockedProxyTypes)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading blocked proxy types: %w", err)
+	}
+	if len(blockedProxyTypes) > 0 {
+		rules = append(rules, rule{reason: reasonProxy, conditions: []condition{proxyTypeCondition(blockedProxyTypes)}})
+	}
+
+	allowedReverseDNS, err := initReverseDNSPatterns(cfg.AllowedReverseDNS)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading allowed reverse DNS patterns: %w", err)
+	}
+
+	blockedReverseDNS, err := initReverseDNSPatterns(cfg.BlockedReverseDNS)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading blocked reverse DNS patterns: %w", err)
+	}
+
+	if len(allowedReverseDNS) > 0 {
+		rules = append(rules, rule{allow: true, reason: reasonReverseDNS, conditions: []condition{reverseDNSCondition(allowedReverseDNS)}})
+	}
+	if len(blockedReverseDNS) > 0 {
+		rules = append(rules, rule{reason: reasonReverseDNS, conditions: []condition{reverseDNSCondition(blockedReverseDNS)}})
+	}
+
+	allowedASNs, err := initASNs(cfg.AllowedASNs)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading allowed ASNs: %w", err)
+	}
+
+	blockedASNs, err := initASNs(cfg.BlockedASNs)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading blocked ASNs: %w", err)
+	}
+
+	allowedISPs, err := initISPPatterns(cfg.AllowedISPs)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading allowed ISPs: %w", err)
+	}
+
+	blockedISPs, err := initISPPatterns(cfg.BlockedISPs)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading blocked ISPs: %w", err)
+	}
+
+	if len(allowedASNs) > 0 {
+		rules = append(rules, rule{allow: true, reason: reasonASN, conditions: []condition{asnCondition(allowedASNs)}})
+	}
+	if len(allowedISPs) > 0 {
+		rules = append(rules, rule{allow: true, reason: reasonASN, conditions: []condition{ispCondition(allowedISPs)}})
+	}
+	if len(blockedASNs) > 0 {
+		rules = append(rules, rule{reason: reasonASN, conditions: []condition{asnCondition(blockedASNs)}})
+	}
+	if len(blockedISPs) > 0 {
+		rules = append(rules, rule{reason: reasonASN, conditions: []condition{ispCondition(blockedISPs)}})
+	}
+
+	allowedUsageTypes, err := initUsageTypes(cfg.AllowedUsageTypes)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading allowed usage types: %w", err)
+	}
+
+	blockedUsageTypes, err := initUsageTypes(cfg.BlockedUsageTypes)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading blocked usage types: %w", err)
+	}
+
+	unknownUsageType, err := initUnknownUsageType(cfg.UnknownUsageType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid unknown usage type action: %w", err)
+	}
+
+	if len(allowedUsageTypes) > 0 || unknownUsageType == unknownUsageTypeAllow {
+		rules = append(rules, rule{allow: true, reason: reasonUsageType, conditions: []condition{
+			usageTypeCondition{usageTypes: allowedUsageTypes, unknown: unknownUsageType == unknownUsageTypeAllow},
+		}})
+	}
+	if len(blockedUsageTypes) > 0 || unknownUsageType == unknownUsageTypeBlock {
+		rules = append(rules, rule{reason: reasonUsageType, conditions: []condition{
+			usageTypeCondition{usageTypes: blockedUsageTypes, unknown: unknownUsageType == unknownUsageTypeBlock},
+		}})
+	}
+
+	allowedRegions, err := initRegions(cfg.AllowedRegions)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading allowed regions: %w", err)
+	}
+
+	blockedRegions, err := initRegions(cfg.BlockedRegions)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading blocked regions: %w", err)
+	}
+
+	if len(allowedRegions) > 0 {
+		rules = append(rules, rule{allow: true, reason: reasonRegion, conditions: []condition{regionCondition(allowedRegions)}})
+	}
+	if len(blockedRegions) > 0 {
+		rules = append(rules, rule{reason: reasonRegion, conditions: []condition{regionCondition(blockedRegions)}})
+	}
+
+	if len(allowedCountries) > 0 {
+		rules = append(rules, rule{allow: true, reason: reasonCountry, conditions: []condition{newCountryCondition(allowedCountries)}})
+	}
+	if len(blockedCountries) > 0 {
+		rules = append(rules, rule{reason: reasonCountry, conditions: []condition{newCountryCondition(blockedCountries)}})
+	}
+
+	return rules, nil
+}
+
+// ipBlockRules creates one rule per CIDR, ordered by descending prefix length, so the most specific
+// matching CIDR decides. For CIDRs of the same length, the allowing rule comes first.
+func ipBlockRules(allowed, blocked []*net.IPNet) []rule {
+	var rules []rule
+
+	for _, block := range allowed {
+		rules = append(rules, rule{allow: true, reason: reasonIPBlock, conditions: []condition{ipBlockCondition{block}}})
+	}
+	for _, block := range blocked {
+		rules = append(rules, rule{allow: false, reason: reasonIPBlock, conditions: []condition{ipBlockCondition{block}}})
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		onesI, _ := rules[i].conditions[0].(ipBlockCondition)[0].Mask.Size()
+		onesJ, _ := rules[j].conditions[0].(ipBlockCondition)[0].Mask.Size()
+
+		return onesI > onesJ
+	})
+
+	return rules
+}
+
+// hasListRules indicates whether any of the allowed* or blocked* lists are configured.
+func hasListRules(cfg *Config) bool {
+	return len(cfg.AllowedCountries) > 0 || len(cfg.BlockedCountries) > 0 ||
+		len(cfg.AllowedIPBlocks) > 0 || len(cfg.BlockedIPBlocks) > 0 || len(cfg.AllowedHostnames) > 0 ||
+		len(cfg.AllowedASNs) > 0 || len(cfg.BlockedASNs) > 0 ||
+		len(cfg.AllowedISPs) > 0 || len(cfg.BlockedISPs) > 0 ||
+		len(cfg.AllowedUsageTypes) > 0 || len(cfg.BlockedUsageTypes) > 0 || cfg.UnknownUsageType != "" ||
+		len(cfg.BlockedProxyTypes) > 0 ||
+		len(cfg.AllowedReverseDNS) > 0 || len(cfg.BlockedReverseDNS) > 0 ||
+		len(cfg.AllowedRegions) > 0 || len(cfg.BlockedRegions) > 0
+}
+
+// ruleRequirements describes which lookups a rule list depends on.
+type ruleRequirements struct {
+	region, asn, isp, usageType, proxyType, reverseDNS bool
+}
+
+// requirementsOf determines which lookups the given rules depend on.
+func requirementsOf(rules []rule) ruleRequirements {
+	var req ruleRequirements
+
+	for _, r := range rules {
+		for _, cond := range r.conditions {
+			switch cond := cond.(type) {
+			case regionCondition:
+				req.region = true
+			case asnCondition:
+				req.asn = true
+			case ispCondition:
+				req.isp = true
+			case usageTypeCondition:
+				req.usageType = true
+			case proxyTypeCondition:
+				req.proxyType = true
+			case reverseDNSCondition:
+				req.reverseDNS = true
+			case expressionCondition:
+				req.region = req.region || cond.requirements.region
+				req.asn = req.asn || cond.requirements.asn
+				req.usageType = req.usageType || cond.requirements.usageType
+				req.reverseDNS = req.reverseDNS || cond.requirements.reverseDNS
+			}
+		}
+	}
+
+	return req
+}
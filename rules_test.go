@@ -0,0 +1,194 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInitRules(t *testing.T) {
+	t.Run("Reasons", func(t *testing.T) {
+		rules, err := initRules([]RuleConfig{
+			{Name: "office", Action: "allow", IPBlocks: []string{"10.0.0.0/8"}},
+			{Action: "Block"},
+		}, nil)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if len(rules) != 2 {
+			t.Fatalf("expected 2 rules, but got: %d", len(rules))
+		}
+		if !rules[0].allow || rules[0].reason != "rule:office" {
+			t.Errorf("unexpected first rule: %+v", rules[0])
+		}
+		if rules[1].allow || rules[1].reason != "rule:#2" || len(rules[1].conditions) != 0 {
+			t.Errorf("unexpected second rule: %+v", rules[1])
+		}
+	})
+
+	for name, cfg := range map[string]RuleConfig{
+		"InvalidAction":  {Action: "deny"},
+		"InvalidCountry": {Action: "allow", Countries: []string{"UK"}},
+		"InvalidCIDR":    {Action: "allow", IPBlocks: []string{"10.0.0.0/33"}},
+		"InvalidHeader":  {Action: "allow", Headers: map[string]string{"X-Foo": "[a"}},
+		"RelativePath":   {Action: "allow", Paths: []string{"api/"}},
+		"InvalidPath":    {Action: "allow", Paths: []string{"/api/[a"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := initRules([]RuleConfig{cfg}, nil); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestIPBlockRules(t *testing.T) {
+	allowed, _ := initIPBlocks([]string{"8.8.8.0/24", "8.8.8.8/32"})
+	blocked, _ := initIPBlocks([]string{"8.0.0.0/8", "8.8.8.0/24"})
+
+	rules := ipBlockRules(allowed, blocked)
+
+	expected := []struct {
+		cidr  string
+		allow bool
+	}{
+		{"8.8.8.8/32", true},
+		{"8.8.8.0/24", true},
+		{"8.8.8.0/24", false},
+		{"8.0.0.0/8", false},
+	}
+	if len(rules) != len(expected) {
+		t.Fatalf("expected %d rules, but got: %d", len(expected), len(rules))
+	}
+	for i, e := range expected {
+		block := rules[i].conditions[0].(ipBlockCondition)[0]
+		if block.String() != e.cidr || rules[i].allow != e.allow {
+			t.Errorf("expected rule %d to be %s (allow: %t), but got: %s (allow: %t)", i, e.cidr, e.allow, block, rules[i].allow)
+		}
+	}
+}
+
+func TestMatchesPathPattern(t *testing.T) {
+	for _, test := range []struct {
+		pattern, path string
+		expected      bool
+	}{
+		{"/api/", "/api/users", true},
+		{"/api/", "/apiv2", false},
+		{"/api/*/admin", "/api/v1/admin", true},
+		{"/api/*/admin", "/api/v1/v2/admin", false},
+		{"/*.php", "/index.php", true},
+		{"/partner", "/partner", true},
+		{"/partner", "/partner/orders", true},
+		{"/partner", "/partner-admin", false},
+		{"/partner", "/partner/../admin", false},
+		{"/partner", "//partner/orders", true},
+		{"/api/", "/api", true},
+		{"/", "/anything", true},
+	} {
+		if actual := matchesPathPattern(test.pattern, cleanPath(test.path)); actual != test.expected {
+			t.Errorf("expected %q to match %q: %t, but got: %t", test.pattern, test.path, test.expected, actual)
+		}
+	}
+}
+
+func TestPlugin_ServeHTTP_Rules(t *testing.T) {
+	cfg := &Config{
+		Enabled:          true,
+		DatabaseFilePath: dbFilePath,
+		Rules: []RuleConfig{
+			{Name: "health", Action: "allow", Paths: []string{"/health"}},
+			{Name: "partner", Action: "allow", Countries: []string{"US"}, Headers: map[string]string{"X-Partner": "acme-*"}},
+			{Name: "google", Action: "block", IPBlocks: []string{"8.8.8.0/24"}},
+			{Name: "dach", Action: "allow", Countries: []string{"@DACH", "US"}},
+		},
+		DisallowedStatusCode: http.StatusForbidden,
+	}
+
+	plugin, err := New(context.TODO(), &noopHandler{}, cfg, pluginName)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	for _, test := range []struct {
+		name, ip, path, partner string
+		expectedStatus          int
+	}{
+		{"First matching rule allows", "8.8.8.8", "/health", "", http.StatusTeapot},
+		{"Header rule allows", "8.8.8.8", "/foobar", "acme-1", http.StatusTeapot},
+		{"Header mismatch falls through", "8.8.8.8", "/foobar", "other", http.StatusForbidden},
+		{"Country rule allows", "8.8.4.4", "/foobar", "", http.StatusTeapot},
+		{"Group rule allows", "185.5.82.105", "/foobar", "", http.StatusTeapot},
+		{"No rule matches", "77.88.8.8", "/foobar", "", http.StatusForbidden},
+		{"Private blocked by default", "192.168.178.66", "/foobar", "", http.StatusForbidden},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			req.Header.Set("X-Real-IP", test.ip)
+			if test.partner != "" {
+				req.Header.Set("X-Partner", test.partner)
+			}
+
+			rr := httptest.NewRecorder()
+			plugin.ServeHTTP(rr, req)
+
+			if rr.Code != test.expectedStatus {
+				t.Errorf("expected status code %d, but got: %d", test.expectedStatus, rr.Code)
+			}
+		})
+	}
+
+	t.Run("Decision", func(t *testing.T) {
+		decision, err := plugin.(*Plugin).Decide("8.8.8.8")
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		expected := Decision{Allowed: false, IP: "8.8.8.8", Country: "US", Reason: "rule:google"}
+		if decision != expected {
+			t.Errorf("expected decision %+v, but got: %+v", expected, decision)
+		}
+	})
+
+	t.Run("AllowPrivate", func(t *testing.T) {
+		cfg := &Config{
+			Enabled:              true,
+			DatabaseFilePath:     dbFilePath,
+			AllowPrivate:         true,
+			Rules:                []RuleConfig{{Action: "block"}},
+			DisallowedStatusCode: http.StatusForbidden,
+		}
+
+		testRequest(t, "Private allowed before rules", cfg, "192.168.178.66", http.StatusTeapot)
+		testRequest(t, "Public blocked by catch-all", cfg, "8.8.8.8", http.StatusForbidden)
+	})
+}
+
+func TestNew_Rules(t *testing.T) {
+	for name, cfg := range map[string]*Config{
+		"CombinedWithLists": {
+			AllowedCountries: []string{"DE"},
+			Rules:            []RuleConfig{{Action: "allow", Countries: []string{"US"}}},
+		},
+		"ASNWithoutDatabase": {
+			Rules: []RuleConfig{{Action: "block", ASNs: []string{"AS15169"}}},
+		},
+		"RegionUnsupportedDatabase": {
+			Rules: []RuleConfig{{Action: "block", Regions: []string{"UA-43"}}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg.Enabled = true
+			cfg.DatabaseFilePath = dbFilePath
+			cfg.DisallowedStatusCode = http.StatusForbidden
+
+			plugin, err := New(context.TODO(), &noopHandler{}, cfg, pluginName)
+			if err == nil {
+				t.Errorf("expected error, but got none")
+			}
+			if plugin != nil {
+				t.Error("expected plugin to be nil, but is not")
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package traefik_plugin_geoblock
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ScheduleConfig restricts when a rule applies. All configured restrictions must be met.
+type ScheduleConfig struct {
+	Timezone string   // IANA timezone the schedule is defined in (default: UTC)
+	Days     []string // Weekdays the rule applies on (e.g. Sat, Sun or Mon-Fri)
+	Times    []string // Local time ranges the rule applies in (e.g. 09:00-17:00 or 22:00-06:00)
+	Start    string   // Date (2026-11-01) or RFC 3339 timestamp the rule applies from
+	End      string   // Date (2026-11-30, inclusive) or RFC 3339 timestamp the rule applies until
+}
+
+// weekdays maps abbreviated weekday names to weekdays.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// timeRange is a range of minutes since midnight. If end is before start, the range spans midnight.
+type timeRange struct {
+	start, end int
+}
+
+// startDay returns the weekday the range containing the minute of the given day started on, if the minute is
+// within the range. The part of a range after midnight belongs to the day before, e.g. 02:00 on Saturday is
+// within 22:00-06:00 of Friday.
+func (r timeRange) startDay(day time.Weekday, minute int) (time.Weekday, bool) {
+	if r.start <= r.end {
+		return day, minute >= r.start && minute < r.end
+	}
+	if minute >= r.start {
+		return day, true
+	}
+
+	return (day + 6) % 7, minute < r.end
+}
+
+// scheduleCondition matches while the schedule is active. Once the end of the schedule has passed,
+// this is logged once.
+type scheduleCondition struct {
+	rule     string
+	location *time.Location
+	days     map[time.Weekday]struct{}
+	times    []timeRange
+	start    time.Time
+	end      time.Time
+	expired  *sync.Once
+}
+
+// initSchedule compiles the schedule of the given rule.
+func initSchedule(cfg ScheduleConfig, rule string) (scheduleCondition, error) {
+	c := scheduleCondition{rule: rule, location: time.UTC, expired: &sync.Once{}}
+
+	if cfg.Timezone != "" {
+		location, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return c, fmt.Errorf("invalid timezone %q: %w", cfg.Timezone, err)
+		}
+		c.location = location
+	}
+
+	if len(cfg.Days) > 0 {
+		c.days = make(map[time.Weekday]struct{})
+		for _, days := range cfg.Days {
+			if err := addWeekdays(c.days, days); err != nil {
+				return c, err
+			}
+		}
+	}
+
+	for _, times := range cfg.Times {
+		r, err := parseTimeRange(times)
+		if err != nil {
+			return c, err
+		}
+		c.times = append(c.times, r)
+	}
+
+	var err error
+	if cfg.Start != "" {
+		if c.start, err = parseScheduleTime(cfg.Start, c.location, false); err != nil {
+			return c, err
+		}
+	}
+	if cfg.End != "" {
+		if c.end, err = parseScheduleTime(cfg.End, c.location, true); err != nil {
+			return c, err
+		}
+	}
+	if !c.start.IsZero() && !c.end.IsZero() && !c.start.Before(c.end) {
+		return c, fmt.Errorf("schedule start %s must be before its end %s", cfg.Start, cfg.End)
+	}
+
+	return c, nil
+}
+
+// addWeekdays adds a weekday (e.g. Mon) or a range of weekdays (e.g. Mon-Fri or Fri-Mon) to the set.
+func addWeekdays(days map[time.Weekday]struct{}, value string) error {
+	from, to, isRange := strings.Cut(strings.ToLower(strings.TrimSpace(value)), "-")
+	if !isRange {
+		to = from
+	}
+
+	first, ok := weekdays[strings.TrimSpace(from)]
+	if !ok {
+		return fmt.Errorf("invalid weekday %q, must be one of Mon, Tue, Wed, Thu, Fri, Sat or Sun", value)
+	}
+	last, ok := weekdays[strings.TrimSpace(to)]
+	if !ok {
+		return fmt.Errorf("invalid weekday %q, must be one of Mon, Tue, Wed, Thu, Fri, Sat or Sun", value)
+	}
+
+	for day := first; ; day = (day + 1) % 7 {
+		days[day] = struct{}{}
+		if day == last {
+			return nil
+		}
+	}
+}
+
+// parseTimeRange parses a time range like 09:00-17:00. The end is exclusive and may be 24:00.
+func parseTimeRange(value string) (timeRange, error) {
+	from, to, ok := strings.Cut(strings.TrimSpace(value), "-")
+	if !ok {
+		return timeRange{}, fmt.Errorf("invalid time range %q, must be like 09:00-17:00", value)
+	}
+
+	start, err := parseTimeOfDay(strings.TrimSpace(from))
+	if err != nil || start == 24*60 {
+		return timeRange{}, fmt.Errorf("invalid time range %q, must be like 09:00-17:00", value)
+	}
+	end, err := parseTimeOfDay(strings.TrimSpace(to))
+	if err != nil || start == end {
+		return timeRange{}, fmt.Errorf("invalid time range %q, must be like 09:00-17:00", value)
+	}
+
+	return timeRange{start: start, end: end % (24 * 60)}, nil
+}
+
+// parseTimeOfDay parses a time like 09:30 into minutes since midnight.
+func parseTimeOfDay(value string) (int, error) {
+	hours, minutes, ok := strings.Cut(value, ":")
+	if !ok || len(hours) != 2 || len(minutes) != 2 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+
+	h, err := strconv.Atoi(hours)
+	if err != nil || h < 0 || h > 24 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+
+	return h*60 + m, nil
+}
+
+// parseScheduleTime parses a date in the given location or an RFC 3339 timestamp. If end is set,
+// dates are inclusive, i.e. they end at midnight of the following day.
+func parseScheduleTime(value string, location *time.Location, end bool) (time.Time, error) {
+	if date, err := time.ParseInLocation("2006-01-02", value, location); err == nil {
+		if end {
+			return date.AddDate(0, 0, 1), nil
+		}
+		return date, nil
+	}
+	if timestamp, err := time.Parse(time.RFC3339, value); err == nil {
+		return timestamp, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid schedule date %q, must be a date (2006-01-02) or an RFC 3339 timestamp", value)
+}
+
+func (c scheduleCondition) matches(ctx *evalContext) (bool, error) {
+	return c.active(ctx.now, func() { ctx.p.logf("%s expired at %s", c.rule, c.end.Format(time.RFC3339)) }), nil
+}
+
+// active indicates whether the schedule is active at the given time. If its end has passed, expired is called once.
+func (c scheduleCondition) active(now time.Time, expired func()) bool {
+	if !c.start.IsZero() && now.Before(c.start) {
+		return false
+	}
+	if !c.end.IsZero() && !now.Before(c.end) {
+		c.expired.Do(expired)
+		return false
+	}
+
+	local := now.In(c.location)
+	if len(c.times) == 0 {
+		return c.onDay(local.Weekday())
+	}
+
+	minute := local.Hour()*60 + local.Minute()
+	for _, r := range c.times {
+		if day, ok := r.startDay(local.Weekday(), minute); ok && c.onDay(day) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// onDay indicates whether the schedule applies on the weekday.
+func (c scheduleCondition) onDay(day time.Weekday) bool {
+	if c.days == nil {
+		return true
+	}
+	_, ok := c.days[day]
+
+	return ok
+}
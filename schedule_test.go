@@ -0,0 +1,145 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestInitSchedule_Errors(t *testing.T) {
+	for name, cfg := range map[string]ScheduleConfig{
+		"InvalidTimezone":  {Timezone: "Europe/Atlantis"},
+		"InvalidWeekday":   {Days: []string{"Monday"}},
+		"InvalidDayRange":  {Days: []string{"Mon-Fry"}},
+		"InvalidTimeRange": {Times: []string{"09:00"}},
+		"InvalidTime":      {Times: []string{"9:00-17:00"}},
+		"InvalidHour":      {Times: []string{"09:00-25:00"}},
+		"InvalidMinute":    {Times: []string{"09:60-17:00"}},
+		"EmptyTimeRange":   {Times: []string{"09:00-09:00"}},
+		"StartAt24":        {Times: []string{"24:00-06:00"}},
+		"InvalidStart":     {Start: "01.11.2026"},
+		"InvalidEnd":       {End: "tomorrow"},
+		"EndBeforeStart":   {Start: "2026-11-30", End: "2026-11-01"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := initSchedule(cfg, "rule:test"); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestScheduleCondition_Active(t *testing.T) {
+	schedule, err := initSchedule(ScheduleConfig{
+		Timezone: "Europe/Berlin",
+		Days:     []string{"Mon-Fri", "sun"},
+		Times:    []string{"09:00-17:00", "22:00-06:00"},
+		Start:    "2026-11-01",
+		End:      "2026-11-30",
+	}, "rule:test")
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	at := func(value string) time.Time {
+		ts, err := time.ParseInLocation("2006-01-02 15:04", value, berlin)
+		if err != nil {
+			t.Fatalf("failed to parse time: %v", err)
+		}
+		return ts
+	}
+
+	for _, test := range []struct {
+		name     string
+		now      time.Time
+		expected bool
+	}{
+		{"BeforeStart", at("2026-10-30 10:00"), false},
+		{"MondayBusinessHours", at("2026-11-02 10:00"), true},
+		{"MondayEvening", at("2026-11-02 18:00"), false},
+		{"MondayNight", at("2026-11-02 23:30"), true},
+		{"TuesdayEarlyMorning", at("2026-11-03 05:59"), true},
+		{"EndOfBusinessHours", at("2026-11-03 17:00"), false},
+		{"Saturday", at("2026-11-07 10:00"), false},
+		{"SaturdayEarlyMorningAfterFridayNight", at("2026-11-07 02:00"), true},
+		{"SaturdayNight", at("2026-11-07 23:00"), false},
+		{"SundayEarlyMorningAfterSaturdayNight", at("2026-11-08 02:00"), false},
+		{"Sunday", at("2026-11-08 10:00"), true},
+		{"UTCInBusinessHours", time.Date(2026, 11, 2, 8, 30, 0, 0, time.UTC), true},
+		{"LastDay", at("2026-11-30 16:59"), true},
+		{"AfterEnd", at("2026-12-01 10:00"), false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := schedule.active(test.now, func() {}); actual != test.expected {
+				t.Errorf("expected %t, but got: %t", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestScheduleCondition_ActiveOvernight(t *testing.T) {
+	schedule, err := initSchedule(ScheduleConfig{Days: []string{"Fri"}, Times: []string{"22:00-06:00"}}, "rule:test")
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	// NB: the part of the range after midnight belongs to the day the range started on.
+	for _, test := range []struct {
+		name     string
+		now      time.Time
+		expected bool
+	}{
+		{"FridayEarlyMorning", time.Date(2026, 11, 6, 2, 0, 0, 0, time.UTC), false},
+		{"FridayNight", time.Date(2026, 11, 6, 23, 0, 0, 0, time.UTC), true},
+		{"SaturdayEarlyMorning", time.Date(2026, 11, 7, 2, 0, 0, 0, time.UTC), true},
+		{"SaturdayMorning", time.Date(2026, 11, 7, 6, 0, 0, 0, time.UTC), false},
+		{"SaturdayNight", time.Date(2026, 11, 7, 23, 0, 0, 0, time.UTC), false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := schedule.active(test.now, func() {}); actual != test.expected {
+				t.Errorf("expected %t, but got: %t", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestScheduleCondition_Expiry(t *testing.T) {
+	schedule, err := initSchedule(ScheduleConfig{End: "2026-11-30T12:00:00Z"}, "rule:test")
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	expired := 0
+	end := time.Date(2026, 11, 30, 12, 0, 0, 0, time.UTC)
+	for _, now := range []time.Time{end.Add(-time.Second), end, end.Add(time.Hour), end.Add(48 * time.Hour)} {
+		schedule.active(now, func() { expired++ })
+	}
+
+	if expired != 1 {
+		t.Errorf("expected expiry to be noticed once, but got: %d", expired)
+	}
+}
+
+func TestPlugin_ServeHTTP_Schedules(t *testing.T) {
+	cfg := &Config{
+		Enabled:          true,
+		DatabaseFilePath: dbFilePath,
+		Rules: []RuleConfig{
+			{Name: "expired", Action: "allow", Countries: []string{"RU"}, Schedule: &ScheduleConfig{End: "2020-01-31"}},
+			{Name: "upcoming", Action: "allow", Countries: []string{"DE"}, Schedule: &ScheduleConfig{Start: "2999-01-01"}},
+			{Name: "always", Action: "allow", Countries: []string{"US"}, Schedule: &ScheduleConfig{Days: []string{"Mon-Sun"}}},
+		},
+		DisallowedStatusCode: http.StatusForbidden,
+	}
+
+	testRequest(t, "Expired", cfg, "77.88.8.8", http.StatusForbidden)
+	testRequest(t, "Upcoming", cfg, "185.5.82.105", http.StatusForbidden)
+	testRequest(t, "Active", cfg, "8.8.8.8", http.StatusTeapot)
+
+	cfg.Rules = []RuleConfig{{Name: "invalid", Action: "allow", Schedule: &ScheduleConfig{Timezone: "Mars/Olympus_Mons"}}}
+	if _, err := New(context.TODO(), &noopHandler{}, cfg, pluginName); err == nil {
+		t.Error("expected error for invalid schedule, but got none")
+	}
+}
@@ -0,0 +1,82 @@
+package traefik_plugin_geoblock
+
+// shadowPolicyName is included in decisions of the shadow policy.
+const shadowPolicyName = "shadow"
+
+// ShadowPolicyConfig defines a candidate policy that is evaluated alongside the global policy without being enforced.
+type ShadowPolicyConfig struct {
+	AllowedCountries  []string     // Whitelist of countries to allow (ISO 3166-1 codes or @groups)
+	BlockedCountries  []string     // Blocklist of countries to be blocked (ISO 3166-1 codes or @groups)
+	DefaultAllow      bool         // If source matches neither blocklist nor whitelist, should it be allowed through?
+	AllowedIPBlocks   []string     // List of whitelist CIDR
+	BlockedIPBlocks   []string     // List of blocklisted CIDRs
+	AllowedHostnames  []string     // Whitelist of hostnames (e.g. dynamic DNS names), resolved periodically
+	AllowedASNs       []string     // Whitelist of autonomous system numbers (e.g. AS15169)
+	BlockedASNs       []string     // Blocklist of autonomous system numbers
+	AllowedISPs       []string     // Whitelist of ISP / AS organization name patterns (e.g. *telekom*)
+	BlockedISPs       []string     // Blocklist of ISP / AS organization name patterns
+	AllowedUsageTypes []string     // Whitelist of usage types (e.g. MOB, ISP)
+	BlockedUsageTypes []string     // Blocklist of usage types (e.g. DCH, SES)
+	UnknownUsageType  string       // Action for IPs with missing or unrecognized usage type: "ignore" (default), "allow" or "block"
+	BlockedProxyTypes []string     // Blocklist of proxy types (e.g. VPN, TOR, PUB)
+	AllowedRegions    []string     // Whitelist of regions (ISO 3166-2 codes or "CC:Region name")
+	BlockedRegions    []string     // Blocklist of regions (ISO 3166-2 codes or "CC:Region name")
+	AllowedReverseDNS []string     // Whitelist of forward-confirmed hostnames (e.g. example.com or *.example.com)
+	BlockedReverseDNS []string     // Blocklist of forward-confirmed hostnames (e.g. *.compute.amazonaws.com)
+	Rules             []RuleConfig // Ordered list of rules, the first matching rule decides (replaces the allowed / blocked lists)
+}
+
+// initShadowPolicy compiles the shadow policy. Private networks are handled like in the global policy, and allowed
+// hostnames are resolved with the global DNS settings.
+func initShadowPolicy(cfg *ShadowPolicyConfig, global *Config, countryGroups map[string][]string,
+	logf func(format string, args ...interface{}),
+) (*policy, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	return initOverridePolicy(shadowPolicyName, &Config{
+		AllowedCountries:        cfg.AllowedCountries,
+		BlockedCountries:        cfg.BlockedCountries,
+		DefaultAllow:            cfg.DefaultAllow,
+		AllowPrivate:            global.AllowPrivate,
+		AllowedIPBlocks:         cfg.AllowedIPBlocks,
+		BlockedIPBlocks:         cfg.BlockedIPBlocks,
+		AllowedHostnames:        cfg.AllowedHostnames,
+		HostnameRefreshInterval: global.HostnameRefreshInterval,
+		DNSServer:               global.DNSServer,
+		DNSTimeout:              global.DNSTimeout,
+		AllowedASNs:             cfg.AllowedASNs,
+		BlockedASNs:             cfg.BlockedASNs,
+		AllowedISPs:             cfg.AllowedISPs,
+		BlockedISPs:             cfg.BlockedISPs,
+		AllowedUsageTypes:       cfg.AllowedUsageTypes,
+		BlockedUsageTypes:       cfg.BlockedUsageTypes,
+		UnknownUsageType:        cfg.UnknownUsageType,
+		BlockedProxyTypes:       cfg.BlockedProxyTypes,
+		AllowedRegions:          cfg.AllowedRegions,
+		BlockedRegions:          cfg.BlockedRegions,
+		AllowedReverseDNS:       cfg.AllowedReverseDNS,
+		BlockedReverseDNS:       cfg.BlockedReverseDNS,
+		Rules:                   cfg.Rules,
+	}, 0, countryGroups, logf)
+}
+
+// ShadowDisagreements returns the number of decisions the shadow policy disagreed with, by country.
+func (p Plugin) ShadowDisagreements() map[string]uint64 {
+	if p.shadowDisagreements == nil {
+		return map[string]uint64{}
+	}
+
+	return p.shadowDisagreements.counts()
+}
+
+// ShadowDisagreementsByDecider returns the number of decisions the shadow policy disagreed with, by what the real
+// decision was based on, e.g. "global", "path:/admin" or "bypass".
+func (p Plugin) ShadowDisagreementsByDecider() map[string]uint64 {
+	if p.shadowDisagreementsBy == nil {
+		return map[string]uint64{}
+	}
+
+	return p.shadowDisagreementsBy.counts()
+}
@@ -0,0 +1,188 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPlugin_ShadowPolicy(t *testing.T) {
+	newPlugin := func(t *testing.T) *Plugin {
+		plugin, err := New(context.TODO(), &noopHandler{}, &Config{
+			Enabled:              true,
+			DatabaseFilePath:     dbFilePath,
+			AllowedCountries:     []string{"US", "DE"},
+			AllowPrivate:         true,
+			DisallowedStatusCode: http.StatusForbidden,
+			Paths: []PathPolicyConfig{
+				{Path: "/public", DefaultAllow: true},
+			},
+			ShadowPolicy: &ShadowPolicyConfig{
+				AllowedCountries: []string{"US", "RU"},
+			},
+		}, pluginName)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+
+		return plugin.(*Plugin)
+	}
+
+	t.Run("Decisions", func(t *testing.T) {
+		plugin := newPlugin(t)
+
+		for _, test := range []struct {
+			ip            string
+			path          string
+			allowed       bool
+			shadowAllowed bool
+		}{
+			{"8.8.8.8", "/", true, true},
+			{"185.5.82.105", "/", true, false},
+			{"77.88.8.8", "/", false, true},
+			{"192.168.178.66", "/", true, true},
+			{"185.5.82.105", "/public", true, false},
+		} {
+			t.Run(test.ip+test.path, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodGet, test.path, nil)
+
+				decision, shadow, err := plugin.decideRequest(req, test.ip)
+				if err != nil {
+					t.Fatalf("expected no error, but got: %v", err)
+				}
+				if decision.Allowed != test.allowed {
+					t.Errorf("expected allowed to be %t, but got: %s", test.allowed, decision)
+				}
+				if decision.Policy == shadowPolicyName {
+					t.Errorf("expected decision of the enforced policy, but got: %s", decision)
+				}
+
+				if shadow == nil {
+					t.Fatal("expected shadow decision, but got none")
+				}
+				if shadow.Allowed != test.shadowAllowed {
+					t.Errorf("expected shadow allowed to be %t, but got: %s", test.shadowAllowed, shadow)
+				}
+				if shadow.Policy != shadowPolicyName {
+					t.Errorf("expected shadow policy, but got: %s", shadow)
+				}
+			})
+		}
+	})
+
+	t.Run("Disagreements", func(t *testing.T) {
+		plugin := newPlugin(t)
+
+		for _, ip := range []string{"8.8.8.8", "185.5.82.105", "185.5.82.105", "77.88.8.8"} {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Real-IP", ip)
+
+			rr := httptest.NewRecorder()
+			plugin.ServeHTTP(rr, req)
+		}
+
+		counts := plugin.ShadowDisagreements()
+		if counts["DE"] != 2 || counts["RU"] != 1 || len(counts) != 2 {
+			t.Errorf("expected DE=2 and RU=1, but got: %v", counts)
+		}
+		if s := plugin.shadowDisagreements.String(); s != "DE=2 RU=1" {
+			t.Errorf("expected %q, but got: %q", "DE=2 RU=1", s)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/public", nil)
+		req.Header.Set("X-Real-IP", "185.5.82.105")
+		plugin.ServeHTTP(httptest.NewRecorder(), req)
+
+		byDecider := plugin.ShadowDisagreementsByDecider()
+		if byDecider["global"] != 3 || byDecider["path:/public"] != 1 || len(byDecider) != 2 {
+			t.Errorf("expected global=3 and path:/public=1, but got: %v", byDecider)
+		}
+	})
+
+	t.Run("Exemption", func(t *testing.T) {
+		plugin, err := New(context.TODO(), &noopHandler{}, &Config{
+			Enabled:              true,
+			DatabaseFilePath:     dbFilePath,
+			AllowedCountries:     []string{"US"},
+			DisallowedStatusCode: http.StatusForbidden,
+			PreflightMode:        preflightModeAllow,
+			ShadowPolicy:         &ShadowPolicyConfig{AllowedCountries: []string{"US"}},
+		}, pluginName)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodOptions, "/", nil)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+
+		decision, shadow, err := plugin.(*Plugin).decideRequest(req, "185.5.82.105")
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if !decision.Allowed || decision.decidedBy() != reasonPreflight {
+			t.Errorf("expected request to be allowed as preflight, but got: %s", decision)
+		}
+		if shadow == nil || shadow.Allowed || shadow.Policy != shadowPolicyName || shadow.Reason != reasonDefault {
+			t.Errorf("expected request to be blocked by the shadow policy, but got: %v", shadow)
+		}
+	})
+
+	t.Run("AllowedHostnames", func(t *testing.T) {
+		server := startTestDNSServer(t, map[string][]string{
+			"admin.dyndns.example.net.": {"77.88.8.8"},
+		})
+
+		plugin, err := New(context.TODO(), &noopHandler{}, &Config{
+			Enabled:              true,
+			DatabaseFilePath:     dbFilePath,
+			AllowedCountries:     []string{"US"},
+			DNSServer:            server.addr,
+			DisallowedStatusCode: http.StatusForbidden,
+			ShadowPolicy: &ShadowPolicyConfig{
+				AllowedCountries: []string{"US"},
+				AllowedHostnames: []string{"admin.dyndns.example.net"},
+			},
+		}, pluginName)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+
+		decision, shadow, err := plugin.(*Plugin).decideRequest(httptest.NewRequest(http.MethodGet, "/", nil), "77.88.8.8")
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if decision.Allowed {
+			t.Errorf("expected request to be blocked, but got: %s", decision)
+		}
+		if shadow == nil || !shadow.Allowed || shadow.Reason != reasonHostname {
+			t.Errorf("expected request to be allowed by the shadow policy's hostname, but got: %v", shadow)
+		}
+	})
+
+	t.Run("NotEnforced", func(t *testing.T) {
+		plugin := newPlugin(t)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-IP", "77.88.8.8")
+
+		rr := httptest.NewRecorder()
+		plugin.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, but got: %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := New(context.TODO(), &noopHandler{}, &Config{
+			Enabled:              true,
+			DatabaseFilePath:     dbFilePath,
+			DisallowedStatusCode: http.StatusForbidden,
+			ShadowPolicy:         &ShadowPolicyConfig{AllowedCountries: []string{"XX"}},
+		}, pluginName)
+		if err == nil {
+			t.Error("expected error, but got none")
+		}
+	})
+}
@@ -0,0 +1,259 @@
+package traefik_plugin_geoblock
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reasonBypassTokenPrefix is followed by the ID of the bypass token that allowed a request, e.g. "bypass-token:jdoe".
+const reasonBypassTokenPrefix = "bypass-token:"
+
+// Defaults for bypass tokens.
+const (
+	defaultBypassTokenParam     = "geoblock_token"
+	defaultBypassCookieName     = "geoblock_bypass"
+	defaultBypassCookieLifetime = 24 * time.Hour
+	minBypassTokenSecretLength  = 32
+)
+
+// Purposes bypass tokens are signed for, so tokens can't be used as cookies and vice versa.
+const (
+	tokenPurposeToken  = "token"
+	tokenPurposeCookie = "cookie"
+)
+
+// BypassTokenConfig configures signed, time-limited bypass tokens.
+type BypassTokenConfig struct {
+	Secrets        []string // Shared secrets to verify tokens with, the first one is used for signing cookies
+	Param          string   // Query parameter carrying the token (default: geoblock_token)
+	CookieName     string   // Name of the cookie set for valid tokens (default: geoblock_bypass)
+	CookieLifetime string   // Maximum lifetime of the cookie, it never outlives the token (default: 24h)
+}
+
+// bypassTokens verifies bypass tokens and the cookies set for them.
+type bypassTokens struct {
+	secrets        [][]byte
+	param          string
+	cookieName     string
+	cookieLifetime time.Duration
+}
+
+// initBypassTokens validates the bypass token configuration.
+func initBypassTokens(cfg *BypassTokenConfig) (*bypassTokens, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if len(cfg.Secrets) == 0 {
+		return nil, errors.New("no secrets configured")
+	}
+
+	t := &bypassTokens{
+		param:          defaultBypassTokenParam,
+		cookieName:     defaultBypassCookieName,
+		cookieLifetime: defaultBypassCookieLifetime,
+	}
+
+	for i, secret := range cfg.Secrets {
+		if len(secret) < minBypassTokenSecretLength {
+			return nil, fmt.Errorf("secret #%d must be at least %d characters long", i+1, minBypassTokenSecretLength)
+		}
+		t.secrets = append(t.secrets, []byte(secret))
+	}
+
+	if cfg.Param != "" {
+		t.param = cfg.Param
+	}
+	if cfg.CookieName != "" {
+		if !isValidCookieName(cfg.CookieName) {
+			return nil, fmt.Errorf("invalid cookie name %q", cfg.CookieName)
+		}
+		t.cookieName = cfg.CookieName
+	}
+	if cfg.CookieLifetime != "" {
+		d, err := time.ParseDuration(cfg.CookieLifetime)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid cookie lifetime %q", cfg.CookieLifetime)
+		}
+		t.cookieLifetime = d
+	}
+
+	return t, nil
+}
+
+// isValidCookieName indicates whether the name is a valid cookie name (an RFC 7230 token).
+func isValidCookieName(name string) bool {
+	return name != "" && strings.IndexFunc(name, func(r rune) bool {
+		return r <= ' ' || r >= 0x7f || strings.ContainsRune(`()<>@,;:\"/[]?={}`, r)
+	}) < 0
+}
+
+// MintBypassToken creates a bypass token for the given ID, signed with the given secret and valid until expires.
+func MintBypassToken(secret, id string, expires time.Time) (string, error) {
+	if len(secret) < minBypassTokenSecretLength {
+		return "", fmt.Errorf("secret must be at least %d characters long", minBypassTokenSecretLength)
+	}
+	if !isValidTokenID(id) {
+		return "", fmt.Errorf("invalid id %q, only letters, digits, - and _ are allowed", id)
+	}
+
+	return signToken([]byte(secret), tokenPurposeToken, id, expires), nil
+}
+
+// isValidTokenID indicates whether the ID only consists of letters, digits, - and _.
+func isValidTokenID(id string) bool {
+	return id != "" && strings.IndexFunc(id, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+	}) < 0
+}
+
+// signToken creates a token of the form <id>.<expiry as unix timestamp>.<signature>.
+func signToken(secret []byte, purpose, id string, expires time.Time) string {
+	payload := id + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + tokenSignature(secret, purpose, payload)
+}
+
+// tokenSignature computes the HMAC-SHA256 signature of a token payload for the given purpose.
+func tokenSignature(secret []byte, purpose, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose + "." + payload))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the ID and expiry of a token signed for the given purpose with any of the secrets,
+// if it didn't expire yet.
+func (t *bypassTokens) verify(token, purpose string, now time.Time) (string, time.Time, bool) {
+	idx := strings.LastIndexByte(token, '.')
+	if idx < 0 {
+		return "", time.Time{}, false
+	}
+	payload, signature := token[:idx], token[idx+1:]
+
+	valid := false
+	for _, secret := range t.secrets {
+		if hmac.Equal([]byte(signature), []byte(tokenSignature(secret, purpose, payload))) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return "", time.Time{}, false
+	}
+
+	id, expiry, ok := strings.Cut(payload, ".")
+	if !ok || !isValidTokenID(id) {
+		return "", time.Time{}, false
+	}
+	seconds, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	expires := time.Unix(seconds, 0)
+	if !now.Before(expires) {
+		return "", time.Time{}, false
+	}
+
+	return id, expires, true
+}
+
+// match returns the ID of a valid token in the query parameter or cookie of the request, if any.
+func (t *bypassTokens) match(req *http.Request, now time.Time) (string, bool) {
+	if t == nil || req == nil {
+		return "", false
+	}
+
+	if token := req.URL.Query().Get(t.param); token != "" {
+		if id, _, ok := t.verify(token, tokenPurposeToken, now); ok {
+			return id, true
+		}
+	}
+
+	if cookie, err := req.Cookie(t.cookieName); err == nil {
+		if id, _, ok := t.verify(cookie.Value, tokenPurposeCookie, now); ok {
+			return id, true
+		}
+	}
+
+	return "", false
+}
+
+// exchange sets a signed cookie if the request carries a valid token in the query parameter, so later requests
+// don't need it anymore. Both the token and the cookie are removed from the request before it is passed on.
+func (t *bypassTokens) exchange(rw http.ResponseWriter, req *http.Request, now time.Time) {
+	query := req.URL.Query()
+	if token := query.Get(t.param); token != "" {
+		if id, expires, ok := t.verify(token, tokenPurposeToken, now); ok {
+			if lifetime := now.Add(t.cookieLifetime); lifetime.Before(expires) {
+				expires = lifetime
+			}
+
+			// NB: cookies are always signed with the first secret, so rotated secrets can be removed eventually.
+			http.SetCookie(rw, &http.Cookie{
+				Name:     t.cookieName,
+				Value:    signToken(t.secrets[0], tokenPurposeCookie, id, expires),
+				Path:     "/",
+				Expires:  expires,
+				MaxAge:   int(expires.Sub(now).Seconds()),
+				Secure:   req.TLS != nil || strings.EqualFold(req.Header.Get("X-Forwarded-Proto"), "https"),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		req.URL.RawQuery = removeQueryParam(req.URL.RawQuery, t.param)
+		req.RequestURI = req.URL.RequestURI()
+	}
+
+	if _, err := req.Cookie(t.cookieName); err == nil {
+		removeCookie(req.Header, t.cookieName)
+	}
+}
+
+// removeQueryParam removes all values of the parameter from the raw query. All other parameters are kept as they are,
+// neither reordered nor escaped differently, so the backend sees the same query apart from the parameter.
+func removeQueryParam(rawQuery, name string) string {
+	params := strings.Split(rawQuery, "&")
+	kept := params[:0]
+	for _, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil && unescaped == name {
+			continue
+		}
+		kept = append(kept, param)
+	}
+
+	return strings.Join(kept, "&")
+}
+
+// removeCookie removes the cookie from the Cookie headers. All other cookies are kept as they are, so the backend
+// sees the same values apart from the cookie. Headers without any other cookies are removed.
+func removeCookie(header http.Header, name string) {
+	var values []string
+	for _, value := range header.Values("Cookie") {
+		cookies := strings.Split(value, ";")
+		kept := cookies[:0]
+		for _, cookie := range cookies {
+			if cookieName, _, _ := strings.Cut(cookie, "="); strings.TrimSpace(cookieName) == name {
+				continue
+			}
+			kept = append(kept, cookie)
+		}
+
+		if value = strings.TrimLeft(strings.Join(kept, ";"), " "); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	header.Del("Cookie")
+	for _, value := range values {
+		header.Add("Cookie", value)
+	}
+}
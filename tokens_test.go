@@ -0,0 +1,217 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testTokenSecret    = "0123456789abcdef0123456789abcdef"
+	testOldTokenSecret = "fedcba9876543210fedcba9876543210"
+)
+
+func TestInitBypassTokens_Errors(t *testing.T) {
+	for name, cfg := range map[string]*BypassTokenConfig{
+		"NoSecrets":       {},
+		"ShortSecret":     {Secrets: []string{testTokenSecret, "secret"}},
+		"InvalidCookie":   {Secrets: []string{testTokenSecret}, CookieName: "geoblock bypass"},
+		"InvalidLifetime": {Secrets: []string{testTokenSecret}, CookieLifetime: "1d"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := initBypassTokens(cfg); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestMintBypassToken(t *testing.T) {
+	if _, err := MintBypassToken("secret", "jdoe", time.Now()); err == nil {
+		t.Error("expected error for short secret, but got none")
+	}
+	if _, err := MintBypassToken(testTokenSecret, "j.doe", time.Now()); err == nil {
+		t.Error("expected error for invalid id, but got none")
+	}
+}
+
+func TestBypassTokens_Verify(t *testing.T) {
+	tokens, err := initBypassTokens(&BypassTokenConfig{Secrets: []string{testTokenSecret, testOldTokenSecret}})
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	now := time.Now()
+	mint := func(secret string, expires time.Time) string {
+		token, err := MintBypassToken(secret, "jdoe", expires)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		return token
+	}
+
+	for _, test := range []struct {
+		name, token, purpose string
+		valid                bool
+	}{
+		{"Valid", mint(testTokenSecret, now.Add(time.Hour)), tokenPurposeToken, true},
+		{"RotatedSecret", mint(testOldTokenSecret, now.Add(time.Hour)), tokenPurposeToken, true},
+		{"UnknownSecret", mint("ffffffffffffffffffffffffffffffff", now.Add(time.Hour)), tokenPurposeToken, false},
+		{"Expired", mint(testTokenSecret, now.Add(-time.Second)), tokenPurposeToken, false},
+		{"OtherPurpose", mint(testTokenSecret, now.Add(time.Hour)), tokenPurposeCookie, false},
+		{"TamperedID", "jdoe2" + strings.TrimPrefix(mint(testTokenSecret, now.Add(time.Hour)), "jdoe"), tokenPurposeToken, false},
+		{"Malformed", "jdoe", tokenPurposeToken, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			id, _, ok := tokens.verify(test.token, test.purpose, now)
+			if ok != test.valid || (ok && id != "jdoe") {
+				t.Errorf("expected valid=%t, but got: %t (%q)", test.valid, ok, id)
+			}
+		})
+	}
+}
+
+func TestRemoveQueryParam(t *testing.T) {
+	for rawQuery, expected := range map[string]string{
+		"geoblock_token=x":                      "",
+		"b=2&geoblock_token=x&a=1":              "b=2&a=1",
+		"geoblock_token=x&geoblock_token=y&a=1": "a=1",
+		"geoblock%5Ftoken=x&a=%7e1":             "a=%7e1",
+		"a=1&&geoblock_token&c":                 "a=1&&c",
+		"geoblock_tokens=x":                     "geoblock_tokens=x",
+	} {
+		if actual := removeQueryParam(rawQuery, "geoblock_token"); actual != expected {
+			t.Errorf("expected %q for %q, but got: %q", expected, rawQuery, actual)
+		}
+	}
+}
+
+func TestRemoveCookie(t *testing.T) {
+	for name, test := range map[string]struct {
+		cookies, expected []string
+	}{
+		"Only":     {[]string{"geoblock_bypass=x"}, nil},
+		"First":    {[]string{"geoblock_bypass=x; session=abc"}, []string{"session=abc"}},
+		"Middle":   {[]string{`a="1 2"; geoblock_bypass=x; b=%7e`}, []string{`a="1 2"; b=%7e`}},
+		"Headers":  {[]string{"geoblock_bypass=x", "a=1;b=2"}, []string{"a=1;b=2"}},
+		"Prefixed": {[]string{"geoblock_bypass_old=x"}, []string{"geoblock_bypass_old=x"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			header := http.Header{"Cookie": test.cookies}
+			removeCookie(header, "geoblock_bypass")
+			if actual := header.Values("Cookie"); !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %q, but got: %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPlugin_ServeHTTP_BypassTokens(t *testing.T) {
+	var forwarded *http.Request
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwarded = req
+		rw.WriteHeader(http.StatusTeapot)
+	})
+
+	plugin, err := New(context.TODO(), next, &Config{
+		Enabled:          true,
+		DatabaseFilePath: dbFilePath,
+		AllowedCountries: []string{"DE"},
+		BypassTokens: &BypassTokenConfig{
+			Secrets:        []string{testTokenSecret, testOldTokenSecret},
+			CookieLifetime: "1h",
+		},
+		DisallowedStatusCode: http.StatusForbidden,
+	}, pluginName)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	token, err := MintBypassToken(testOldTokenSecret, "jdoe", time.Now().Add(48*time.Hour))
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	t.Run("NoToken", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-IP", "8.8.8.8")
+
+		rr := httptest.NewRecorder()
+		plugin.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, but got: %d", http.StatusForbidden, rr.Code)
+		}
+	})
+
+	var cookie *http.Cookie
+	t.Run("Token", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/docs?z=1&page=%7e2&geoblock_token="+token+"&q=a+b", nil)
+		req.Header.Set("X-Real-IP", "8.8.8.8")
+
+		rr := httptest.NewRecorder()
+		plugin.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusTeapot {
+			t.Fatalf("expected status code %d, but got: %d", http.StatusTeapot, rr.Code)
+		}
+		if forwarded.URL.RawQuery != "z=1&page=%7e2&q=a+b" || forwarded.RequestURI != "/docs?z=1&page=%7e2&q=a+b" {
+			t.Errorf("expected token to be removed from the query, but got: %s", forwarded.RequestURI)
+		}
+
+		cookies := rr.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != defaultBypassCookieName {
+			t.Fatalf("expected bypass cookie to be set, but got: %v", cookies)
+		}
+		cookie = cookies[0]
+		if !cookie.HttpOnly || cookie.MaxAge <= 0 || cookie.MaxAge > 3600 {
+			t.Errorf("expected http only cookie with a lifetime of at most 1h, but got: %v", cookie)
+		}
+	})
+
+	t.Run("Cookie", func(t *testing.T) {
+		if cookie == nil {
+			t.Skip("no cookie was set")
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/docs", nil)
+		req.Header.Set("X-Real-IP", "8.8.8.8")
+		req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+		req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+
+		decision, err := plugin.(*Plugin).DecideRequest(req, "8.8.8.8")
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		if !decision.Allowed || decision.Reason != "bypass-token:jdoe" {
+			t.Errorf("expected request to be allowed by bypass token, but got: %+v", decision)
+		}
+
+		rr := httptest.NewRecorder()
+		plugin.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusTeapot {
+			t.Errorf("expected status code %d, but got: %d", http.StatusTeapot, rr.Code)
+		}
+		if forwarded.Header.Get("Cookie") != "session=abc" {
+			t.Errorf("expected bypass cookie to be removed, but got: %q", forwarded.Header.Get("Cookie"))
+		}
+	})
+
+	t.Run("TokenAsCookie", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/docs", nil)
+		req.Header.Set("X-Real-IP", "8.8.8.8")
+		req.AddCookie(&http.Cookie{Name: defaultBypassCookieName, Value: token})
+
+		rr := httptest.NewRecorder()
+		plugin.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("expected status code %d, but got: %d", http.StatusForbidden, rr.Code)
+		}
+	})
+}
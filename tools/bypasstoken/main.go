@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	geoblock "github.com/nscuro/traefik-plugin-geoblock"
+)
+
+func main() {
+	var id, validFor string
+
+	flag.StringVar(&id, "id", "", "ID of the token, recorded in decisions (e.g. jdoe)")
+	flag.StringVar(&validFor, "valid-for", "24h", "Duration the token is valid for")
+	flag.Parse()
+
+	if id == "" {
+		log.Fatalln("no id provided")
+	}
+
+	// NB: the secret is read from the environment, so it doesn't end up in the shell history.
+	secret := os.Getenv("GEOBLOCK_BYPASS_TOKEN_SECRET")
+	if secret == "" {
+		log.Fatalln("no secret provided, set GEOBLOCK_BYPASS_TOKEN_SECRET")
+	}
+
+	duration, err := time.ParseDuration(validFor)
+	if err != nil || duration <= 0 {
+		log.Fatalf("invalid duration %q", validFor)
+	}
+
+	token, err := geoblock.MintBypassToken(secret, id, time.Now().Add(duration))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(token)
+}
@@ -0,0 +1,83 @@
+package traefik_plugin_geoblock
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Actions for IP addresses whose usage type is missing or unrecognized.
+const (
+	unknownUsageTypeIgnore = "ignore"
+	unknownUsageTypeAllow  = "allow"
+	unknownUsageTypeBlock  = "block"
+)
+
+// knownUsageTypes are the usage types reported by ip2location DB24 and later.
+var knownUsageTypes = map[string]string{
+	"COM": "Commercial",
+	"ORG": "Organization",
+	"GOV": "Government",
+	"MIL": "Military",
+	"EDU": "University/College/School",
+	"LIB": "Library",
+	"CDN": "Content Delivery Network",
+	"ISP": "Fixed Line ISP",
+	"MOB": "Mobile ISP",
+	"DCH": "Data Center/Web Hosting/Transit",
+	"SES": "Search Engine Spider",
+	"RSV": "Reserved",
+}
+
+// initUsageTypes normalizes and validates a list of usage types.
+func initUsageTypes(usageTypes []string) (map[string]struct{}, error) {
+	usageTypeSet := make(map[string]struct{}, len(usageTypes))
+
+	for _, usageType := range usageTypes {
+		normalized := strings.ToUpper(strings.TrimSpace(usageType))
+		if _, ok := knownUsageTypes[normalized]; !ok {
+			return nil, fmt.Errorf("%q is not a known usage type", usageType)
+		}
+		usageTypeSet[normalized] = struct{}{}
+	}
+
+	return usageTypeSet, nil
+}
+
+// initUnknownUsageType validates the action for unknown usage types.
+func initUnknownUsageType(action string) (string, error) {
+	switch strings.ToLower(action) {
+	case "", unknownUsageTypeIgnore:
+		return unknownUsageTypeIgnore, nil
+	case unknownUsageTypeAllow:
+		return unknownUsageTypeAllow, nil
+	case unknownUsageTypeBlock:
+		return unknownUsageTypeBlock, nil
+	default:
+		return "", fmt.Errorf("%q is not a valid action, must be one of %q, %q or %q",
+			action, unknownUsageTypeIgnore, unknownUsageTypeAllow, unknownUsageTypeBlock)
+	}
+}
+
+// LookupUsageType queries the ip2location database for the usage type of a given IP address.
+// Some networks are assigned multiple usage types (e.g. "ISP/MOB"), so a list is returned.
+func (p Plugin) LookupUsageType(ip string) ([]string, error) {
+	record, err := p.db.Get_usagetype(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseUsageType(record.Usagetype)
+}
+
+// parseUsageType splits a usage type as reported by ip2location into its usage types, if any.
+func parseUsageType(value string) ([]string, error) {
+	if strings.HasPrefix(strings.ToLower(value), "invalid") {
+		return nil, errors.New(value)
+	}
+	if strings.HasPrefix(value, ip2locationNotSupported) || value == "-" || value == "" {
+		return nil, nil
+	}
+
+	return strings.Split(value, "/"), nil
+}
@@ -0,0 +1,164 @@
+package traefik_plugin_geoblock
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestInitUsageTypes(t *testing.T) {
+	usageTypes, err := initUsageTypes([]string{"dch", " SES "})
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	for _, usageType := range []string{"DCH", "SES"} {
+		if _, ok := usageTypes[usageType]; !ok {
+			t.Errorf("expected usage type %s to be parsed", usageType)
+		}
+	}
+
+	if _, err = initUsageTypes([]string{"VPN"}); err == nil {
+		t.Error("expected error, but got none")
+	}
+}
+
+func TestInitUnknownUsageType(t *testing.T) {
+	for input, expected := range map[string]string{
+		"":       unknownUsageTypeIgnore,
+		"ignore": unknownUsageTypeIgnore,
+		"Allow":  unknownUsageTypeAllow,
+		"BLOCK":  unknownUsageTypeBlock,
+	} {
+		action, err := initUnknownUsageType(input)
+		if err != nil {
+			t.Errorf("expected no error for %q, but got: %v", input, err)
+		}
+		if action != expected {
+			t.Errorf("expected action %q for %q, but got: %q", expected, input, action)
+		}
+	}
+
+	if _, err := initUnknownUsageType("deny"); err == nil {
+		t.Error("expected error, but got none")
+	}
+}
+
+func TestNew_UsageTypeUnsupportedDatabase(t *testing.T) {
+	cfg := &Config{
+		Enabled:              true,
+		DatabaseFilePath:     dbFilePath,
+		BlockedUsageTypes:    []string{"DCH"},
+		DisallowedStatusCode: http.StatusForbidden,
+	}
+
+	// The DB1 database used for tests does not contain usage types.
+	plugin, err := New(context.TODO(), &noopHandler{}, cfg, pluginName)
+	if err == nil {
+		t.Errorf("expected error, but got none")
+	}
+	if plugin != nil {
+		t.Error("expected plugin to be nil, but is not")
+	}
+}
+
+func TestParseUsageType(t *testing.T) {
+	for input, expected := range map[string][]string{
+		"DCH":                                 {"DCH"},
+		"ISP/MOB":                             {"ISP", "MOB"},
+		"-":                                   nil,
+		"":                                    nil,
+		ip2locationNotSupported + " for DB1.": nil,
+	} {
+		usageTypes, err := parseUsageType(input)
+		if err != nil {
+			t.Errorf("expected no error for %q, but got: %v", input, err)
+		}
+		if !reflect.DeepEqual(usageTypes, expected) {
+			t.Errorf("expected usage types %v for %q, but got: %v", expected, input, usageTypes)
+		}
+	}
+
+	if _, err := parseUsageType("Invalid IP address."); err == nil {
+		t.Error("expected error, but got none")
+	}
+}
+
+func TestUsageTypeCondition(t *testing.T) {
+	for name, test := range map[string]struct {
+		usageTypes     []string
+		expectedAllow  bool
+		expectedReason string
+	}{
+		"Allowed":             {[]string{"SES"}, true, reasonUsageType},
+		"Blocked":             {[]string{"DCH"}, false, reasonUsageType},
+		"CompoundAllowed":     {[]string{"ISP", "MOB"}, true, reasonUsageType},
+		"CompoundBlocked":     {[]string{"CDN", "DCH"}, false, reasonUsageType},
+		"AllowedBeatsBlocked": {[]string{"DCH", "SES"}, true, reasonUsageType},
+		"Other":               {[]string{"COM"}, true, reasonDefault},
+		"Unknown":             {[]string{"VPN"}, false, reasonUsageType},
+		"UnknownAndKnown":     {[]string{"VPN", "COM"}, true, reasonDefault},
+		"Missing":             {nil, false, reasonUsageType},
+	} {
+		t.Run(name, func(t *testing.T) {
+			pol, err := initPolicy(&Config{
+				AllowedUsageTypes: []string{"SES", "MOB"},
+				BlockedUsageTypes: []string{"DCH"},
+				UnknownUsageType:  unknownUsageTypeBlock,
+				DefaultAllow:      true,
+			}, nil, t.Logf)
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+
+			ctx := &evalContext{decision: &Decision{IP: "8.8.8.8", Country: "US"}, usageTypesLoaded: true, usageTypes: test.usageTypes}
+			decision, err := pol.decide(ctx)
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if decision.Allowed != test.expectedAllow || decision.Reason != test.expectedReason {
+				t.Errorf("expected allowed %t by %q, but got: %t by %q", test.expectedAllow, test.expectedReason, decision.Allowed, decision.Reason)
+			}
+		})
+	}
+
+	t.Run("UnknownIgnored", func(t *testing.T) {
+		cond := usageTypeCondition{usageTypes: map[string]struct{}{"DCH": {}}}
+		for _, usageTypes := range [][]string{nil, {"VPN"}} {
+			matched, err := cond.matches(&evalContext{usageTypesLoaded: true, usageTypes: usageTypes})
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			if matched {
+				t.Errorf("expected usage types %v not to match", usageTypes)
+			}
+		}
+	})
+}
+
+func TestPlugin_LookupUsageType(t *testing.T) {
+	cfg := &Config{
+		Enabled:              true,
+		DatabaseFilePath:     dbFilePath,
+		DisallowedStatusCode: http.StatusForbidden,
+	}
+
+	plugin, err := New(context.TODO(), &noopHandler{}, cfg, pluginName)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	// The DB1 database used for tests does not contain usage types.
+	usageTypes, err := plugin.(*Plugin).LookupUsageType("8.8.8.8")
+	if err != nil {
+		t.Errorf("expected no error, but got: %v", err)
+	}
+	if usageTypes != nil {
+		t.Errorf("expected no usage types, but got: %v", usageTypes)
+	}
+
+	if _, err = plugin.(*Plugin).LookupUsageType("foobar"); err == nil {
+		t.Error("expected error, but got none")
+	}
+}
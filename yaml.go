@@ -0,0 +1,400 @@
+package traefik_plugin_geoblock
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// interfaceType is the type of values whose type isn't known upfront.
+var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// yamlLine is a non-empty line of a YAML document, without comments.
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+// yamlScalar is a plain (unquoted) scalar. Its type depends on the value it is decoded into, e.g. 276 is a string when
+// decoded into a list of countries, but a number when decoded into a status code.
+type yamlScalar string
+
+// yamlParser parses the subset of YAML needed for configuration files: block mappings and sequences,
+// flow sequences of scalars (e.g. [DE, AT]), the empty flow mapping {}, comments and plain or quoted scalars.
+// Anchors, multi-line scalars and multiple documents are not supported.
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// decodeYAML parses a YAML document and decodes it into the given pointer, like json.Unmarshal would.
+// Unknown fields are rejected, so typos in configuration files don't go unnoticed.
+func decodeYAML(data []byte, v interface{}) error {
+	doc, err := parseYAML(data)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(resolveYAML(doc, reflect.TypeOf(v).Elem()))
+	if err != nil {
+		return err
+	}
+
+	return decodeJSON(encoded, v)
+}
+
+// decodeJSON decodes a JSON document into the given value, rejecting unknown fields.
+func decodeJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(v)
+}
+
+// parseYAML parses a YAML document into maps, slices and scalars.
+func parseYAML(data []byte) (interface{}, error) {
+	p := &yamlParser{}
+
+	for i, raw := range strings.Split(string(data), "\n") {
+		text := stripYAMLComment(strings.TrimRight(raw, " \t\r"))
+
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || (i == 0 && trimmed == "---") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs can't be used for indentation", i+1)
+		}
+
+		p.lines = append(p.lines, yamlLine{number: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+
+	if len(p.lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+
+	doc, err := p.parseBlock(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].number)
+	}
+
+	return doc, nil
+}
+
+// stripYAMLComment removes a trailing comment from a line, ignoring # within quoted strings.
+func stripYAMLComment(line string) string {
+	var quote byte
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return strings.TrimRight(line[:i], " \t")
+		}
+	}
+
+	return line
+}
+
+// isYAMLSequenceEntry indicates whether a line starts a sequence entry.
+func isYAMLSequenceEntry(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseBlock parses a block mapping or sequence at the given indentation.
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	if isYAMLSequenceEntry(p.lines[p.pos].text) {
+		return p.parseSequence(indent)
+	}
+
+	return p.parseMapping(indent)
+}
+
+// parseSequence parses a block sequence at the given indentation.
+func (p *yamlParser) parseSequence(indent int) ([]interface{}, error) {
+	seq := []interface{}{}
+
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLSequenceEntry(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		rest := strings.TrimLeft(line.text[1:], " ")
+
+		switch {
+		case rest == "":
+			p.pos++
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				item, err := p.parseBlock(p.lines[p.pos].indent)
+				if err != nil {
+					return nil, err
+				}
+				seq = append(seq, item)
+			} else {
+				seq = append(seq, nil)
+			}
+		case isYAMLSequenceEntry(rest) || isYAMLMappingEntry(rest):
+			// NB: the entry's content starts a nested block, e.g. "- name: foo", continued on the following lines.
+			p.lines[p.pos] = yamlLine{number: line.number, indent: line.indent + len(line.text) - len(rest), text: rest}
+			item, err := p.parseBlock(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, item)
+		default:
+			item, err := parseYAMLFlow(rest, line.number)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, item)
+			p.pos++
+		}
+	}
+
+	return seq, nil
+}
+
+// parseMapping parses a block mapping at the given indentation.
+func (p *yamlParser) parseMapping(indent int) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		line := p.lines[p.pos]
+
+		key, rest, ok := splitYAMLMappingEntry(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\", but got %q", line.number, line.text)
+		}
+		key, err := parseYAMLKey(key, line.number)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := m[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %q", line.number, key)
+		}
+		p.pos++
+
+		var value interface{}
+		switch {
+		case rest != "":
+			value, err = parseYAMLFlow(rest, line.number)
+		case p.pos < len(p.lines) && p.lines[p.pos].indent > indent:
+			value, err = p.parseBlock(p.lines[p.pos].indent)
+		case p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLSequenceEntry(p.lines[p.pos].text):
+			// NB: sequences may be indented at the same level as their key.
+			value, err = p.parseSequence(indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].number)
+	}
+
+	return m, nil
+}
+
+// isYAMLMappingEntry indicates whether a line is a mapping entry.
+func isYAMLMappingEntry(text string) bool {
+	_, _, ok := splitYAMLMappingEntry(text)
+	return ok
+}
+
+// splitYAMLMappingEntry splits a mapping entry into its key and value, ignoring colons within quoted keys.
+func splitYAMLMappingEntry(text string) (key, value string, ok bool) {
+	start := 0
+	if text != "" && (text[0] == '"' || text[0] == '\'') {
+		end := strings.IndexByte(text[1:], text[0])
+		if end < 0 {
+			return "", "", false
+		}
+		start = end + 2
+	}
+
+	for i := start; i < len(text); i++ {
+		if text[i] == ':' && (i == len(text)-1 || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+
+	return "", "", false
+}
+
+// parseYAMLKey parses a mapping key.
+func parseYAMLKey(key string, lineNumber int) (string, error) {
+	value, err := parseYAMLScalar(key, lineNumber)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprint(value), nil
+}
+
+// parseYAMLFlow parses a flow sequence of scalars, the empty flow mapping or a scalar.
+func parseYAMLFlow(text string, lineNumber int) (interface{}, error) {
+	switch {
+	case text == "{}":
+		return map[string]interface{}{}, nil
+	case strings.HasPrefix(text, "{"):
+		return nil, fmt.Errorf("line %d: flow mappings are not supported", lineNumber)
+	case !strings.HasPrefix(text, "["):
+		return parseYAMLScalar(text, lineNumber)
+	case !strings.HasSuffix(text, "]"):
+		return nil, fmt.Errorf("line %d: unterminated flow sequence", lineNumber)
+	}
+
+	seq := []interface{}{}
+
+	inner := strings.TrimSpace(text[1 : len(text)-1])
+	if inner == "" {
+		return seq, nil
+	}
+
+	var quote byte
+	start := 0
+	for i := 0; i <= len(inner); i++ {
+		if i < len(inner) {
+			c := inner[i]
+			switch {
+			case quote != 0:
+				if c == '\\' && quote == '"' {
+					i++
+				} else if c == quote {
+					quote = 0
+				}
+				continue
+			case c == '"' || c == '\'':
+				quote = c
+				continue
+			case c == '[' || c == '{':
+				return nil, fmt.Errorf("line %d: nested flow collections are not supported", lineNumber)
+			case c != ',':
+				continue
+			}
+		}
+
+		item, err := parseYAMLScalar(strings.TrimSpace(inner[start:i]), lineNumber)
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, item)
+		start = i + 1
+	}
+
+	return seq, nil
+}
+
+// parseYAMLScalar parses a quoted scalar into a string, and a plain scalar into a yamlScalar.
+func parseYAMLScalar(text string, lineNumber int) (interface{}, error) {
+	if text == "" {
+		return nil, fmt.Errorf("line %d: missing value", lineNumber)
+	}
+
+	switch text[0] {
+	case '"':
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid string %s", lineNumber, text)
+		}
+		return value, nil
+	case '\'':
+		if len(text) < 2 || text[len(text)-1] != '\'' {
+			return nil, fmt.Errorf("line %d: invalid string %s", lineNumber, text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	}
+
+	return yamlScalar(text), nil
+}
+
+// resolveYAML replaces the plain scalars of a parsed document by values of the types they are decoded into, as far as
+// they are known. Fields that are unknown are left for the JSON decoder to reject.
+func resolveYAML(node interface{}, t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch node := node.(type) {
+	case yamlScalar:
+		return resolveYAMLScalar(string(node), t)
+	case map[string]interface{}:
+		for key, value := range node {
+			valueType := interfaceType
+			switch t.Kind() {
+			case reflect.Map:
+				valueType = t.Elem()
+			case reflect.Struct:
+				if field, ok := yamlField(t, key); ok {
+					valueType = field.Type
+				}
+			}
+			node[key] = resolveYAML(value, valueType)
+		}
+	case []interface{}:
+		elemType := interfaceType
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			elemType = t.Elem()
+		}
+		for i, value := range node {
+			node[i] = resolveYAML(value, elemType)
+		}
+	}
+
+	return node
+}
+
+// yamlField returns the struct field a mapping key is decoded into, matched like the JSON decoder does.
+func yamlField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Name
+		if tag, _, _ := strings.Cut(field.Tag.Get("json"), ","); tag != "" {
+			name = tag
+		}
+		if field.IsExported() && strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// resolveYAMLScalar resolves a plain scalar into a string if it is decoded into a string, and otherwise into a
+// number, bool, nil or string, whichever it looks like.
+func resolveYAMLScalar(text string, t reflect.Type) interface{} {
+	switch text {
+	case "null", "Null", "NULL", "~":
+		return nil
+	}
+
+	if t.Kind() == reflect.String {
+		return text
+	}
+
+	switch text {
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+
+	if number, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return number
+	}
+
+	return text
+}
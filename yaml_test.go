@@ -0,0 +1,114 @@
+package traefik_plugin_geoblock
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	var doc interface{}
+	err := decodeYAML([]byte(`---
+# Customer hosts
+example.com:
+  allowedCountries: [ DE, "AT" ] # DACH without CH
+  defaultAllow: false
+  disallowedStatusCode: 404
+"*.example.org":
+  allowedIPBlocks:
+  - 10.0.0.0/8
+  - "192.168.0.0/16"
+'shop#1.example.net':
+  blockedCountries:
+    - RU
+  nested:
+    - name: foo
+      values: []
+    -
+      name: 'it''s'
+empty: {}
+none:
+`), &doc)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	actual, _ := json.Marshal(doc)
+	expected := `{"*.example.org":{"allowedIPBlocks":["10.0.0.0/8","192.168.0.0/16"]},` +
+		`"empty":{},` +
+		`"example.com":{"allowedCountries":["DE","AT"],"defaultAllow":false,"disallowedStatusCode":404},` +
+		`"none":null,` +
+		`"shop#1.example.net":{"blockedCountries":["RU"],"nested":[{"name":"foo","values":[]},{"name":"it's"}]}}`
+	if string(actual) != expected {
+		t.Errorf("expected %s, but got: %s", expected, actual)
+	}
+}
+
+func TestParseYAML_Errors(t *testing.T) {
+	for input, expectedErr := range map[string]string{
+		"foo: bar\n  baz: qux":    "line 2: unexpected indentation",
+		"foo:\n\t- bar":           "line 2: tabs can't be used for indentation",
+		"foo: bar\nfoo: baz":      `line 2: duplicate key "foo"`,
+		"foo: [bar":               "line 1: unterminated flow sequence",
+		"foo: [[bar]]":            "line 1: nested flow collections are not supported",
+		"foo: {bar: baz}":         "line 1: flow mappings are not supported",
+		"foo: \"bar":              `line 1: invalid string "bar`,
+		"foo:\n  - bar\n  baz: 1": "line 3: unexpected indentation",
+		"foo\nbar":                `line 1: expected "key: value", but got "foo"`,
+		"foo:\n  bar: 1\n baz: 2": "line 3: unexpected indentation",
+	} {
+		_, err := parseYAML([]byte(input))
+		if err == nil {
+			t.Errorf("expected error for %q, but got none", input)
+			continue
+		}
+		if err.Error() != expectedErr {
+			t.Errorf("expected error %q for %q, but got: %q", expectedErr, input, err)
+		}
+	}
+}
+
+func TestDecodeYAML(t *testing.T) {
+	var hosts map[string]HostPolicyConfig
+
+	err := decodeYAML([]byte(`example.com:
+  allowedCountries: [ 276, AT, "040" ]
+  blockedCountries:
+    - 643
+  defaultAllow: true
+  disallowedStatusCode: 404
+`), &hosts)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	expected := HostPolicyConfig{
+		AllowedCountries:     []string{"276", "AT", "040"},
+		BlockedCountries:     []string{"643"},
+		DefaultAllow:         true,
+		DisallowedStatusCode: http.StatusNotFound,
+	}
+	if actual := hosts["example.com"]; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %+v, but got: %+v", expected, actual)
+	}
+
+	for name, input := range map[string]string{
+		"InvalidStatusCode": "example.com:\n  disallowedStatusCode: often",
+		"InvalidBool":       "example.com:\n  defaultAllow: 1",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := decodeYAML([]byte(input), &hosts); err == nil {
+				t.Error("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestDecodeYAML_UnknownField(t *testing.T) {
+	var hosts map[string]HostPolicyConfig
+
+	if err := decodeYAML([]byte("example.com:\n  allowedCountry: [DE]"), &hosts); err == nil {
+		t.Error("expected error, but got none")
+	}
+}